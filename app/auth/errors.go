@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"strings"
+	"time"
+)
+
+// Kind groups domain errors by what went wrong, transports map it to their own status codes
+type Kind int
+
+const (
+	KindInvalid         Kind = iota + 1 // the input can't be used
+	KindNotFound                        // the client or code doesn't exist
+	KindConflict                        // the record already exists
+	KindUnauthenticated                 // wrong, expired or used up credentials
+	KindForbidden                       // the client may not do this
+	KindTooManyRequests                 // a limit was hit, RetryAfter says for how long
+)
+
+// Error is a failure the caller can act on. Anything else returned by the service is internal.
+type Error struct {
+	Kind       Kind
+	Message    string
+	RetryAfter time.Duration // set on KindTooManyRequests
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Helper function to report input the service can't use
+func invalid(message string) *Error {
+	return &Error{Kind: KindInvalid, Message: message}
+}
+
+var (
+	ErrClientNotFound    = &Error{Kind: KindNotFound, Message: "Client not found"}
+	ErrClientNotVerified = &Error{Kind: KindUnauthenticated, Message: "Client not verified"}
+	ErrClientInactive    = &Error{Kind: KindUnauthenticated, Message: "Client is not active"}
+	ErrInvalidPassword   = &Error{Kind: KindUnauthenticated, Message: "Invalid password"}
+	ErrAccountExists     = &Error{Kind: KindConflict, Message: "Email or contact already exists"}
+	ErrTaxIDExists       = &Error{Kind: KindConflict, Message: "Tax ID already exists"}
+	ErrAgencyNotFound    = &Error{Kind: KindInvalid, Message: "Invalid agency ID"}
+
+	ErrNotUserOwner = &Error{Kind: KindForbidden, Message: "Only agencies and landlords can manage users"}
+	ErrUserNotFound = &Error{Kind: KindNotFound, Message: "User not found"}
+
+	ErrNotAgency        = &Error{Kind: KindForbidden, Message: "Only agencies can manage landlords"}
+	ErrLandlordNotFound = &Error{Kind: KindNotFound, Message: "Landlord not found"}
+
+	ErrInvitationNotFound = &Error{Kind: KindNotFound, Message: "Invitation not found"}
+	ErrInvitationPending  = &Error{Kind: KindConflict, Message: "An invitation is already pending for this email or contact"}
+	ErrInvitationClosed   = &Error{Kind: KindConflict, Message: "Invitation was already accepted or revoked"}
+	ErrInvitationInvalid  = invalid("Invitation is invalid or has expired")
+
+	ErrNotRoleOwner = &Error{Kind: KindForbidden, Message: "Only agencies and landlords can manage roles"}
+	ErrRoleNotFound = &Error{Kind: KindNotFound, Message: "Role not found"}
+	ErrRoleExists   = &Error{Kind: KindConflict, Message: "A role with this name already exists"}
+
+	ErrOTPNotFound         = &Error{Kind: KindNotFound, Message: "OTP not found"}
+	ErrOTPExpired          = &Error{Kind: KindUnauthenticated, Message: "OTP expired"}
+	ErrOTPInvalid          = &Error{Kind: KindUnauthenticated, Message: "Invalid OTP"}
+	ErrOTPAttemptsExceeded = &Error{Kind: KindUnauthenticated, Message: "Too many failed attempts, request a new OTP"}
+
+	ErrOTPChannelInvalid     = invalid("otp_channel must be sms or email")
+	ErrOTPChannelUnavailable = invalid("Email delivery is not available")
+	ErrOTPPurposeUnsupported = invalid("purpose must be " + strings.Join(utils.OTPPurposes, ", "))
+	ErrLanguageUnsupported   = invalid("preferred_language must be " + strings.Join(notify.Locales, " or "))
+)
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"fmt"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How long an owner has to wait before sending an invitation again
+const inviteResendCooldown = time.Minute
+
+// Invitation statuses, see InvitationStatus
+const (
+	InvitationPending  = "pending"
+	InvitationExpired  = "expired"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+)
+
+// NewInvitation is a staff member an agency or landlord invites, the invitee picks its own password
+type NewInvitation struct {
+	FirstName         string
+	LastName          string
+	Position          string
+	Email             string
+	Contact           string
+	Channel           string // sms (default) or email, the invitation needs the contact or email it picks
+	PreferredLanguage string // the owner's language when empty
+}
+
+// InvitationAcceptance is what the invitee sends with the token
+type InvitationAcceptance struct {
+	Token    string
+	Password string
+	Email    string // used when the invitation has no email
+	Contact  string // used when the invitation has no contact
+}
+
+// InvitationStatus tells whether an invitation can still be accepted
+func InvitationStatus(invitation *models.Invitation, now time.Time) string {
+	switch {
+	case invitation.AcceptedAt != nil:
+		return InvitationAccepted
+	case invitation.RevokedAt != nil:
+		return InvitationRevoked
+	case !now.Before(invitation.ExpiresAt):
+		return InvitationExpired
+	default:
+		return InvitationPending
+	}
+}
+
+// Helper function to load an invitation of the caller's owner, invitations of other owners are not found
+func (s *Service) ownedInvitation(store repository.Store, callerID, invitationID int) (*models.Client, *models.Invitation, error) {
+	owner, err := s.userOwner(store, callerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	invitation, err := store.Invitations().GetByIDForUpdate(invitationID)
+	if err == repository.ErrNotFound || err == nil && invitation.OwnerID != owner.ID {
+		return nil, nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return owner, invitation, nil
+}
+
+// Helper function to build the link sent with an invitation
+func (s *Service) inviteLink(token string) string {
+	if s.InviteURL == "" {
+		return token
+	}
+	link, err := url.Parse(s.InviteURL)
+	if err != nil {
+		return token
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// Helper function to issue a new token for the invitation and queue it to the invitee,
+// the tokens sent before stop working
+func (s *Service) sendInvitation(store repository.Store, owner *models.Client, invitation *models.Invitation, now time.Time) error {
+	token, tokenID, expiresAt, err := middleware.GenerateInviteToken(invitation.ID, now)
+	if err != nil {
+		return fmt.Errorf("failed to generate invite token: %v", err)
+	}
+	if err = store.Invitations().SetToken(invitation.ID, tokenID, expiresAt, now); err != nil {
+		return err
+	}
+	invitation.TokenID, invitation.ExpiresAt, invitation.SentAt = tokenID, expiresAt, now
+
+	tenantID, err := store.Clients().Tenant(owner.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load tenant: %v", err)
+	}
+	subject, message, err := s.renderTenantMessage(store, tenantID, invitation.PreferredLanguage, notify.MessageStaffInvite, notify.TemplateData{
+		Name:           invitation.FirstName,
+		Inviter:        owner.Name,
+		Link:           s.inviteLink(token),
+		ExpiresInHours: int(middleware.InviteTokenTTL.Hours()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render invitation: %v", err)
+	}
+
+	dest := Destination{Channel: invitation.Channel, Contact: invitation.Contact, Email: invitation.Email}
+	if err := s.queueMessage(store, dest, subject, message); err != nil {
+		return fmt.Errorf("failed to queue invitation: %v", err)
+	}
+	return nil
+}
+
+// InviteUser records an invitation of the caller's owner and sends the invitee a single use token to accept it with
+func (s *Service) InviteUser(callerID int, input NewInvitation) (*models.Invitation, error) {
+	owner, err := s.userOwner(s.Store, callerID)
+	if err != nil {
+		return nil, err
+	}
+
+	input.FirstName, input.LastName = strings.TrimSpace(input.FirstName), strings.TrimSpace(input.LastName)
+	if input.FirstName == "" || input.LastName == "" {
+		return nil, invalid("first_name and last_name are required")
+	}
+	if err := s.CheckOTPChannel(input.Channel); err != nil {
+		return nil, err
+	}
+	if input.Channel == "" {
+		input.Channel = notify.ChannelSMS
+	}
+	if input.Channel == notify.ChannelSMS && input.Contact == "" {
+		return nil, invalid("contact is required to invite by sms")
+	}
+	if input.Channel == notify.ChannelEmail && input.Email == "" {
+		return nil, invalid("email is required to invite by email")
+	}
+	if input.PreferredLanguage == "" {
+		input.PreferredLanguage = owner.PreferredLanguage
+	}
+	language, err := PreferredLocale(input.PreferredLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invitation := &models.Invitation{
+		OwnerID:           owner.ID,
+		OwnerType:         string(owner.Type),
+		FirstName:         input.FirstName,
+		LastName:          input.LastName,
+		Position:          input.Position,
+		Email:             input.Email,
+		Contact:           input.Contact,
+		Channel:           input.Channel,
+		PreferredLanguage: language,
+		ExpiresAt:         now,
+		SentAt:            now,
+		CreatedAt:         now,
+	}
+	err = s.Store.InTx(func(store repository.Store) error {
+		// Locking the owner serializes its invitations, so the same person can't be invited twice at once
+		if _, err := store.Clients().GetByIDForUpdate(owner.ID); err != nil {
+			return err
+		}
+		if err := accountAvailable(store, input.Email, input.Contact); err != nil {
+			return err
+		}
+		pending, err := store.Invitations().HasPending(owner.ID, input.Email, input.Contact, now)
+		if err != nil {
+			return err
+		}
+		if pending {
+			return ErrInvitationPending
+		}
+
+		if err := store.Invitations().Create(invitation); err != nil {
+			return err
+		}
+		return s.sendInvitation(store, owner, invitation, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// Helper function to check no client has the email or contact yet
+func accountAvailable(store repository.Store, email, contact string) error {
+	lookups := []struct {
+		get   func(string) (*models.Client, error)
+		value string
+	}{
+		{store.Clients().GetByEmail, email},
+		{store.Clients().GetByContact, contact},
+	}
+	for _, lookup := range lookups {
+		if lookup.value == "" {
+			continue
+		}
+		_, err := lookup.get(lookup.value)
+		if err == nil {
+			return ErrAccountExists
+		}
+		if err != repository.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListInvitations returns the invitations of the caller's owner that were neither accepted nor revoked
+func (s *Service) ListInvitations(callerID int) ([]models.Invitation, error) {
+	owner, err := s.userOwner(s.Store, callerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Invitations().ListPending(owner.ID)
+}
+
+// ResendInvitation sends a pending or expired invitation again with a new token and expiry,
+// the tokens sent before stop working
+func (s *Service) ResendInvitation(callerID, invitationID int) (*models.Invitation, error) {
+	var invitation *models.Invitation
+	now := time.Now()
+	err := s.Store.InTx(func(store repository.Store) error {
+		owner, loaded, err := s.ownedInvitation(store, callerID, invitationID)
+		if err != nil {
+			return err
+		}
+		invitation = loaded
+		if invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+			return ErrInvitationClosed
+		}
+		if wait := inviteResendCooldown - now.Sub(invitation.SentAt); wait > 0 {
+			return &Error{
+				Kind:       KindTooManyRequests,
+				Message:    fmt.Sprintf("Resend not allowed before %s", now.Add(wait).UTC().Format(time.RFC3339)),
+				RetryAfter: wait,
+			}
+		}
+		return s.sendInvitation(store, owner, invitation, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// RevokeInvitation withdraws an invitation of the caller's owner, its token can no longer be accepted
+func (s *Service) RevokeInvitation(callerID, invitationID int) (*models.Invitation, error) {
+	var invitation *models.Invitation
+	err := s.Store.InTx(func(store repository.Store) error {
+		_, loaded, err := s.ownedInvitation(store, callerID, invitationID)
+		if err != nil {
+			return err
+		}
+		invitation = loaded
+		if invitation.AcceptedAt != nil || invitation.RevokedAt != nil {
+			return ErrInvitationClosed
+		}
+		now := time.Now()
+		invitation.RevokedAt = &now
+		return store.Invitations().Revoke(invitation.ID, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation creates the invited user with the password it picked, the token can't be used again.
+// The invitation proves the invitee holds the address it was sent to. When the invitee supplied the
+// other one, the account stays unverified and an activation code is sent there to confirm it.
+func (s *Service) AcceptInvitation(input InvitationAcceptance) (*models.User, error) {
+	if input.Token == "" || input.Password == "" {
+		return nil, invalid("token and password are required")
+	}
+	invitationID, tokenID, err := middleware.ValidateInviteToken(input.Token)
+	if err != nil {
+		return nil, ErrInvitationInvalid
+	}
+
+	var user *models.User
+	now := time.Now()
+	err = s.Store.InTx(func(store repository.Store) error {
+		invitation, err := store.Invitations().GetByIDForUpdate(invitationID)
+		if err == repository.ErrNotFound {
+			return ErrInvitationInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if invitation.TokenID != tokenID || InvitationStatus(invitation, now) != InvitationPending {
+			return ErrInvitationInvalid
+		}
+		owner, err := s.userOwner(store, invitation.OwnerID)
+		if err != nil {
+			return err
+		}
+
+		email, contact := invitation.Email, invitation.Contact
+		var unproven Destination
+		if email == "" {
+			email = input.Email
+			unproven = Destination{Channel: notify.ChannelEmail, Email: email}
+		}
+		if contact == "" {
+			contact = input.Contact
+			unproven = Destination{Channel: notify.ChannelSMS, Contact: contact}
+		}
+		if email == "" || contact == "" {
+			return invalid("email and contact are required, the invitation only has one of them")
+		}
+		if err := s.CheckOTPChannel(unproven.Channel); err != nil {
+			return err
+		}
+		client, err := s.newClient(Registration{
+			Name:              invitation.FirstName + " " + invitation.LastName,
+			Email:             email,
+			Contact:           contact,
+			Password:          input.Password,
+			PreferredLanguage: invitation.PreferredLanguage,
+		}, models.ClientTypeUser, now)
+		if err != nil {
+			return err
+		}
+		if err := store.Clients().Create(client); err != nil {
+			if err == repository.ErrDuplicate {
+				return ErrAccountExists
+			}
+			return err
+		}
+		if unproven.Channel != "" {
+			if err := s.queueOTP(store, client.ID, "activation", notify.MessageActivationOTP, unproven, now); err != nil {
+				return err
+			}
+		} else {
+			if err := store.Clients().SetVerified(client.ID, now); err != nil {
+				return err
+			}
+			client.IsVerified = true
+		}
+
+		user = &models.User{
+			Client:    *client,
+			FirstName: invitation.FirstName,
+			LastName:  invitation.LastName,
+			Position:  invitation.Position,
+			OwnerID:   owner.ID,
+			OwnerType: invitation.OwnerType,
+		}
+		if err := store.Users().Create(user); err != nil {
+			return err
+		}
+		return store.Invitations().MarkAccepted(invitation.ID, client.ID, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
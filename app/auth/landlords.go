@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"strings"
+	"time"
+)
+
+// LandlordUpdate holds the profile fields to change, nil ones are kept
+type LandlordUpdate struct {
+	Name    *string
+	Address *string
+}
+
+// Helper function to load the agency managing landlords
+func (s *Service) landlordAgency(store repository.Store, agencyID int) (*models.Client, error) {
+	agency, err := store.Clients().GetByID(agencyID)
+	if err == repository.ErrNotFound {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if agency.Type != models.ClientTypeAgency {
+		return nil, ErrNotAgency
+	}
+	return agency, nil
+}
+
+// Helper function to load a landlord the agency represents, other landlords are not found
+func (s *Service) agencyLandlord(store repository.Store, agencyID, landlordID int) (*models.Landlord, error) {
+	if _, err := s.landlordAgency(store, agencyID); err != nil {
+		return nil, err
+	}
+	landlord, err := store.Landlords().GetByID(landlordID)
+	if err == repository.ErrNotFound || err == nil && (landlord.AgencyID == nil || *landlord.AgencyID != agencyID) {
+		return nil, ErrLandlordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return landlord, nil
+}
+
+// RegisterAgencyLandlord creates an unverified landlord represented by the agency and sends it an activation code
+func (s *Service) RegisterAgencyLandlord(agencyID int, input LandlordRegistration) (*models.Client, error) {
+	agency, err := s.landlordAgency(s.Store, agencyID)
+	if err != nil {
+		return nil, err
+	}
+	if input.PreferredLanguage == "" {
+		input.PreferredLanguage = agency.PreferredLanguage
+	}
+	input.AgencyID = &agency.ID
+	return s.RegisterLandlord(input)
+}
+
+// ListAgencyLandlords returns the landlords the agency represents
+func (s *Service) ListAgencyLandlords(agencyID int) ([]models.Landlord, error) {
+	if _, err := s.landlordAgency(s.Store, agencyID); err != nil {
+		return nil, err
+	}
+	return s.Store.Landlords().ListByAgency(agencyID)
+}
+
+// GetAgencyLandlord returns a landlord the agency represents
+func (s *Service) GetAgencyLandlord(agencyID, landlordID int) (*models.Landlord, error) {
+	return s.agencyLandlord(s.Store, agencyID, landlordID)
+}
+
+// UpdateAgencyLandlord changes the profile of a landlord the agency represents
+func (s *Service) UpdateAgencyLandlord(agencyID, landlordID int, update LandlordUpdate) (*models.Landlord, error) {
+	var landlord *models.Landlord
+	err := s.Store.InTx(func(store repository.Store) error {
+		var err error
+		if landlord, err = s.agencyLandlord(store, agencyID, landlordID); err != nil {
+			return err
+		}
+
+		if update.Name != nil {
+			landlord.Name = strings.TrimSpace(*update.Name)
+		}
+		if update.Address != nil {
+			landlord.Address = strings.TrimSpace(*update.Address)
+		}
+		if landlord.Name == "" || landlord.Address == "" {
+			return invalid("name and address can't be empty")
+		}
+
+		landlord.Client.Name = landlord.Name
+		landlord.UpdatedAt = time.Now()
+		return store.Landlords().Update(landlord)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return landlord, nil
+}
+
+// SetAgencyLandlordActive activates or deactivates a landlord the agency represents. A deactivated
+// landlord can't sign in and the tokens it was issued stop working.
+func (s *Service) SetAgencyLandlordActive(agencyID, landlordID int, active bool) (*models.Landlord, error) {
+	var landlord *models.Landlord
+	err := s.Store.InTx(func(store repository.Store) error {
+		var err error
+		if landlord, err = s.agencyLandlord(store, agencyID, landlordID); err != nil {
+			return err
+		}
+		landlord.IsActive, landlord.UpdatedAt = active, time.Now()
+		return store.Clients().SetActive(landlord.ID, active, landlord.UpdatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return landlord, nil
+}
+
+// ReleaseAgencyLandlord stops the agency representing the landlord, which keeps its account and
+// becomes an independent landlord
+func (s *Service) ReleaseAgencyLandlord(agencyID, landlordID int) (*models.Landlord, error) {
+	var landlord *models.Landlord
+	err := s.Store.InTx(func(store repository.Store) error {
+		var err error
+		if landlord, err = s.agencyLandlord(store, agencyID, landlordID); err != nil {
+			return err
+		}
+		landlord.AgencyID = nil
+		return store.Landlords().SetAgency(landlord.ID, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return landlord, nil
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"log"
+)
+
+// RenderMessage renders a message in the client's preferred language. The template of the
+// agency or landlord the client belongs to is used when they have one for that language.
+func (s *Service) RenderMessage(store repository.Store, clientID int, kind string, data notify.TemplateData) (string, string, error) {
+	client, err := store.Clients().GetByID(clientID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load client: %v", err)
+	}
+	tenantID, err := store.Clients().Tenant(clientID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load tenant: %v", err)
+	}
+	data.Name = client.Name
+	return s.renderTenantMessage(store, tenantID, client.PreferredLanguage, kind, data)
+}
+
+// Helper function to render a message in a language with the tenant's template when it has one,
+// for recipients that may not have a client record yet. Without a template in that language the
+// tenant's template in its own language is preferred to the built in one, the built in templates
+// fall back to English.
+func (s *Service) renderTenantMessage(store repository.Store, tenantID int, language, kind string, data notify.TemplateData) (string, string, error) {
+	locale := s.Templates.Locale(language)
+	if subject, body, ok, err := s.renderOverride(store, tenantID, kind, locale, data); ok || err != nil {
+		return subject, body, err
+	}
+
+	tenant, err := store.Clients().GetByID(tenantID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load tenant: %v", err)
+	}
+	if tenantLocale := s.Templates.Locale(tenant.PreferredLanguage); tenantLocale != locale {
+		if subject, body, ok, err := s.renderOverride(store, tenantID, kind, tenantLocale, data); ok || err != nil {
+			return subject, body, err
+		}
+	}
+
+	return s.Templates.Render(kind, locale, data)
+}
+
+// Helper function to render the tenant's template of a message type in a locale, ok is false
+// when there is none or it fails to render
+func (s *Service) renderOverride(store repository.Store, tenantID int, kind, locale string, data notify.TemplateData) (subject, body string, ok bool, err error) {
+	override, err := store.Templates().Get(tenantID, kind, locale)
+	if err == repository.ErrNotFound {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to load template: %v", err)
+	}
+
+	subject, body, err = notify.RenderTemplate(notify.Template{Subject: override.Subject, Body: override.Body}, data)
+	if err != nil {
+		// A broken override must not keep codes from going out
+		log.Printf("Template %s/%s of tenant %d failed, using the built in one: %v", kind, locale, tenantID, err)
+		return "", "", false, nil
+	}
+	if subject == "" {
+		subject, _, _ = s.Templates.Render(kind, locale, data)
+	}
+	return subject, body, true, nil
+}
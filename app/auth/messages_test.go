@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"testing"
+)
+
+func TestRenderMessageLocaleFallback(t *testing.T) {
+	data := notify.TemplateData{Code: "123456", ExpiresIn: 10}
+	builtin := func(locale string) string {
+		_, body, err := notify.NewTemplateRegistry().Render(notify.MessageActivationOTP, locale, notify.TemplateData{Name: "Jane", Code: "123456", ExpiresIn: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	tests := []struct {
+		name           string
+		tenantLanguage string
+		clientLanguage string
+		overrides      map[string]string // locale to body
+		wantSubject    string
+		wantBody       string
+	}{
+		{
+			name:           "tenant template in the client's language",
+			tenantLanguage: "en",
+			clientLanguage: "sw",
+			overrides:      map[string]string{"en": "Code {{.Code}}", "sw": "Nambari {{.Code}}"},
+			wantSubject:    "Nambari yako ya uthibitisho",
+			wantBody:       "Nambari 123456",
+		},
+		{
+			name:           "tenant template in the tenant's language",
+			tenantLanguage: "en",
+			clientLanguage: "sw",
+			overrides:      map[string]string{"en": "Code {{.Code}}"},
+			wantSubject:    "Your verification code",
+			wantBody:       "Code 123456",
+		},
+		{
+			name:           "broken template in the client's language",
+			tenantLanguage: "en",
+			clientLanguage: "sw",
+			overrides:      map[string]string{"en": "Code {{.Code}}", "sw": "Nambari {{.Missing}}"},
+			wantSubject:    "Your verification code",
+			wantBody:       "Code 123456",
+		},
+		{
+			name:           "built in template in the client's language",
+			tenantLanguage: "en",
+			clientLanguage: "sw",
+			wantSubject:    "Nambari yako ya uthibitisho",
+			wantBody:       builtin("sw"),
+		},
+		{
+			name:           "broken tenant templates only",
+			tenantLanguage: "sw",
+			clientLanguage: "en",
+			overrides:      map[string]string{"en": "{{template \"missing\"}}", "sw": "{{call .Name}}"},
+			wantSubject:    "Your verification code",
+			wantBody:       builtin("en"),
+		},
+		{
+			name:           "unsupported language",
+			tenantLanguage: "en",
+			clientLanguage: "fr-FR",
+			wantSubject:    "Your verification code",
+			wantBody:       builtin("en"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := repository.NewMemoryStore()
+			s := &Service{Store: store, Templates: notify.NewTemplateRegistry()}
+
+			tenant := &models.Client{Type: models.ClientTypeLandlord, Name: "Acme", Contact: "254700000001", PreferredLanguage: tt.tenantLanguage}
+			client := &models.Client{Type: models.ClientTypeUser, Name: "Jane", Contact: "254700000002", PreferredLanguage: tt.clientLanguage}
+			for _, c := range []*models.Client{tenant, client} {
+				if err := store.Clients().Create(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.Users().Create(&models.User{Client: *client, OwnerID: tenant.ID, OwnerType: string(tenant.Type)}); err != nil {
+				t.Fatal(err)
+			}
+			for locale, body := range tt.overrides {
+				template := &models.MessageTemplate{TenantID: tenant.ID, Type: notify.MessageActivationOTP, Locale: locale, Body: body}
+				if err := store.Templates().Save(template); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			subject, body, err := s.RenderMessage(store, client.ID, notify.MessageActivationOTP, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tt.wantSubject || body != tt.wantBody {
+				t.Fatalf("rendered %q / %q, want %q / %q", subject, body, tt.wantSubject, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"fmt"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"log"
+	"time"
+)
+
+// Codes a client can be sent in 24 hours, over all purposes, before resends are refused
+const maxOTPSendsPerDay = 10
+
+// ApplyOTPPolicyOverride applies an agency's override to a policy
+func ApplyOTPPolicyOverride(policy utils.OTPPolicy, o *models.OTPPolicyOverride) utils.OTPPolicy {
+	if o.Length != nil {
+		policy.Length = *o.Length
+	}
+	if o.Alphabet != nil {
+		policy.Alphabet = *o.Alphabet
+	}
+	if o.TTLSeconds != nil {
+		policy.TTL = time.Duration(*o.TTLSeconds) * time.Second
+	}
+	if o.MaxAttempts != nil {
+		policy.MaxAttempts = *o.MaxAttempts
+	}
+	if o.ResendCooldownSeconds != nil {
+		policy.ResendCooldown = time.Duration(*o.ResendCooldownSeconds) * time.Second
+	}
+	return policy
+}
+
+// BaseOTPPolicy returns the configured policy of a purpose
+func (s *Service) BaseOTPPolicy(purpose string) utils.OTPPolicy {
+	policy, ok := s.OTPPolicies[purpose]
+	if !ok {
+		policy = utils.DefaultOTPPolicy
+	}
+	return policy
+}
+
+// OTPPolicy returns the policy for a client, the configured one with the override of the agency
+// the client belongs to
+func (s *Service) OTPPolicy(store repository.Store, clientID int, purpose string) (utils.OTPPolicy, error) {
+	policy := s.BaseOTPPolicy(purpose)
+
+	tenantID, err := store.Clients().Tenant(clientID)
+	if err != nil {
+		return policy, err
+	}
+	override, err := store.OTPs().GetPolicyOverride(tenantID, purpose)
+	if err != nil {
+		return policy, err
+	}
+	if override != nil {
+		if merged := ApplyOTPPolicyOverride(policy, override); merged.Validate() == nil {
+			policy = merged
+		} else {
+			// The configured policy may have changed since the override was saved
+			log.Printf("OTP policy override %s of tenant %d is invalid, using the configured one", purpose, tenantID)
+		}
+	}
+
+	// Login codes can't outlive the challenge they complete
+	if purpose == "2fa" && policy.TTL > middleware.ChallengeTokenTTL {
+		policy.TTL = middleware.ChallengeTokenTTL
+	}
+	return policy, nil
+}
+
+// CreateOTP generates and stores a code for a purpose under the client's OTP policy
+func (s *Service) CreateOTP(store repository.Store, clientID int, purpose string, now time.Time) (string, utils.OTPPolicy, error) {
+	policy, err := s.OTPPolicy(store, clientID, purpose)
+	if err != nil {
+		return "", policy, fmt.Errorf("failed to load OTP policy: %v", err)
+	}
+
+	otp, err := policy.Generate()
+	if err != nil {
+		return "", policy, err
+	}
+	otpHash, err := utils.HashOTP(clientID, purpose, otp)
+	if err != nil {
+		return "", policy, err
+	}
+
+	code := models.OTPCode{ClientID: clientID, Purpose: purpose, Hash: otpHash, ExpiresAt: now.Add(policy.TTL)}
+	if err = store.OTPs().Create(&code); err != nil {
+		return "", policy, err
+	}
+	return otp, policy, nil
+}
+
+// ConsumeOTP checks a code against the latest unused OTP of a purpose and marks it used on success.
+// A wrong code counts against the stored one, so callers commit on ErrOTPInvalid and ErrOTPAttemptsExceeded.
+// The code is burnt after the number of failures the client's OTP policy allows.
+func (s *Service) ConsumeOTP(store repository.Store, clientID int, purpose, code string) error {
+	policy, err := s.OTPPolicy(store, clientID, purpose)
+	if err != nil {
+		return fmt.Errorf("failed to load OTP policy: %v", err)
+	}
+
+	stored, err := store.OTPs().LatestUnused(clientID, purpose)
+	if err == repository.ErrNotFound {
+		return ErrOTPNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return ErrOTPExpired
+	}
+
+	ok, err := utils.CheckOTP(stored.Hash, clientID, purpose, policy.Normalize(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		failedAttempts := stored.FailedAttempts + 1
+		burnt := failedAttempts >= policy.MaxAttempts
+		if err = store.OTPs().RecordFailure(stored.ID, failedAttempts, burnt); err != nil {
+			return err
+		}
+		if burnt {
+			return ErrOTPAttemptsExceeded
+		}
+		return ErrOTPInvalid
+	}
+
+	return store.OTPs().MarkUsed(stored.ID)
+}
+
+// Helper function to consume a code and run fn in the same transaction. A wrong code is
+// committed without running fn, so the failed attempt counts.
+func (s *Service) withOTP(clientID int, purpose, code string, fn func(store repository.Store) error) error {
+	var otpErr error
+	err := s.Store.InTx(func(store repository.Store) error {
+		otpErr = s.ConsumeOTP(store, clientID, purpose, code)
+		switch otpErr {
+		case nil:
+			return fn(store)
+		case ErrOTPInvalid, ErrOTPAttemptsExceeded:
+			return nil
+		default:
+			return otpErr
+		}
+	})
+	if err == nil {
+		err = otpErr
+	}
+	return err
+}
+
+// Helper function to queue a message for delivery with the transaction, on the chosen channel
+// with the other one as fallback when the client has it and it is configured
+func (s *Service) queueMessage(store repository.Store, dest Destination, subject, text string) error {
+	if err := s.CheckOTPChannel(dest.Channel); err != nil {
+		return err
+	}
+
+	message := notify.Message{Subject: subject, Body: text}
+	if dest.Channel == notify.ChannelEmail {
+		message.Channel, message.Recipient = notify.ChannelEmail, dest.Email
+		if dest.Contact != "" {
+			message.FallbackChannel, message.FallbackRecipient = notify.ChannelSMS, dest.Contact
+		}
+	} else {
+		message.Channel, message.Recipient = notify.ChannelSMS, dest.Contact
+		if dest.Email != "" && s.EmailEnabled {
+			message.FallbackChannel, message.FallbackRecipient = notify.ChannelEmail, dest.Email
+		}
+	}
+
+	return store.Outbox().Enqueue(message)
+}
+
+// Helper function to generate a code for a purpose and queue the message of type kind with it
+func (s *Service) queueOTP(store repository.Store, clientID int, purpose, kind string, dest Destination, now time.Time) error {
+	otp, policy, err := s.CreateOTP(store, clientID, purpose, now)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s OTP: %v", purpose, err)
+	}
+
+	subject, message, err := s.RenderMessage(store, clientID, kind, notify.TemplateData{Code: otp, ExpiresIn: int(policy.TTL.Minutes())})
+	if err != nil {
+		return fmt.Errorf("failed to render %s OTP: %v", purpose, err)
+	}
+	if err := s.queueMessage(store, dest, subject, message); err != nil {
+		return fmt.Errorf("failed to queue %s OTP: %v", purpose, err)
+	}
+	return nil
+}
+
+// Resend is a code sent by ResendOTP
+type Resend struct {
+	Client *models.Client
+	Policy utils.OTPPolicy
+	// Code is only set for 2fa, login codes skip the outbox and are delivered by the caller
+	Code string
+	// NextResendAt is when the cooldown and the daily cap allow the next code
+	NextResendAt time.Time
+}
+
+// ResendOTP sends a new code for a purpose and invalidates the earlier ones. Resends are limited by
+// the cooldown of the client's policy and a daily cap, an *Error of KindTooManyRequests says how long to wait.
+func (s *Service) ResendOTP(clientID int, purpose, channel string) (*Resend, error) {
+	switch purpose {
+	case "activation", "reset":
+		if err := s.CheckOTPChannel(channel); err != nil {
+			return nil, err
+		}
+	case "2fa":
+	default:
+		return nil, ErrOTPPurposeUnsupported
+	}
+
+	resend := &Resend{}
+	now := time.Now()
+	err := s.Store.InTx(func(store repository.Store) error {
+		// Locking the client serializes concurrent resends, so the limits can't be raced
+		client, err := store.Clients().GetByIDForUpdate(clientID)
+		if err == repository.ErrNotFound {
+			return ErrClientNotFound
+		}
+		if err != nil {
+			return err
+		}
+		resend.Client = client
+
+		switch {
+		case purpose == "activation" && client.IsVerified:
+			return invalid("Client already verified")
+		case purpose == "2fa" && client.MFAMethod != "sms":
+			return invalid("SMS two-factor authentication is not enabled")
+		case purpose != "activation" && !client.IsActive:
+			return &Error{Kind: KindForbidden, Message: "Account is inactive"}
+		}
+
+		if resend.Policy, err = s.checkResend(store, client.ID, purpose, now); err != nil {
+			return err
+		}
+
+		if err = store.OTPs().InvalidateUnused(client.ID, purpose); err != nil {
+			return fmt.Errorf("failed to invalidate previous codes: %v", err)
+		}
+
+		dest := Destination{Channel: channel, Contact: client.Contact, Email: client.Email}
+		switch purpose {
+		case "activation":
+			err = s.queueOTP(store, client.ID, purpose, notify.MessageActivationOTP, dest, now)
+		case "reset":
+			err = s.queueOTP(store, client.ID, purpose, notify.MessageResetOTP, dest, now)
+		default:
+			resend.Code, resend.Policy, err = s.CreateOTP(store, client.ID, purpose, now)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The code just sent counts towards the limits
+		wait, err := resendWait(store, client.ID, purpose, resend.Policy.ResendCooldown)
+		if err != nil {
+			return err
+		}
+		resend.NextResendAt = now.Add(wait).Truncate(time.Second)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resend, nil
+}
+
+// Helper function to refuse another code for purpose until the cooldown and daily cap allow it, an *Error
+// of KindTooManyRequests says how long to wait. Lock the client first, so concurrent sends can't race the limits.
+func (s *Service) checkResend(store repository.Store, clientID int, purpose string, now time.Time) (utils.OTPPolicy, error) {
+	policy, err := s.OTPPolicy(store, clientID, purpose)
+	if err != nil {
+		return policy, err
+	}
+	wait, err := resendWait(store, clientID, purpose, policy.ResendCooldown)
+	if err != nil {
+		return policy, err
+	}
+	if wait > 0 {
+		retryAt := now.Add(wait).Truncate(time.Second)
+		return policy, &Error{
+			Kind:       KindTooManyRequests,
+			Message:    fmt.Sprintf("Resend not allowed before %s", retryAt.UTC().Format(time.RFC3339)),
+			RetryAfter: wait,
+		}
+	}
+	return policy, nil
+}
+
+// resendWait returns how long the client has to wait before another code for purpose may be sent,
+// at least cooldown after the last one
+func resendWait(store repository.Store, clientID int, purpose string, cooldown time.Duration) (time.Duration, error) {
+	var wait time.Duration
+
+	sinceLast, sent, err := store.OTPs().SinceLast(clientID, purpose)
+	if err != nil {
+		return 0, err
+	}
+	if sent {
+		if remaining := cooldown - sinceLast; remaining > wait {
+			wait = remaining
+		}
+	}
+
+	// Once the cap is reached, a slot frees up when the maxOTPSendsPerDay-th newest code is a day old
+	capAge, capped, err := store.OTPs().SinceNth(clientID, maxOTPSendsPerDay, 24*time.Hour)
+	if err != nil {
+		return 0, err
+	}
+	if capped {
+		if daily := 24*time.Hour - capAge; daily > wait {
+			wait = daily
+		}
+	}
+
+	return wait, nil
+}
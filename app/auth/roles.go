@@ -0,0 +1,290 @@
+package auth
+
+import (
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Longest role name the roles table takes
+const maxRoleName = 100
+
+// NewRole is a role an agency or landlord defines for its users
+type NewRole struct {
+	Name        string
+	Description string
+	Permissions []string // names from the permission catalog
+}
+
+// RoleUpdate holds the role fields to change, nil ones are kept. Permissions replaces the whole set.
+type RoleUpdate struct {
+	Name        *string
+	Description *string
+	Permissions *[]string
+}
+
+// Helper function to load the client managing roles for the caller, the same clients that own users
+func (s *Service) roleOwner(store repository.Store, callerID int) (*models.Client, error) {
+	owner, err := s.userOwner(store, callerID)
+	if err == ErrNotUserOwner {
+		return nil, ErrNotRoleOwner
+	}
+	return owner, err
+}
+
+// Helper function to load a role of the caller's owner, roles of other owners are not found
+func (s *Service) ownedRole(store repository.Store, callerID, roleID int) (*models.Role, error) {
+	owner, err := s.roleOwner(store, callerID)
+	if err != nil {
+		return nil, err
+	}
+	role, err := store.Roles().GetByID(roleID)
+	if err == repository.ErrNotFound || err == nil && role.OwnerID != owner.ID {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// Helper function to check a role name
+func roleName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", invalid("name is required")
+	}
+	if len(name) > maxRoleName {
+		return "", invalid("name is too long")
+	}
+	return name, nil
+}
+
+// Helper function to check permission names against the catalog, duplicates are dropped
+func permissionNames(store repository.Store, names []string) ([]string, error) {
+	unique := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+
+	known, err := store.Roles().PermissionsByName(unique)
+	if err != nil {
+		return nil, err
+	}
+	if len(known) != len(unique) {
+		found := make(map[string]bool, len(known))
+		for _, permission := range known {
+			found[permission.Name] = true
+		}
+		for _, name := range unique {
+			if !found[name] {
+				return nil, invalid("Unknown permission: " + name)
+			}
+		}
+	}
+	sort.Strings(unique)
+	return unique, nil
+}
+
+// ListPermissions returns the permission catalog roles are built from
+func (s *Service) ListPermissions() ([]models.Permission, error) {
+	return s.Store.Roles().Permissions()
+}
+
+// CreateRole defines a role of the caller's owner
+func (s *Service) CreateRole(callerID int, input NewRole) (*models.Role, error) {
+	var role *models.Role
+	err := s.Store.InTx(func(store repository.Store) error {
+		owner, err := s.roleOwner(store, callerID)
+		if err != nil {
+			return err
+		}
+		name, err := roleName(input.Name)
+		if err != nil {
+			return err
+		}
+		permissions, err := permissionNames(store, input.Permissions)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		role = &models.Role{
+			Name:        name,
+			Description: strings.TrimSpace(input.Description),
+			OwnerID:     owner.ID,
+			OwnerType:   string(owner.Type),
+			Permissions: permissions,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := store.Roles().Create(role); err != nil {
+			if err == repository.ErrDuplicate {
+				return ErrRoleExists
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// ListRoles returns the roles of the caller's owner
+func (s *Service) ListRoles(callerID int) ([]models.Role, error) {
+	owner, err := s.roleOwner(s.Store, callerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Roles().ListByOwner(owner.ID)
+}
+
+// GetRole returns a role of the caller's owner
+func (s *Service) GetRole(callerID, roleID int) (*models.Role, error) {
+	return s.ownedRole(s.Store, callerID, roleID)
+}
+
+// UpdateRole changes a role of the caller's owner, the users holding it get the new permissions
+func (s *Service) UpdateRole(callerID, roleID int, update RoleUpdate) (*models.Role, error) {
+	var role *models.Role
+	err := s.Store.InTx(func(store repository.Store) error {
+		var err error
+		if role, err = s.ownedRole(store, callerID, roleID); err != nil {
+			return err
+		}
+
+		if update.Name != nil {
+			if role.Name, err = roleName(*update.Name); err != nil {
+				return err
+			}
+		}
+		if update.Description != nil {
+			role.Description = strings.TrimSpace(*update.Description)
+		}
+		if update.Permissions != nil {
+			if role.Permissions, err = permissionNames(store, *update.Permissions); err != nil {
+				return err
+			}
+		}
+
+		role.UpdatedAt = time.Now()
+		if err := store.Roles().Update(role); err != nil {
+			if err == repository.ErrDuplicate {
+				return ErrRoleExists
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// DeleteRole removes a role of the caller's owner, the users holding it lose it
+func (s *Service) DeleteRole(callerID, roleID int) error {
+	return s.Store.InTx(func(store repository.Store) error {
+		if _, err := s.ownedRole(store, callerID, roleID); err != nil {
+			return err
+		}
+		return store.Roles().Delete(roleID)
+	})
+}
+
+// UserRoles returns the roles assigned to a user of the caller's owner
+func (s *Service) UserRoles(callerID, userID int) ([]models.Role, error) {
+	if _, err := s.ownedUser(s.Store, callerID, userID); err != nil {
+		if err == ErrNotUserOwner {
+			return nil, ErrNotRoleOwner
+		}
+		return nil, err
+	}
+	return s.Store.Roles().UserRoles(userID)
+}
+
+// SetUserRoles replaces the roles of a user of the caller's owner, every role must belong to that owner
+func (s *Service) SetUserRoles(callerID, userID int, roleIDs []int) ([]models.Role, error) {
+	var roles []models.Role
+	err := s.Store.InTx(func(store repository.Store) error {
+		if _, err := s.ownedUser(store, callerID, userID); err != nil {
+			if err == ErrNotUserOwner {
+				return ErrNotRoleOwner
+			}
+			return err
+		}
+		for _, roleID := range roleIDs {
+			if _, err := s.ownedRole(store, callerID, roleID); err != nil {
+				return err
+			}
+		}
+
+		if err := store.Roles().SetUserRoles(userID, roleIDs); err != nil {
+			return err
+		}
+		var err error
+		roles, err = store.Roles().UserRoles(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// ClientPermissions returns what a client may do in its tenant. Agencies and landlords hold
+// middleware.AllPermissions, users the permissions of their roles.
+func (s *Service) ClientPermissions(client *models.Client) ([]string, error) {
+	switch client.Type {
+	case models.ClientTypeAgency, models.ClientTypeLandlord:
+		return []string{middleware.AllPermissions}, nil
+	case models.ClientTypeUser:
+		return s.Store.Roles().UserPermissions(client.ID)
+	default:
+		return []string{}, nil
+	}
+}
+
+// ResolvePermissions implements middleware.PermissionResolver, unknown clients hold no permissions
+func (s *Service) ResolvePermissions(clientID uint) ([]string, error) {
+	client, err := s.Store.Clients().GetByID(int(clientID))
+	if err == repository.ErrNotFound {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.ClientPermissions(client)
+}
+
+// TokenSubject describes a client for the access tokens issued to it, with its permissions
+// when they are embedded in tokens
+func (s *Service) TokenSubject(clientID int) (middleware.Subject, error) {
+	client, err := s.Store.Clients().GetByID(clientID)
+	if err == repository.ErrNotFound {
+		return middleware.Subject{}, ErrClientNotFound
+	}
+	if err != nil {
+		return middleware.Subject{}, err
+	}
+
+	subject := middleware.Subject{ClientID: uint(client.ID), Role: string(client.Type)}
+	if client.Type == models.ClientTypeUser {
+		subject.UserID = uint(client.ID)
+	}
+	if middleware.EmbedPermissions {
+		if subject.Permissions, err = s.ClientPermissions(client); err != nil {
+			return middleware.Subject{}, err
+		}
+	}
+	return subject, nil
+}
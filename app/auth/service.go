@@ -0,0 +1,281 @@
+// Package auth holds the account logic behind the HTTP handlers: registration, verification,
+// login and password resets. Failures the caller can act on are returned as *Error.
+package auth
+
+import (
+	"fmt"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+type Service struct {
+	Store        repository.Store
+	Templates    *notify.TemplateRegistry
+	OTPPolicies  map[string]utils.OTPPolicy // by purpose, utils.DefaultOTPPolicy for the ones missing
+	EmailEnabled bool                       // whether codes can be sent by email
+	InviteURL    string                     // page invitees accept invitations on, the token is added as ?token=
+}
+
+// Registration holds what every account needs
+type Registration struct {
+	Name              string
+	Email             string
+	Contact           string
+	Password          string
+	OTPChannel        string // sms (default) or email
+	PreferredLanguage string // en (default) or sw
+}
+
+type AgencyRegistration struct {
+	Registration
+	Address string
+	TaxID   string
+	LogoURL string
+}
+
+type LandlordRegistration struct {
+	Registration
+	Address  string
+	AgencyID *int // the agency managing the landlord, if any
+}
+
+// Destination is where a code goes, Channel picks Contact or Email and the other is the fallback
+type Destination struct {
+	Channel string
+	Contact string
+	Email   string
+}
+
+// PreferredLocale checks a preferred language, an empty one means the default
+func PreferredLocale(language string) (string, error) {
+	if language == "" {
+		return notify.DefaultLocale, nil
+	}
+	for _, locale := range notify.Locales {
+		if strings.EqualFold(language, locale) {
+			return locale, nil
+		}
+	}
+	return "", ErrLanguageUnsupported
+}
+
+// CheckOTPChannel checks a requested OTP channel before anything is written
+func (s *Service) CheckOTPChannel(channel string) error {
+	switch channel {
+	case "", notify.ChannelSMS:
+		return nil
+	case notify.ChannelEmail:
+		if !s.EmailEnabled {
+			return ErrOTPChannelUnavailable
+		}
+		return nil
+	default:
+		return ErrOTPChannelInvalid
+	}
+}
+
+// Helper function to check the fields every account needs and build its client record
+func (s *Service) newClient(input Registration, clientType models.ClientType, now time.Time) (*models.Client, error) {
+	if input.Name == "" || input.Contact == "" || input.Password == "" || input.Email == "" {
+		return nil, invalid("Name, Email, contact and password are required")
+	}
+	if err := s.CheckOTPChannel(input.OTPChannel); err != nil {
+		return nil, err
+	}
+	language, err := PreferredLocale(input.PreferredLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to secure password: %v", err)
+	}
+
+	return &models.Client{
+		Name:              input.Name,
+		Contact:           input.Contact,
+		Email:             input.Email,
+		UUID:              generateUUID(),
+		Type:              clientType,
+		Password:          string(hashedPassword),
+		PreferredLanguage: language,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}, nil
+}
+
+// Helper function to create the client record and its profile, then queue the activation code
+func (s *Service) register(client *models.Client, channel string, createProfile func(store repository.Store) error) error {
+	return s.Store.InTx(func(store repository.Store) error {
+		if err := store.Clients().Create(client); err != nil {
+			if err == repository.ErrDuplicate {
+				return ErrAccountExists
+			}
+			return err
+		}
+		if err := createProfile(store); err != nil {
+			return err
+		}
+
+		dest := Destination{Channel: channel, Contact: client.Contact, Email: client.Email}
+		return s.queueOTP(store, client.ID, "activation", notify.MessageActivationOTP, dest, client.CreatedAt)
+	})
+}
+
+// RegisterAgency creates an unverified agency account and sends it an activation code
+func (s *Service) RegisterAgency(input AgencyRegistration) (*models.Client, error) {
+	client, err := s.newClient(input.Registration, models.ClientTypeAgency, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.register(client, input.OTPChannel, func(store repository.Store) error {
+		agency := models.Agency{
+			Client:  models.Client{ID: client.ID},
+			Name:    input.Name,
+			Address: input.Address,
+			TaxID:   input.TaxID,
+			LogoURL: input.LogoURL,
+		}
+		if err := store.Agencies().Create(&agency); err != nil {
+			if err == repository.ErrDuplicate {
+				return ErrTaxIDExists
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// RegisterLandlord creates an unverified landlord account and sends it an activation code
+func (s *Service) RegisterLandlord(input LandlordRegistration) (*models.Client, error) {
+	client, err := s.newClient(input.Registration, models.ClientTypeLandlord, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if input.Address == "" {
+		return nil, invalid("Address is required for landlords")
+	}
+	if input.AgencyID != nil {
+		exists, err := s.Store.Agencies().Exists(*input.AgencyID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrAgencyNotFound
+		}
+	}
+
+	err = s.register(client, input.OTPChannel, func(store repository.Store) error {
+		return store.Landlords().Create(&models.Landlord{
+			Client:   models.Client{ID: client.ID},
+			Name:     input.Name,
+			Address:  input.Address,
+			AgencyID: input.AgencyID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Verify activates a client with the code sent when it registered
+func (s *Service) Verify(clientID int, code string) error {
+	return s.withOTP(clientID, "activation", code, func(store repository.Store) error {
+		return store.Clients().SetVerified(clientID, time.Now())
+	})
+}
+
+// Login checks a client's contact and password. Issuing tokens, or a challenge for the
+// second factor, is left to the caller.
+func (s *Service) Login(contact, password string) (*models.Client, error) {
+	client, err := s.Store.Clients().GetByContact(contact)
+	if err == repository.ErrNotFound {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.IsVerified {
+		return nil, ErrClientNotVerified
+	}
+	if !client.IsActive {
+		return nil, ErrClientInactive
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(client.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidPassword
+	}
+
+	return client, nil
+}
+
+// RequestReset sends a password reset code, by SMS when the contact is given or by email when the email is
+func (s *Service) RequestReset(contact, email string) error {
+	// The identifier given picks the channel the code goes to
+	lookup, identifier, channel := s.Store.Clients().GetByContact, contact, notify.ChannelSMS
+	if contact == "" {
+		lookup, identifier, channel = s.Store.Clients().GetByEmail, email, notify.ChannelEmail
+	}
+	if identifier == "" {
+		return invalid("Contact or email is required")
+	}
+	if err := s.CheckOTPChannel(channel); err != nil {
+		return err
+	}
+
+	client, err := lookup(identifier)
+	if err == repository.ErrNotFound {
+		return ErrClientNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// The code is sent by the outbox dispatcher once the transaction commits
+	return s.Store.InTx(func(store repository.Store) error {
+		// Locking the client serializes concurrent requests, so the resend limits can't be raced
+		if _, err := store.Clients().GetByIDForUpdate(client.ID); err != nil {
+			return err
+		}
+		now := time.Now()
+		if _, err := s.checkResend(store, client.ID, "reset", now); err != nil {
+			return err
+		}
+
+		dest := Destination{Channel: channel, Contact: client.Contact, Email: client.Email}
+		return s.queueOTP(store, client.ID, "reset", notify.MessageResetOTP, dest, now)
+	})
+}
+
+// ResetPassword sets a new password with the code sent by RequestReset
+func (s *Service) ResetPassword(clientID int, code, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to secure password: %v", err)
+	}
+
+	return s.withOTP(clientID, "reset", code, func(store repository.Store) error {
+		return store.Clients().SetPassword(clientID, string(hashedPassword), time.Now())
+	})
+}
+
+// Helper function to generate UUID
+func generateUUID() string {
+	// TODO: Use a proper UUID library like github.com/google/uuid
+	// return uuid.New().String()
+
+	// Temporary placeholder - replace with proper UUID generation
+	return fmt.Sprintf("client-%d", time.Now().UnixNano())
+}
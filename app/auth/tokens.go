@@ -0,0 +1,23 @@
+package auth
+
+import (
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+)
+
+// IsRevoked implements middleware.RevocationStore, a token is revoked when its jti is on the
+// denylist or it was issued before the client's cutoff
+func (s *Service) IsRevoked(claims *middleware.Claims) (bool, error) {
+	tokens := s.Store.Tokens()
+	if claims.ID != "" {
+		revoked, err := tokens.IsAccessTokenRevoked(claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	invalidBefore, err := tokens.InvalidBefore(int(claims.ClientID))
+	if err != nil {
+		return false, err
+	}
+	return invalidBefore != nil && claims.IssuedAt != nil && claims.IssuedAt.Time.Before(*invalidBefore), nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"strings"
+	"time"
+)
+
+// NewUser is a staff account an agency or landlord creates under its own tenant
+type NewUser struct {
+	FirstName         string
+	LastName          string
+	Position          string
+	Email             string
+	Contact           string
+	Password          string
+	OTPChannel        string // sms (default) or email
+	PreferredLanguage string // the owner's language when empty
+}
+
+// UserUpdate holds the profile fields to change, nil ones are kept
+type UserUpdate struct {
+	FirstName *string
+	LastName  *string
+	Position  *string
+}
+
+// Helper function to load the client managing users for the caller, only agencies and landlords own
+// users. Users act for the agency or landlord they belong to.
+func (s *Service) userOwner(store repository.Store, callerID int) (*models.Client, error) {
+	owner, err := store.Clients().GetByID(callerID)
+	if err == nil && owner.Type == models.ClientTypeUser {
+		var ownerID int
+		if ownerID, err = store.Clients().Tenant(callerID); err == nil {
+			owner, err = store.Clients().GetByID(ownerID)
+		}
+	}
+	if err == repository.ErrNotFound {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner.Type != models.ClientTypeAgency && owner.Type != models.ClientTypeLandlord {
+		return nil, ErrNotUserOwner
+	}
+	return owner, nil
+}
+
+// Helper function to load a user of the caller's owner, users of other owners are not found
+func (s *Service) ownedUser(store repository.Store, callerID, userID int) (*models.User, error) {
+	owner, err := s.userOwner(store, callerID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := store.Users().GetByID(userID)
+	if err == repository.ErrNotFound || err == nil && user.OwnerID != owner.ID {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser creates an unverified user of the caller's owner and sends it an activation code
+func (s *Service) CreateUser(callerID int, input NewUser) (*models.User, error) {
+	owner, err := s.userOwner(s.Store, callerID)
+	if err != nil {
+		return nil, err
+	}
+	input.FirstName, input.LastName = strings.TrimSpace(input.FirstName), strings.TrimSpace(input.LastName)
+	if input.FirstName == "" || input.LastName == "" || input.Email == "" || input.Contact == "" || input.Password == "" {
+		return nil, invalid("first_name, last_name, email, contact and password are required")
+	}
+	if input.PreferredLanguage == "" {
+		input.PreferredLanguage = owner.PreferredLanguage
+	}
+
+	client, err := s.newClient(Registration{
+		Name:              input.FirstName + " " + input.LastName,
+		Email:             input.Email,
+		Contact:           input.Contact,
+		Password:          input.Password,
+		OTPChannel:        input.OTPChannel,
+		PreferredLanguage: input.PreferredLanguage,
+	}, models.ClientTypeUser, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Position:  input.Position,
+		OwnerID:   owner.ID,
+		OwnerType: string(owner.Type),
+	}
+	err = s.register(client, input.OTPChannel, func(store repository.Store) error {
+		user.Client = *client
+		return store.Users().Create(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListUsers returns the users of the caller's owner
+func (s *Service) ListUsers(callerID int) ([]models.User, error) {
+	owner, err := s.userOwner(s.Store, callerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Users().ListByOwner(owner.ID)
+}
+
+// GetUser returns a user of the caller's owner
+func (s *Service) GetUser(callerID, userID int) (*models.User, error) {
+	return s.ownedUser(s.Store, callerID, userID)
+}
+
+// UpdateUser changes the profile of a user of the caller's owner
+func (s *Service) UpdateUser(callerID, userID int, update UserUpdate) (*models.User, error) {
+	var user *models.User
+	err := s.Store.InTx(func(store repository.Store) error {
+		var err error
+		if user, err = s.ownedUser(store, callerID, userID); err != nil {
+			return err
+		}
+
+		if update.FirstName != nil {
+			user.FirstName = strings.TrimSpace(*update.FirstName)
+		}
+		if update.LastName != nil {
+			user.LastName = strings.TrimSpace(*update.LastName)
+		}
+		if update.Position != nil {
+			user.Position = *update.Position
+		}
+		if user.FirstName == "" || user.LastName == "" {
+			return invalid("first_name and last_name can't be empty")
+		}
+
+		user.Name = user.FirstName + " " + user.LastName
+		user.UpdatedAt = time.Now()
+		return store.Users().Update(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetUserActive activates or deactivates a user of the caller's owner. A deactivated user can't sign in
+// and the tokens it was issued stop working.
+func (s *Service) SetUserActive(callerID, userID int, active bool) (*models.User, error) {
+	var user *models.User
+	err := s.Store.InTx(func(store repository.Store) error {
+		var err error
+		if user, err = s.ownedUser(store, callerID, userID); err != nil {
+			return err
+		}
+		user.IsActive, user.UpdatedAt = active, time.Now()
+		return store.Clients().SetActive(user.ID, active, user.UpdatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser removes a user of the caller's owner with its client record
+func (s *Service) DeleteUser(callerID, userID int) error {
+	return s.Store.InTx(func(store repository.Store) error {
+		if _, err := s.ownedUser(store, callerID, userID); err != nil {
+			return err
+		}
+		return store.Clients().Delete(userID)
+	})
+}
@@ -1,14 +1,11 @@
 package controllers
 
 import (
-	"fmt"
-	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
 	"github.com/kimoresteve/identity-service/app/utils"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
-	"strings"
-	"time"
 )
 
 // Updated input models }
@@ -40,113 +37,76 @@ func (c *Controller) RegisterAgency(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields for agency
-	if input.Name == "" || input.Contact == "" || input.Password == "" || input.Email == "" {
-		http.Error(w, "Name, Email, contact and password are required", http.StatusBadRequest)
-		return
-	}
-
-	//if input.Address == "" || input.TaxID == "" {
-	//	http.Error(w, "Address and Tax ID are required for agencies", http.StatusBadRequest)
-	//	return
-	//}
-
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	client, err := c.Auth.RegisterAgency(auth.AgencyRegistration{
+		Registration: auth.Registration{
+			Name:              input.Name,
+			Email:             input.Email,
+			Contact:           input.Contact,
+			Password:          input.Password,
+			OTPChannel:        input.OTPChannel,
+			PreferredLanguage: input.PreferredLanguage,
+		},
+		Address: input.Address,
+		TaxID:   input.TaxID,
+		LogoURL: input.LogoURL,
+	})
 	if err != nil {
-		http.Error(w, "Failed to secure password", http.StatusInternalServerError)
+		writeAuthError(w, err, "Failed to complete registration")
 		return
 	}
 
-	// Begin database transaction
-	tx, err := c.DB.Begin()
-	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-		return
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	now := time.Now()
-	uuid := c.generateUUID()
-
-	// Create base client record
-	client := models.Client{
-		Name:      input.Name,
-		Contact:   input.Contact,
-		Email:     input.Email,
-		UUID:      uuid,
-		Type:      "agency",
-		Password:  string(hashedPassword),
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-
-	result, err := tx.Exec(`
-        INSERT INTO clients (name, contact, email, password, type, uuid, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		client.Name, client.Contact, client.Email,
-		client.Password, client.Type, client.UUID,
-		client.CreatedAt, client.UpdatedAt)
-
-	if err != nil {
-		if strings.Contains(err.Error(), "duplicate") {
-			http.Error(w, "Email or contact already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, "Failed to create agency account", http.StatusInternalServerError)
-		fmt.Printf("Failed to create agency account: %v\n", err)
-		return
-	}
-
-	clientID, err := result.LastInsertId()
-	if err != nil {
-		http.Error(w, "Failed to get client ID", http.StatusInternalServerError)
-		return
-	}
-
-	// Insert agency-specific data
-	_, err = tx.Exec(`
-        INSERT INTO agencies (id, name, address, tax_id, logo_url)
-        VALUES (?, ?, ?, ?, ?)`,
-		clientID, input.Name, input.Address, input.TaxID, input.LogoURL)
-
-	if err != nil {
-		if strings.Contains(err.Error(), "duplicate") && strings.Contains(err.Error(), "tax_id") {
-			http.Error(w, "Tax ID already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, "Failed to create agency profile", http.StatusInternalServerError)
-		return
-	}
-
-	// Generate and send OTP
-	if err := c.sendOTP(tx, clientID, input.Contact, now); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to complete registration", http.StatusInternalServerError)
-		return
-	}
-
-	c.sendSuccessResponse(w, clientID, client.Name, client.Contact, "Agency created successfully")
+	c.sendSuccessResponse(w, int64(client.ID), client.Name, client.Contact, "Agency created successfully")
 }
 
+// RegisterLandlordAgency creates a landlord represented by the calling agency.
+// @Summary Agency Landlord Registration
+// @Description Registers a landlord on behalf of the agency in the token. The landlord gets an activation code and signs in once verified.
+// @Tags Agency
+// @Accept json
+// @Produce json
+// @Param landlord body AgencyLandlordInput true "Landlord Registration Input"
+// @Success 201 {object} map[string]interface{} "Landlord created successful"
+// @Failure 400 {string} string "Invalid request or missing fields"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 409 {string} string "Email or contact already exists"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/register/agency/landlord [post]
 func (c *Controller) RegisterLandlordAgency(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	var input LandlordInput
+	agencyID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input AgencyLandlordInput
 	if err := utils.DecodeAndValidateJSONBody(w, r, &input); err != nil {
 		var apiErr *utils.APIError
 		if errors.As(err, &apiErr) {
 			http.Error(w, apiErr.Message, apiErr.Status)
 		}
+		return
+	}
+
+	client, err := c.Auth.RegisterAgencyLandlord(int(agencyID), auth.LandlordRegistration{
+		Registration: auth.Registration{
+			Name:              input.Name,
+			Email:             input.Email,
+			Contact:           input.Contact,
+			Password:          input.Password,
+			OTPChannel:        input.OTPChannel,
+			PreferredLanguage: input.PreferredLanguage,
+		},
+		Address: input.Address,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to complete registration")
+		return
 	}
+
+	c.sendSuccessResponse(w, int64(client.ID), client.Name, client.Contact, "Landlord created successfully")
 }
@@ -1,17 +1,13 @@
 package controllers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
+	"github.com/gorilla/mux"
 	middleware "github.com/kimoresteve/identity-service/app/middlewares"
 	"github.com/kimoresteve/identity-service/app/models"
-	"github.com/kimoresteve/identity-service/app/utils"
-	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
 	"strconv"
-	"time"
 )
 
 // Status returns the health status of the API server.
@@ -53,57 +49,8 @@ func (c *Controller) Verify(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	tx, err := c.DB.Begin()
-	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-		return
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	var dbOtp string
-	var expiresAt time.Time
-
-	err = tx.QueryRow(`
-		SELECT otp, expires_at 
-		FROM otp_codes 
-		WHERE client_id = ? AND purpose = 'activation'
-		ORDER BY expires_at DESC 
-		LIMIT 1
-	`, body.ClientID).Scan(&dbOtp, &expiresAt)
-
-	if err != nil {
-		http.Error(w, "OTP not found", http.StatusNotFound)
-		return
-	}
-	if dbOtp != body.OTP {
-		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
-		return
-	}
-	if time.Now().After(expiresAt) {
-		http.Error(w, "OTP expired", http.StatusUnauthorized)
-		return
-	}
-	_, err = tx.Exec(`
-		UPDATE clients 
-		SET is_verified = 1, updated_at = ? 
-		WHERE id = ?
-	`, time.Now(), body.ClientID)
-
-	if err != nil {
-		http.Error(w, "Failed to verify client", http.StatusInternalServerError)
-		return
-	}
-	_, err = tx.Exec(`DELETE FROM otp_codes WHERE client_id = ?`, body.ClientID)
-	if err != nil {
-		http.Error(w, "Failed to delete OTP", http.StatusInternalServerError)
-		return
-	}
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to commit verification", http.StatusInternalServerError)
+	if err := c.Auth.Verify(body.ClientID, body.OTP); err != nil {
+		writeAuthError(w, err, "Failed to verify client")
 		return
 	}
 	response := models.Response{
@@ -117,14 +64,15 @@ func (c *Controller) Verify(w http.ResponseWriter, r *http.Request) {
 
 // Login logs a landlord into the system.
 // @Summary Client login
-// @Description Logs in a client by validating contact and password
+// @Description Logs in a client by validating contact and password. Clients with two-factor authentication get a challenge token to complete at /auth/login/verify-2fa.
 // @Tags Client
 // @Accept json
 // @Produce json
 // @Param credentials body models.LoginInput true "Login credentials"
-// @Success 200 {object} models.Response "Login successful with token"
+// @Success 200 {object} models.Response "Login successful with access and refresh token, or mfa_required with a challenge token"
 // @Failure 401 {string} string "Invalid credentials or unverified landlord"
-// @Failure 404 {string} string "Landlord not found"
+// @Failure 404 {string} string "Client not found"
+// @Failure 429 {string} string "Second factor locked or login code requested too often"
 // @Router /auth/login [post]
 func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -134,91 +82,64 @@ func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 	var input models.LoginInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-	}
-
-	defer r.Body.Close()
-
-	tx, err := c.DB.Begin()
-	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 		return
 	}
-	defer tx.Rollback()
 
-	row := tx.QueryRow(`SELECT id, name, contact, type, email, password, created_at, updated_at, is_verified FROM clients WHERE contact = ?`, input.Contact)
+	defer r.Body.Close()
 
-	var landlord models.Client
-	err = row.Scan(&landlord.ID, &landlord.Name, &landlord.Contact, &landlord.Type, &landlord.Email, &landlord.Password, &landlord.CreatedAt, &landlord.UpdatedAt, &landlord.IsVerified)
+	landlord, err := c.Auth.Login(input.Contact, input.Password)
 	if err != nil {
-		http.Error(w, "Landlord not found", http.StatusNotFound)
-		return
-	}
-
-	if !landlord.IsVerified {
-		http.Error(w, "Landlord not verified", http.StatusUnauthorized)
-		return
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(landlord.Password), []byte(input.Password)); err != nil {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
-		return
-	}
-
-	if err := tx.Commit(); err != nil {
-		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		writeAuthError(w, err, "Database error")
 		return
 	}
 
-	token, err := middleware.GenerateJWT(uint(landlord.ID))
-	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+	// Accounts with a second factor get a challenge instead of tokens
+	if landlord.MFAMethod != "" && landlord.MFAMethod != "none" {
+		c.sendMFAChallenge(w, landlord)
 		return
 	}
 
-	response := models.Response{
-		Success: true,
-		Message: "Login successful",
-		Data: map[string]interface{}{
-			"id":      landlord.ID,
-			"name":    landlord.Name,
-			"contact": landlord.Contact,
-			"token":   token,
-		},
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-
+	c.completeLogin(w, landlord)
 }
 
-// GenerateToken creates a token for client
+// GenerateToken creates a new access token for the signed in client
 // @Summary Generate Token
 // @Tags Client
 // @Accept json
 // @Produce json
-// @Param client_id query uint true "Client ID"
+// @Param id path uint true "Client ID, must be the caller's own"
 // @Success 200 {object} models.Response "Token sent successfully"
 // @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Tokens can only be generated for the caller"
 // @Failure 404 {string} string "Client not found"
-// @Router /auth/get-token [get]
+// @Router /auth/get-token/{id} [get]
 func (c *Controller) GenerateToken(w http.ResponseWriter, r *http.Request) {
-
-	clientIDStr := r.URL.Query().Get("id")
-	if clientIDStr == "" {
-		http.Error(w, "client_id parameter is required", http.StatusBadRequest)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	// Convert clientID to uint
-	clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
+	clientID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
 	if err != nil {
 		http.Error(w, "Invalid client_id format", http.StatusBadRequest)
 		return
 	}
+	if uint(clientID) != callerID {
+		http.Error(w, "Tokens can only be generated for the caller", http.StatusForbidden)
+		return
+	}
 
 	// Generate JWT token
-	token, err := middleware.GenerateJWT(uint(clientID))
+	token, err := c.accessToken(int(clientID), "")
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeAuthError(w, err, "Failed to generate token")
 		return
 	}
 
@@ -227,8 +148,8 @@ func (c *Controller) GenerateToken(w http.ResponseWriter, r *http.Request) {
 		Success: true,
 		Message: "Token generated successfully",
 		Data: map[string]interface{}{
-			"token": token,
-			//"expires_in": int(middleware.TokenTTL.Seconds()),
+			"token":      token,
+			"expires_in": int(middleware.TokenTTL.Seconds()),
 		},
 	}
 
@@ -239,14 +160,15 @@ func (c *Controller) GenerateToken(w http.ResponseWriter, r *http.Request) {
 
 // ForgotPassword sends a reset OTP code to the Client.
 // @Summary Forgot password
-// @Description Sends an OTP to the client to reset password
+// @Description Sends an OTP to the client to reset password, by SMS when the contact is given or by email when the email is
 // @Tags Client
 // @Accept json
 // @Produce json
-// @Param forgotPassword body models.ForgotPasswordInput true "Contact Information"
+// @Param forgotPassword body models.ForgotPasswordInput true "Contact or email"
 // @Success 200 {object} models.Response "OTP sent successfully"
 // @Failure 400 {string} string "Invalid request payload"
 // @Failure 404 {string} string "Client not found"
+// @Failure 429 {string} string "Code requested too often"
 // @Router /auth/forgot-password [post]
 func (c *Controller) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -255,78 +177,14 @@ func (c *Controller) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var body models.ForgotPasswordInput
-	now := time.Now()
-
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 
-	// Start transaction
-	tx, err := c.DB.Begin()
-	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-		return
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Find client by contact
-	row := tx.QueryRow(`SELECT id, name, contact, email FROM clients WHERE contact = ?`, body.Contact)
-
-	var result struct {
-		ID      int    `json:"id"`
-		Name    string `json:"name"`
-		Contact string `json:"contact"`
-		Email   string `json:"email"`
-	}
-
-	err = row.Scan(&result.ID, &result.Name, &result.Contact, &result.Email)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Client not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Database error", http.StatusInternalServerError)
-		}
-		return
-	}
-
-	// Generate OTP
-	otp, err := utils.GenerateOTP()
-	if err != nil {
-		http.Error(w, "Failed to generate OTP", http.StatusInternalServerError)
-		return
-	}
-
-	// Insert OTP (still within the same transaction)
-	expiresAt := now.Add(15 * time.Minute)
-	_, err = tx.Exec(`
-        INSERT INTO otp_codes (client_id, otp, expires_at, purpose)
-        VALUES (?, ?, ?, ?)`,
-		result.ID, otp, expiresAt, "reset")
-
-	if err != nil {
-		http.Error(w, "Failed to store OTP", http.StatusInternalServerError)
-		fmt.Printf("Error storing OTP: %s", err.Error())
-		return
-	}
-
-	// Send SMS
-	message := fmt.Sprintf("Hello, Your Reset Code is %s. It expires in 15 minutes.", otp)
-	if err := utils.SendSmsCode(result.Contact, message); err != nil {
-		http.Error(w, "Failed to send OTP", http.StatusInternalServerError)
-		fmt.Printf("Error sending SMS: %s", err.Error())
-		return
-	}
-
-	// Commit transaction only at the end
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to complete operation", http.StatusInternalServerError)
-		fmt.Printf("Error committing transaction: %s", err.Error())
+	if err := c.Auth.RequestReset(body.Contact, body.Email); err != nil {
+		writeAuthError(w, err, "Failed to send OTP")
 		return
 	}
 
@@ -362,57 +220,12 @@ func (c *Controller) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	var body models.ResetPasswordInput
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-	}
-	defer r.Body.Close()
-
-	tx, err := c.DB.Begin()
-	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-	}
-	defer tx.Rollback()
-
-	var dbOtp string
-	var expiresAt time.Time
-
-	err = tx.QueryRow(`
-		SELECT otp, expires_at 
-		FROM otp_codes 
-		WHERE client_id = ? AND purpose = 'reset'
-		ORDER BY expires_at DESC 
-		LIMIT 1
-	`, body.ID).Scan(&dbOtp, &expiresAt)
-
-	if err != nil {
-		http.Error(w, "OTP not found", http.StatusNotFound)
-		return
-	}
-	if dbOtp != body.OTP {
-		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
-		return
-	}
-	if time.Now().After(expiresAt) {
-		http.Error(w, "OTP expired", http.StatusUnauthorized)
-		return
-	}
-
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Failed to secure password", http.StatusInternalServerError)
 		return
 	}
+	defer r.Body.Close()
 
-	_, err = tx.Exec(`UPDATE clients SET password = ?, updated = ?,  WHERE id = ? `, hashedPassword, time.Now(), body.ID)
-	if err != nil {
-		http.Error(w, "Failed to update password", http.StatusInternalServerError)
-	}
-
-	_, err = tx.Exec(`DELETE FROM otp_codes WHERE client_id = ?`, body.ID)
-	if err != nil {
-		http.Error(w, "Failed to delete OTP", http.StatusInternalServerError)
-		return
-	}
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to commit verification", http.StatusInternalServerError)
+	if err := c.Auth.ResetPassword(body.ID, body.OTP, body.Password); err != nil {
+		writeAuthError(w, err, "Failed to update password")
 		return
 	}
 
@@ -422,53 +235,43 @@ func (c *Controller) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(response)
 
-}
-func (c *Controller) AddUser(w http.ResponseWriter, r *http.Request) {
-
 }
 
 type AgencyInput struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Contact  string `json:"contact"`
-	Password string `json:"password"`
-	Address  string `json:"address"`
-	TaxID    string `json:"tax_id"`
-	LogoURL  string `json:"logo_url,omitempty"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Contact    string `json:"contact"`
+	Password   string `json:"password"`
+	Address    string `json:"address"`
+	TaxID      string `json:"tax_id"`
+	LogoURL    string `json:"logo_url,omitempty"`
+	OTPChannel string `json:"otp_channel,omitempty"` // sms (default) or email
+
+	PreferredLanguage string `json:"preferred_language,omitempty"` // en (default) or sw
 }
 
 type LandlordInput struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Contact  string `json:"contact"`
-	Password string `json:"password"`
-	Address  string `json:"address"`
-	AgencyID *int   `json:"agency_id,omitempty"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Contact    string `json:"contact"`
+	Password   string `json:"password"`
+	Address    string `json:"address"`
+	AgencyID   *int   `json:"agency_id,omitempty"`
+	OTPChannel string `json:"otp_channel,omitempty"` // sms (default) or email
+
+	PreferredLanguage string `json:"preferred_language,omitempty"` // en (default) or sw
 }
 
-// Helper function to generate and send OTP
-func (c *Controller) sendOTP(tx *sql.Tx, clientID int64, contact string, now time.Time) error {
-	otp, err := utils.GenerateOTP()
-	if err != nil {
-		return fmt.Errorf("Failed to generate OTP")
-	}
+// AgencyLandlordInput is a landlord an agency registers, the agency is the caller
+type AgencyLandlordInput struct {
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Contact    string `json:"contact"`
+	Password   string `json:"password"`
+	Address    string `json:"address"`
+	OTPChannel string `json:"otp_channel,omitempty"` // sms (default) or email
 
-	expiresAt := now.Add(15 * time.Minute)
-	_, err = tx.Exec(`
-        INSERT INTO otp_codes (client_id, otp, expires_at)
-        VALUES (?, ?, ?)`,
-		clientID, otp, expiresAt)
-
-	if err != nil {
-		return fmt.Errorf("Failed to store OTP")
-	}
-
-	message := fmt.Sprintf("Hello, Your OTP is %s. It expires in 15 minutes.", otp)
-	if err := utils.SendSmsCode(contact, message); err != nil {
-		return fmt.Errorf("Failed to send OTP")
-	}
-
-	return nil
+	PreferredLanguage string `json:"preferred_language,omitempty"` // the agency's language when omitted
 }
 
 // Helper function to send success response
@@ -489,12 +292,3 @@ func (c *Controller) sendSuccessResponse(w http.ResponseWriter, clientID int64,
 		log.Printf("Failed to encode response: %v", err)
 	}
 }
-
-// Helper function to generate UUID
-func (c *Controller) generateUUID() string {
-	// TODO: Use a proper UUID library like github.com/google/uuid
-	// return uuid.New().String()
-
-	// Temporary placeholder - replace with proper UUID generation
-	return fmt.Sprintf("client-%d", time.Now().UnixNano())
-}
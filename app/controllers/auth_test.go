@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestForgotPasswordCooldown(t *testing.T) {
+	c, _ := newTestController(t)
+	createTestClient(t, c, "254700000301")
+
+	input := models.ForgotPasswordInput{Contact: "254700000301"}
+	responseData(t, serve(t, http.HandlerFunc(c.ForgotPassword), "/auth/forgot-password", input, ""))
+
+	rec := serve(t, http.HandlerFunc(c.ForgotPassword), "/auth/forgot-password", input, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("second request status = %d, want 429 with Retry-After", rec.Code)
+	}
+}
+
+func TestGenerateTokenOnlyForCaller(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000302")
+	other := createTestClient(t, c, "254700000303")
+	token := login(t, c, "254700000302")["token"].(string)
+
+	generate := func(id int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/auth/get-token/%d", id), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(id)})
+
+		rec := httptest.NewRecorder()
+		middleware.JWTMiddleware(http.HandlerFunc(c.GenerateToken)).ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := generate(other.ID); rec.Code != http.StatusForbidden {
+		t.Fatalf("status for another client = %d, want 403", rec.Code)
+	}
+	if data := responseData(t, generate(client.ID)); data["token"] == nil {
+		t.Fatalf("no token generated: %v", data)
+	}
+}
@@ -1,10 +1,62 @@
 package controllers
 
 import (
-	"database/sql"
+	"errors"
+	"github.com/kimoresteve/identity-service/app/auth"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 type Controller struct {
-	DB *sql.DB // or whatever your database type is
+	Store repository.Store
+	SMS   notify.SMSSender
+	Email notify.EmailSender // nil when email delivery is not configured
+
+	Auth *auth.Service // registration, verification, login and OTPs over Store
 	// Add other dependencies as needed (mailer, logger, etc.)
 }
+
+// Helper function to answer an error returned from a store transaction. APIErrors carry their
+// own message and status, anything else is logged and answered with fallback.
+func writeAPIError(w http.ResponseWriter, err error, fallback string) {
+	var apiErr *utils.APIError
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.Message, apiErr.Status)
+		return
+	}
+	log.Printf("%s: %v", fallback, err)
+	http.Error(w, fallback, http.StatusInternalServerError)
+}
+
+// Helper function to answer an error returned from the auth service. Its domain errors are
+// answered with their message and the status of their kind, anything else as writeAPIError does.
+func writeAuthError(w http.ResponseWriter, err error, fallback string) {
+	var authErr *auth.Error
+	if !errors.As(err, &authErr) {
+		writeAPIError(w, err, fallback)
+		return
+	}
+
+	status := http.StatusInternalServerError
+	switch authErr.Kind {
+	case auth.KindInvalid:
+		status = http.StatusBadRequest
+	case auth.KindNotFound:
+		status = http.StatusNotFound
+	case auth.KindConflict:
+		status = http.StatusConflict
+	case auth.KindUnauthenticated:
+		status = http.StatusUnauthorized
+	case auth.KindForbidden:
+		status = http.StatusForbidden
+	case auth.KindTooManyRequests:
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(int(authErr.RetryAfter.Round(time.Second).Seconds())))
+	}
+	http.Error(w, authErr.Message, status)
+}
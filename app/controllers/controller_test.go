@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The handlers run on the memory store, the middlewares package needs JWT_SECRET to be set
+
+const testPassword = "correct horse battery staple"
+
+// newTestController returns a controller on an empty memory store with texts kept by the recorder
+func newTestController(t *testing.T) (*Controller, *notify.Recorder) {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	service := &auth.Service{Store: store, Templates: notify.NewTemplateRegistry()}
+	middleware.SetRevocationStore(service)
+	middleware.SetPermissionResolver(service)
+
+	sms := &notify.Recorder{}
+	return &Controller{Store: store, SMS: sms, Auth: service}, sms
+}
+
+// createTestClient stores a verified landlord client that signs in with testPassword
+func createTestClient(t *testing.T, c *Controller, contact string) *models.Client {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	client := &models.Client{
+		UUID:      "uuid-" + contact,
+		Type:      models.ClientTypeLandlord,
+		Name:      "Test Landlord",
+		Contact:   contact,
+		Password:  string(hash),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := c.Store.Clients().Create(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store.Clients().SetVerified(client.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// serve sends body as JSON to the handler, with the access token when one is given
+func serve(t *testing.T, handler http.Handler, target string, body interface{}, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, target, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// send sends body as JSON to the handler with the client's access token and the path variables
+func send(t *testing.T, c *Controller, handler http.Handler, method, target string, vars map[string]string, body interface{}, clientID int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	token, err := c.accessToken(clientID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// idVars returns the path variables of a route taking an id
+func idVars(id int) map[string]string {
+	return map[string]string{"id": strconv.Itoa(id)}
+}
+
+// responseData decodes the data of a successful response
+func responseData(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+
+	if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 200 or 201: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Success bool                   `json:"success"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success {
+		t.Fatalf("response not successful: %s", rec.Body.String())
+	}
+	return response.Data
+}
+
+// login signs the client in with testPassword and returns the response data
+func login(t *testing.T, c *Controller, contact string) map[string]interface{} {
+	t.Helper()
+	rec := serve(t, http.HandlerFunc(c.Login), "/auth/login", models.LoginInput{Contact: contact, Password: testPassword}, "")
+	return responseData(t, rec)
+}
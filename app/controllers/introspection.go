@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Introspect reports whether a token is active (RFC 7662).
+// @Summary Token introspection
+// @Description Lets service clients check access and refresh tokens they cannot verify themselves. Inactive, unknown and revoked tokens all return active false.
+// @Tags OIDC
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} map[string]interface{} "Token status"
+// @Failure 401 {object} map[string]string "Service client authentication failed"
+// @Router /oauth/introspect [post]
+func (c *Controller) Introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid request payload")
+		return
+	}
+	if _, err := c.authenticateServiceClient(r); err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// The hint only decides which kind of token is looked up first
+	lookups := []func(string) (map[string]interface{}, error){introspectAccessToken, c.introspectRefreshToken}
+	if r.PostForm.Get("token_type_hint") == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	response := map[string]interface{}{"active": false}
+	for _, lookup := range lookups {
+		result, err := lookup(token)
+		if err != nil {
+			log.Printf("Failed to introspect token: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to introspect token")
+			return
+		}
+		if result != nil {
+			response = result
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// Revoke revokes an access or refresh token (RFC 7009).
+// @Summary Token revocation
+// @Description Lets service clients revoke access tokens and refresh token families. Unknown tokens are ignored.
+// @Tags OIDC
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {string} string "Token revoked or unknown"
+// @Failure 401 {object} map[string]string "Service client authentication failed"
+// @Router /oauth/revoke [post]
+func (c *Controller) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid request payload")
+		return
+	}
+	if _, err := c.authenticateServiceClient(r); err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	err := c.Store.InTx(func(store repository.Store) error {
+		if claims, err := middleware.ValidateToken(token); err == nil {
+			return revokeAccessToken(store, claims)
+		}
+
+		// Revoking a refresh token ends the whole session it belongs to
+		stored, err := store.Tokens().GetRefreshToken(middleware.HashRefreshToken(token))
+		if err == repository.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return store.Tokens().RevokeRefreshFamily(stored.FamilyID, time.Now())
+	})
+	if err != nil {
+		log.Printf("Failed to revoke token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to revoke token")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// introspectAccessToken returns the claims of a valid, unrevoked access token or nil
+func introspectAccessToken(token string) (map[string]interface{}, error) {
+	claims, err := middleware.ValidateToken(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	revoked, err := middleware.IsTokenRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, nil
+	}
+
+	result := map[string]interface{}{
+		"active":     true,
+		"token_type": "access_token",
+		"client_id":  claims.ClientID,
+		"jti":        claims.ID,
+		"iss":        claims.Issuer,
+		"sub":        claims.Subject,
+	}
+	if claims.UserID != 0 {
+		result["user_id"] = claims.UserID
+	}
+	if claims.Role != "" {
+		result["role"] = claims.Role
+	}
+	if claims.Service != "" {
+		result["service"] = claims.Service
+	}
+	if claims.Scope != "" {
+		result["scope"] = claims.Scope
+	}
+	if claims.Permissions != "" {
+		result["perms"] = claims.Permissions
+	}
+	if claims.ExpiresAt != nil {
+		result["exp"] = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		result["iat"] = claims.IssuedAt.Unix()
+	}
+	return result, nil
+}
+
+// introspectRefreshToken returns the details of an unrevoked, unexpired refresh token or nil
+func (c *Controller) introspectRefreshToken(token string) (map[string]interface{}, error) {
+	stored, err := c.Store.Tokens().GetRefreshToken(middleware.HashRefreshToken(token))
+	if err == repository.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, nil
+	}
+
+	client, err := c.Store.Clients().GetByID(stored.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsActive {
+		return nil, nil
+	}
+
+	result := map[string]interface{}{
+		"active":     true,
+		"token_type": "refresh_token",
+		"client_id":  stored.ClientID,
+		"exp":        stored.ExpiresAt.Unix(),
+		"iat":        stored.CreatedAt.Unix(),
+	}
+	if stored.Scope != "" {
+		result["scope"] = stored.Scope
+	}
+	return result, nil
+}
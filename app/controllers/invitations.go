@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Helper function to describe an invitation in a response, with whether it can still be accepted
+func invitationData(invitation *models.Invitation, now time.Time) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":                 invitation.ID,
+		"first_name":         invitation.FirstName,
+		"last_name":          invitation.LastName,
+		"position":           invitation.Position,
+		"email":              invitation.Email,
+		"contact":            invitation.Contact,
+		"channel":            invitation.Channel,
+		"preferred_language": invitation.PreferredLanguage,
+		"owner_id":           invitation.OwnerID,
+		"owner_type":         invitation.OwnerType,
+		"status":             auth.InvitationStatus(invitation, now),
+		"expires_at":         invitation.ExpiresAt,
+		"sent_at":            invitation.SentAt,
+		"created_at":         invitation.CreatedAt,
+	}
+	if invitation.AcceptedAt != nil {
+		data["accepted_at"] = invitation.AcceptedAt
+		data["user_id"] = invitation.UserID
+	}
+	if invitation.RevokedAt != nil {
+		data["revoked_at"] = invitation.RevokedAt
+	}
+	return data
+}
+
+// Helper function to read the caller and the invitation id from the path
+func invitationRequest(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	invitationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation id", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return int(callerID), invitationID, true
+}
+
+// Helper function to answer with a single invitation
+func writeInvitation(w http.ResponseWriter, status int, message string, invitation *models.Invitation) {
+	response := models.Response{
+		Success: true,
+		Message: message,
+		Data:    invitationData(invitation, time.Now()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// InviteUser invites a staff member to the agency or landlord.
+// @Summary Invite a user
+// @Description Sends the invitee a single use link by SMS or email. Accepting it creates the user with the password the invitee picks.
+// @Tags Invitations
+// @Accept json
+// @Produce json
+// @Param invitation body models.InvitationInput true "Invitation"
+// @Success 201 {object} models.Response "Invitation sent"
+// @Failure 400 {string} string "Invalid request or missing fields"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 409 {string} string "Account exists or an invitation is already pending"
+// @Router /auth/invitations [post]
+func (c *Controller) InviteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input models.InvitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	invitation, err := c.Auth.InviteUser(int(callerID), auth.NewInvitation{
+		FirstName:         input.FirstName,
+		LastName:          input.LastName,
+		Position:          input.Position,
+		Email:             input.Email,
+		Contact:           input.Contact,
+		Channel:           input.Channel,
+		PreferredLanguage: input.PreferredLanguage,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to send invitation")
+		return
+	}
+
+	writeInvitation(w, http.StatusCreated, "Invitation sent", invitation)
+}
+
+// ListInvitations returns the invitations of the agency or landlord that were neither accepted nor revoked.
+// @Summary List pending invitations
+// @Tags Invitations
+// @Produce json
+// @Success 200 {object} models.Response "Invitations"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Router /auth/invitations [get]
+func (c *Controller) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitations, err := c.Auth.ListInvitations(int(callerID))
+	if err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	now := time.Now()
+	list := make([]map[string]interface{}, 0, len(invitations))
+	for i := range invitations {
+		list = append(list, invitationData(&invitations[i], now))
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Invitations",
+		Data: map[string]interface{}{
+			"invitations": list,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResendInvitation sends an invitation again with a new link, the earlier links stop working.
+// @Summary Resend an invitation
+// @Tags Invitations
+// @Produce json
+// @Param id path int true "Invitation ID"
+// @Success 200 {object} models.Response "Invitation sent"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "Invitation not found"
+// @Failure 409 {string} string "Invitation was already accepted or revoked"
+// @Failure 429 {string} string "Resent too recently, Retry-After says how long to wait"
+// @Router /auth/invitations/{id}/resend [post]
+func (c *Controller) ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, invitationID, ok := invitationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	invitation, err := c.Auth.ResendInvitation(callerID, invitationID)
+	if err != nil {
+		writeAuthError(w, err, "Failed to send invitation")
+		return
+	}
+
+	writeInvitation(w, http.StatusOK, "Invitation sent", invitation)
+}
+
+// RevokeInvitation withdraws a pending invitation, its link stops working.
+// @Summary Revoke an invitation
+// @Tags Invitations
+// @Produce json
+// @Param id path int true "Invitation ID"
+// @Success 200 {object} models.Response "Invitation revoked"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "Invitation not found"
+// @Failure 409 {string} string "Invitation was already accepted or revoked"
+// @Router /auth/invitations/{id} [delete]
+func (c *Controller) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, invitationID, ok := invitationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	invitation, err := c.Auth.RevokeInvitation(callerID, invitationID)
+	if err != nil {
+		writeAuthError(w, err, "Failed to revoke invitation")
+		return
+	}
+
+	writeInvitation(w, http.StatusOK, "Invitation revoked", invitation)
+}
+
+// AcceptInvitation creates the invited user with the password it picked.
+// @Summary Accept an invitation
+// @Description Public endpoint taking the token from the invitation. When the invitation had both email and contact the user is verified and can sign in right away, otherwise an activation code is sent to the address the invitee added.
+// @Tags Invitations
+// @Accept json
+// @Produce json
+// @Param invitation body models.AcceptInvitationInput true "Invite token and password"
+// @Success 201 {object} models.Response "Invitation accepted"
+// @Failure 400 {string} string "Invalid, expired or used invitation, or missing fields"
+// @Failure 409 {string} string "Email or contact already exists"
+// @Router /auth/invitations/accept [post]
+func (c *Controller) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input models.AcceptInvitationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := c.Auth.AcceptInvitation(auth.InvitationAcceptance{
+		Token:    input.Token,
+		Password: input.Password,
+		Email:    input.Email,
+		Contact:  input.Contact,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to accept invitation")
+		return
+	}
+
+	message := "Invitation accepted"
+	if !user.IsVerified {
+		message = "Invitation accepted, verify the code sent to activate the account"
+	}
+	writeUser(w, http.StatusCreated, message, user)
+}
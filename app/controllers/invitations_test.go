@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// inviteToken invites a staff member of the owner and returns a token to accept the invitation with
+func inviteToken(t *testing.T, c *Controller, owner *models.Client, input auth.NewInvitation) string {
+	t.Helper()
+
+	invitation, err := c.Auth.InviteUser(owner.ID, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, tokenID, expiresAt, err := middleware.GenerateInviteToken(invitation.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store.Invitations().SetToken(invitation.ID, tokenID, expiresAt, now); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAcceptInvitationVerification(t *testing.T) {
+	c, _ := newTestController(t)
+	c.Auth.EmailEnabled = true
+	owner := createTestClient(t, c, "254700000601")
+
+	tests := []struct {
+		name       string
+		invitation auth.NewInvitation
+		acceptance models.AcceptInvitationInput
+		verified   bool
+	}{
+		{
+			name:       "both addresses invited",
+			invitation: auth.NewInvitation{Contact: "254700000602", Email: "both@example.com"},
+			verified:   true,
+		},
+		{
+			name:       "email added by the invitee",
+			invitation: auth.NewInvitation{Contact: "254700000603"},
+			acceptance: models.AcceptInvitationInput{Email: "added@example.com"},
+		},
+		{
+			name:       "contact added by the invitee",
+			invitation: auth.NewInvitation{Email: "invited@example.com", Channel: "email"},
+			acceptance: models.AcceptInvitationInput{Contact: "254700000604"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.invitation.FirstName, tt.invitation.LastName = "Staff", "Member"
+			tt.acceptance.Token = inviteToken(t, c, owner, tt.invitation)
+			tt.acceptance.Password = testPassword
+
+			data := responseData(t, serve(t, http.HandlerFunc(c.AcceptInvitation), "/auth/invitations/accept", tt.acceptance, ""))
+			if data["is_verified"] != tt.verified {
+				t.Fatalf("verified = %v, want %v", data["is_verified"], tt.verified)
+			}
+
+			// The address the invitation didn't prove gets an activation code
+			id := int(data["id"].(float64))
+			_, err := c.Store.OTPs().LatestUnused(id, "activation")
+			if tt.verified && err == nil || !tt.verified && err != nil {
+				t.Fatalf("activation code lookup err = %v, want a code only when unverified", err)
+			}
+		})
+	}
+}
@@ -1,13 +1,15 @@
 package controllers
 
 import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
 	"github.com/kimoresteve/identity-service/app/models"
 	"github.com/kimoresteve/identity-service/app/utils"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
-	"strings"
-	"time"
+	"strconv"
 )
 
 //type LandlordInput struct {
@@ -43,104 +45,248 @@ func (c *Controller) RegisterLandlord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields for landlord
-	if input.Name == "" || input.Contact == "" || input.Password == "" || input.Email == "" {
-		http.Error(w, "Name, Email, contact and password are required", http.StatusBadRequest)
+	client, err := c.Auth.RegisterLandlord(auth.LandlordRegistration{
+		Registration: auth.Registration{
+			Name:              input.Name,
+			Email:             input.Email,
+			Contact:           input.Contact,
+			Password:          input.Password,
+			OTPChannel:        input.OTPChannel,
+			PreferredLanguage: input.PreferredLanguage,
+		},
+		Address:  input.Address,
+		AgencyID: input.AgencyID,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to complete registration")
 		return
 	}
 
-	if input.Address == "" {
-		http.Error(w, "Address is required for landlords", http.StatusBadRequest)
-		return
-	}
+	c.sendSuccessResponse(w, int64(client.ID), client.Name, client.Contact, "Landlord created successfully")
+}
 
-	// Validate agency exists if AgencyID is provided
-	if input.AgencyID != nil {
-		var count int
-		err := c.DB.QueryRow("SELECT COUNT(*) FROM agencies WHERE id = ?", *input.AgencyID).Scan(&count)
-		if err != nil || count == 0 {
-			http.Error(w, "Invalid agency ID", http.StatusBadRequest)
-			return
-		}
+// Helper function to describe a landlord in a response, without its password hash
+func landlordData(landlord *models.Landlord) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 landlord.ID,
+		"uuid":               landlord.UUID,
+		"name":               landlord.Name,
+		"address":            landlord.Address,
+		"email":              landlord.Email,
+		"contact":            landlord.Contact,
+		"is_verified":        landlord.IsVerified,
+		"is_active":          landlord.IsActive,
+		"preferred_language": landlord.PreferredLanguage,
+		"agency_id":          landlord.AgencyID,
+		"created_at":         landlord.CreatedAt,
+		"updated_at":         landlord.UpdatedAt,
 	}
+}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+// Helper function to read the calling agency and the landlord id from the path
+func landlordRequest(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	agencyID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	landlordID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Failed to secure password", http.StatusInternalServerError)
+		http.Error(w, "Invalid landlord id", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return int(agencyID), landlordID, true
+}
+
+// Helper function to answer with a single landlord
+func writeLandlord(w http.ResponseWriter, status int, message string, landlord *models.Landlord) {
+	response := models.Response{
+		Success: true,
+		Message: message,
+		Data:    landlordData(landlord),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListAgencyLandlords returns the landlords the agency represents.
+// @Summary List the agency's landlords
+// @Tags Landlord
+// @Produce json
+// @Success 200 {object} models.Response "Landlords"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Router /auth/landlords [get]
+func (c *Controller) ListAgencyLandlords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agencyID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// Begin database transaction
-	tx, err := c.DB.Begin()
+	landlords, err := c.Auth.ListAgencyLandlords(int(agencyID))
 	if err != nil {
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		writeAuthError(w, err, "Database error")
 		return
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	now := time.Now()
-	uuid := c.generateUUID()
-
-	// Create base client record
-	client := models.Client{
-		Name:      input.Name,
-		Contact:   input.Contact,
-		Email:     input.Email,
-		UUID:      uuid,
-		Type:      "landlord",
-		Password:  string(hashedPassword),
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-
-	result, err := tx.Exec(`
-        INSERT INTO clients (name, contact, email, password, type, uuid, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		client.Name, client.Contact, client.Email,
-		client.Password, client.Type, client.UUID,
-		client.CreatedAt, client.UpdatedAt)
 
+	list := make([]map[string]interface{}, 0, len(landlords))
+	for i := range landlords {
+		list = append(list, landlordData(&landlords[i]))
+	}
+
+	writeData(w, http.StatusOK, "Landlords", "landlords", list)
+}
+
+// GetAgencyLandlord returns one landlord the agency represents.
+// @Summary Get a landlord of the agency
+// @Tags Landlord
+// @Produce json
+// @Param id path int true "Landlord ID"
+// @Success 200 {object} models.Response "Landlord"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 404 {string} string "Landlord not found"
+// @Router /auth/landlords/{id} [get]
+func (c *Controller) GetAgencyLandlord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agencyID, landlordID, ok := landlordRequest(w, r)
+	if !ok {
+		return
+	}
+
+	landlord, err := c.Auth.GetAgencyLandlord(agencyID, landlordID)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate") {
-			http.Error(w, "Email or contact already exists", http.StatusConflict)
-			return
-		}
-		http.Error(w, "Failed to create landlord account", http.StatusInternalServerError)
+		writeAuthError(w, err, "Database error")
 		return
 	}
 
-	clientID, err := result.LastInsertId()
+	writeLandlord(w, http.StatusOK, "Landlord", landlord)
+}
+
+// UpdateAgencyLandlord changes the profile of a landlord the agency represents.
+// @Summary Update a landlord of the agency
+// @Tags Landlord
+// @Accept json
+// @Produce json
+// @Param id path int true "Landlord ID"
+// @Param landlord body models.LandlordUpdateInput true "Fields to change"
+// @Success 200 {object} models.Response "Landlord updated"
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 404 {string} string "Landlord not found"
+// @Router /auth/landlords/{id} [patch]
+func (c *Controller) UpdateAgencyLandlord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agencyID, landlordID, ok := landlordRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.LandlordUpdateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	landlord, err := c.Auth.UpdateAgencyLandlord(agencyID, landlordID, auth.LandlordUpdate{
+		Name:    input.Name,
+		Address: input.Address,
+	})
 	if err != nil {
-		http.Error(w, "Failed to get client ID", http.StatusInternalServerError)
+		writeAuthError(w, err, "Failed to update landlord")
 		return
 	}
 
-	// Insert landlord-specific data
-	_, err = tx.Exec(`
-        INSERT INTO landlords (id, name, address, agency_id)
-        VALUES (?, ?, ?, ?)`,
-		clientID, input.Name, input.Address, input.AgencyID)
+	writeLandlord(w, http.StatusOK, "Landlord updated", landlord)
+}
+
+// DeactivateAgencyLandlord stops a landlord the agency represents from signing in, its tokens stop working.
+// @Summary Deactivate a landlord of the agency
+// @Tags Landlord
+// @Produce json
+// @Param id path int true "Landlord ID"
+// @Success 200 {object} models.Response "Landlord deactivated"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 404 {string} string "Landlord not found"
+// @Router /auth/landlords/{id}/deactivate [post]
+func (c *Controller) DeactivateAgencyLandlord(w http.ResponseWriter, r *http.Request) {
+	c.setAgencyLandlordActive(w, r, false, "Landlord deactivated")
+}
+
+// ActivateAgencyLandlord lets a deactivated landlord of the agency sign in again.
+// @Summary Activate a landlord of the agency
+// @Tags Landlord
+// @Produce json
+// @Param id path int true "Landlord ID"
+// @Success 200 {object} models.Response "Landlord activated"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 404 {string} string "Landlord not found"
+// @Router /auth/landlords/{id}/activate [post]
+func (c *Controller) ActivateAgencyLandlord(w http.ResponseWriter, r *http.Request) {
+	c.setAgencyLandlordActive(w, r, true, "Landlord activated")
+}
+
+// Helper function to activate or deactivate the landlord in the path
+func (c *Controller) setAgencyLandlordActive(w http.ResponseWriter, r *http.Request, active bool, message string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agencyID, landlordID, ok := landlordRequest(w, r)
+	if !ok {
+		return
+	}
 
+	landlord, err := c.Auth.SetAgencyLandlordActive(agencyID, landlordID, active)
 	if err != nil {
-		http.Error(w, "Failed to create landlord profile", http.StatusInternalServerError)
+		writeAuthError(w, err, "Failed to update landlord")
 		return
 	}
 
-	// Generate and send OTP
-	if err := c.sendOTP(tx, clientID, input.Contact, now); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	writeLandlord(w, http.StatusOK, message, landlord)
+}
+
+// ReleaseAgencyLandlord stops the agency representing a landlord, the landlord keeps its account as an independent landlord.
+// @Summary Release a landlord of the agency
+// @Tags Landlord
+// @Produce json
+// @Param id path int true "Landlord ID"
+// @Success 200 {object} models.Response "Landlord released"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 404 {string} string "Landlord not found"
+// @Router /auth/landlords/{id}/release [post]
+func (c *Controller) ReleaseAgencyLandlord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agencyID, landlordID, ok := landlordRequest(w, r)
+	if !ok {
 		return
 	}
 
-	if err = tx.Commit(); err != nil {
-		http.Error(w, "Failed to complete registration", http.StatusInternalServerError)
+	landlord, err := c.Auth.ReleaseAgencyLandlord(agencyID, landlordID)
+	if err != nil {
+		writeAuthError(w, err, "Failed to release landlord")
 		return
 	}
 
-	c.sendSuccessResponse(w, clientID, client.Name, client.Contact, "Landlord created successfully")
+	writeLandlord(w, http.StatusOK, "Landlord released", landlord)
 }
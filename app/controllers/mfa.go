@@ -0,0 +1,674 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Purpose of the challenge token handed out between the password and the second factor
+const mfaChallengePurpose = "mfa"
+
+// Failed second factor attempts allowed before the challenge is revoked and the second factor locked
+const maxMFAAttempts = 5
+
+// How long the second factor is locked after maxMFAAttempts, doubled by every further failure up to mfaMaxLockout
+const (
+	mfaLockout    = time.Minute
+	mfaMaxLockout = time.Hour
+)
+
+// EnrollTOTP starts authenticator app enrollment.
+// @Summary Start TOTP enrollment
+// @Description Generates a TOTP secret for the signed in client. It becomes active once confirmed with a first code.
+// @Tags Two-factor
+// @Produce json
+// @Success 200 {object} models.Response "Secret and otpauth URI"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Router /auth/2fa/totp/enroll [post]
+func (c *Controller) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	client, err := c.Store.Clients().GetByID(int(clientID))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	settings, err := c.Store.MFA().Settings(client.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if settings.Method != "none" {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	if err = c.Store.MFA().SetTOTPSecret(client.ID, secret, time.Now()); err != nil {
+		http.Error(w, "Failed to store secret", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Scan the code with your authenticator app and confirm with the first code",
+		Data: map[string]interface{}{
+			"secret":      secret,
+			"otpauth_uri": utils.TOTPURI(totpIssuer(), client.Contact, secret),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ConfirmTOTP activates a pending TOTP enrollment.
+// @Summary Confirm TOTP enrollment
+// @Description Enables TOTP two-factor authentication once the first code from the authenticator app checks out
+// @Tags Two-factor
+// @Accept json
+// @Produce json
+// @Param code body models.TOTPCodeInput true "Code from the authenticator app"
+// @Success 200 {object} models.Response "Two-factor authentication enabled with recovery codes"
+// @Failure 400 {string} string "No pending enrollment"
+// @Failure 401 {string} string "Invalid code"
+// @Router /auth/2fa/totp/confirm [post]
+func (c *Controller) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var input models.TOTPCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var codes []string
+	err := c.Store.InTx(func(store repository.Store) error {
+		settings, err := store.MFA().SettingsForUpdate(int(clientID))
+		if err != nil {
+			return err
+		}
+		if settings.Method != "none" {
+			return utils.NewAPIError("Two-factor authentication is already enabled", http.StatusConflict, nil)
+		}
+		if settings.TOTPSecret == "" {
+			return utils.NewAPIError("No pending TOTP enrollment", http.StatusBadRequest, nil)
+		}
+
+		step, ok := utils.ValidateTOTP(settings.TOTPSecret, input.Code, time.Now())
+		if !ok {
+			return utils.NewAPIError("Invalid code", http.StatusUnauthorized, nil)
+		}
+
+		if err := store.MFA().EnableTOTP(int(clientID), step, time.Now()); err != nil {
+			return err
+		}
+		codes, err = issueRecoveryCodes(store, int(clientID))
+		return err
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Two-factor authentication enabled, store the recovery codes somewhere safe",
+		Data: map[string]interface{}{
+			"recovery_codes": codes,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DisableTOTP turns TOTP two-factor authentication off.
+// @Summary Disable TOTP
+// @Description Disables TOTP two-factor authentication, a current code or one of the recovery codes is required. Failed attempts count towards the same lockout as logins.
+// @Tags Two-factor
+// @Accept json
+// @Produce json
+// @Param code body models.DisableTOTPInput true "Code from the authenticator app or recovery code"
+// @Success 200 {object} models.Response "Two-factor authentication disabled"
+// @Failure 400 {string} string "TOTP is not enabled"
+// @Failure 401 {string} string "Invalid code"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /auth/2fa/totp/disable [post]
+func (c *Controller) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	clientID := int(claims.ClientID)
+	var input models.DisableTOTPInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var verified bool
+	err := c.Store.InTx(func(store repository.Store) error {
+		settings, err := store.MFA().SettingsForUpdate(clientID)
+		if err != nil {
+			return err
+		}
+		if settings.Method != "totp" {
+			return utils.NewAPIError("TOTP is not enabled", http.StatusBadRequest, nil)
+		}
+		if err := checkMFALockout(settings, time.Now()); err != nil {
+			return err
+		}
+
+		if input.RecoveryCode != "" {
+			verified, err = checkRecoveryCode(store, clientID, input.RecoveryCode)
+			if err != nil {
+				return err
+			}
+		} else {
+			_, verified = checkTOTP(settings, input.Code)
+		}
+
+		// The failure is committed, the caller is answered after the transaction. Once too many
+		// failed the access token used is revoked, as a login challenge would be.
+		if !verified {
+			return recordMFAFailure(store, claims, settings, time.Now())
+		}
+
+		if err := store.MFA().SetFailedAttempts(clientID, 0, nil); err != nil {
+			return err
+		}
+		if err := store.MFA().Disable(clientID, time.Now()); err != nil {
+			return err
+		}
+		return store.MFA().DeleteRecoveryCodes(clientID)
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to disable two-factor authentication")
+		return
+	}
+	if !verified {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// VerifyTwoFactor completes a login that returned mfa_required.
+// @Summary Verify second factor
+// @Description Exchanges the challenge token from /auth/login and a second factor code, or one of the recovery codes, for access and refresh tokens
+// @Tags Two-factor
+// @Accept json
+// @Produce json
+// @Param verification body models.VerifyTwoFactorInput true "Challenge token and code or recovery code"
+// @Success 200 {object} models.Response "Login successful with access and refresh token"
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid or expired challenge or code"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /auth/login/verify-2fa [post]
+func (c *Controller) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input models.VerifyTwoFactorInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	claims, err := middleware.ValidateChallengeToken(input.ChallengeToken, mfaChallengePurpose)
+	if err != nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := middleware.IsTokenRevoked(claims)
+	if err != nil {
+		http.Error(w, "Failed to check challenge", http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	var verified bool
+	err = c.Store.InTx(func(store repository.Store) error {
+		settings, err := store.MFA().SettingsForUpdate(int(claims.ClientID))
+		if err != nil {
+			return err
+		}
+		if settings.Method != "totp" && settings.Method != "sms" {
+			return utils.NewAPIError("Two-factor authentication is not enabled", http.StatusBadRequest, nil)
+		}
+		if err := checkMFALockout(settings, time.Now()); err != nil {
+			return err
+		}
+
+		switch {
+		case input.RecoveryCode != "":
+			verified, err = checkRecoveryCode(store, int(claims.ClientID), input.RecoveryCode)
+		case settings.Method == "totp":
+			var step int64
+			if step, verified = checkTOTP(settings, input.Code); verified {
+				err = store.MFA().SetTOTPLastStep(int(claims.ClientID), step)
+			}
+		case settings.Method == "sms":
+			verified, err = c.checkSMSCode(store, int(claims.ClientID), input.Code)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The failure is committed, the caller is answered after the transaction
+		if !verified {
+			return recordMFAFailure(store, claims, settings, time.Now())
+		}
+
+		if err := store.MFA().SetFailedAttempts(int(claims.ClientID), 0, nil); err != nil {
+			return err
+		}
+		// A challenge can only be completed once
+		return revokeAccessToken(store, claims)
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to verify code")
+		return
+	}
+	if !verified {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	client, err := c.Store.Clients().GetByID(int(claims.ClientID))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	c.completeLogin(w, client)
+}
+
+// EnableSMSTwoFactor opts the client into SMS codes as a second factor.
+// @Summary Enable SMS two-factor authentication
+// @Description After the password check every login sends a code to the verified contact, which must be given at /auth/login/verify-2fa
+// @Tags Two-factor
+// @Produce json
+// @Success 200 {object} models.Response "SMS two-factor authentication enabled with recovery codes"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Contact not verified"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Router /auth/2fa/sms/enable [post]
+func (c *Controller) EnableSMSTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var codes []string
+	err := c.Store.InTx(func(store repository.Store) error {
+		client, err := store.Clients().GetByIDForUpdate(int(clientID))
+		if err != nil {
+			return err
+		}
+		settings, err := store.MFA().Settings(client.ID)
+		if err != nil {
+			return err
+		}
+		// Only switch from none, so an enabled authenticator app is never silently replaced
+		if settings.Method != "none" {
+			return utils.NewAPIError("Two-factor authentication is already enabled", http.StatusConflict, nil)
+		}
+		if !client.IsVerified {
+			return utils.NewAPIError("Verify your contact before enabling SMS two-factor authentication", http.StatusForbidden, nil)
+		}
+
+		if err := store.MFA().EnableSMS(client.ID, time.Now()); err != nil {
+			return err
+		}
+		codes, err = issueRecoveryCodes(store, client.ID)
+		return err
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "SMS two-factor authentication enabled, store the recovery codes somewhere safe",
+		Data: map[string]interface{}{
+			"recovery_codes": codes,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DisableSMSTwoFactor turns SMS codes as a second factor off.
+// @Summary Disable SMS two-factor authentication
+// @Description Requires a texted code or one of the recovery codes. A request with neither texts a code to the client.
+// @Tags Two-factor
+// @Accept json
+// @Produce json
+// @Param code body models.DisableSMSTwoFactorInput true "Texted code or recovery code, empty to request a code"
+// @Success 200 {object} models.Response "SMS two-factor authentication disabled"
+// @Success 202 {object} models.Response "Code sent"
+// @Failure 400 {string} string "SMS two-factor authentication is not enabled"
+// @Failure 401 {string} string "Missing or invalid token, or invalid code"
+// @Failure 429 {string} string "Code requested too often"
+// @Failure 500 {string} string "Failed to disable two-factor authentication"
+// @Router /auth/2fa/sms/disable [post]
+func (c *Controller) DisableSMSTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var input models.DisableSMSTwoFactorInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if input.Code == "" && input.RecoveryCode == "" {
+		c.sendDisableSMSCode(w, int(clientID))
+		return
+	}
+
+	var verified bool
+	err := c.Store.InTx(func(store repository.Store) error {
+		settings, err := store.MFA().SettingsForUpdate(int(clientID))
+		if err != nil {
+			return err
+		}
+		if settings.Method != "sms" {
+			return utils.NewAPIError("SMS two-factor authentication is not enabled", http.StatusBadRequest, nil)
+		}
+
+		if input.RecoveryCode != "" {
+			verified, err = checkRecoveryCode(store, int(clientID), input.RecoveryCode)
+		} else {
+			verified, err = c.checkSMSCode(store, int(clientID), input.Code)
+		}
+		// A wrong code still counts against the texted one, so it is committed
+		if err != nil || !verified {
+			return err
+		}
+
+		if err := store.MFA().Disable(int(clientID), time.Now()); err != nil {
+			return err
+		}
+		if err := store.OTPs().InvalidateUnused(int(clientID), "2fa"); err != nil {
+			return err
+		}
+		return store.MFA().DeleteRecoveryCodes(int(clientID))
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to disable two-factor authentication")
+		return
+	}
+	if !verified {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "SMS two-factor authentication disabled",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to text the code that confirms turning SMS codes off
+func (c *Controller) sendDisableSMSCode(w http.ResponseWriter, clientID int) {
+	client, err := c.Store.Clients().GetByID(clientID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if client.MFAMethod != "sms" {
+		http.Error(w, "SMS two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if err := c.sendTwoFactorCode(client); err != nil {
+		writeAuthError(w, err, "Failed to send OTP")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "A code has been sent, repeat the request with it to disable SMS two-factor authentication",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to issue the access and refresh token at the end of a successful login
+func (c *Controller) completeLogin(w http.ResponseWriter, client *models.Client) {
+	refreshToken, err := issueRefreshToken(c.Store, client.ID, "", "", time.Now())
+	if err != nil {
+		http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := c.accessToken(client.ID, "")
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Login successful",
+		Data: map[string]interface{}{
+			"id":            client.ID,
+			"name":          client.Name,
+			"contact":       client.Contact,
+			"token":         token,
+			"refresh_token": refreshToken,
+			"token_type":    "Bearer",
+			"expires_in":    int(middleware.TokenTTL.Seconds()),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to answer a password login with a second factor challenge
+func (c *Controller) sendMFAChallenge(w http.ResponseWriter, client *models.Client) {
+	settings, err := c.Store.MFA().Settings(client.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := checkMFALockout(settings, time.Now()); err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	if client.MFAMethod == "sms" {
+		if err := c.sendTwoFactorCode(client); err != nil {
+			writeAuthError(w, err, "Failed to send OTP")
+			return
+		}
+	}
+
+	challenge, err := middleware.GenerateChallengeToken(uint(client.ID), mfaChallengePurpose)
+	if err != nil {
+		http.Error(w, "Failed to generate challenge", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Two-factor authentication required",
+		Data: map[string]interface{}{
+			"mfa_required":    true,
+			"mfa_method":      client.MFAMethod,
+			"challenge_token": challenge,
+			"expires_in":      int(middleware.ChallengeTokenTTL.Seconds()),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to generate, store and text a login code to the client. It is limited like
+// resends, an *auth.Error of KindTooManyRequests says how long to wait.
+func (c *Controller) sendTwoFactorCode(client *models.Client) error {
+	resend, err := c.Auth.ResendOTP(client.ID, "2fa", "")
+	if err != nil {
+		return err
+	}
+	return c.deliverTwoFactorCode(resend.Client, resend.Code, resend.Policy.TTL)
+}
+
+// Helper function to text a login code, by email when SMS fails
+func (c *Controller) deliverTwoFactorCode(client *models.Client, otp string, ttl time.Duration) error {
+	// Sent right away rather than through the outbox, the code is only good for a few minutes
+	subject, message, err := c.Auth.RenderMessage(c.Store, client.ID, notify.MessageLoginOTP, notify.TemplateData{Code: otp, ExpiresIn: int(ttl.Minutes())})
+	if err != nil {
+		return err
+	}
+	smsErr := c.SMS.SendSMS(client.Contact, message)
+	if smsErr == nil || c.Email == nil || client.Email == "" {
+		return smsErr
+	}
+
+	log.Printf("Sending login code by SMS failed, falling back to email: %v", smsErr)
+	return c.Email.SendEmail(client.Email, subject, message)
+}
+
+// checkSMSCode compares a code with the latest '2fa' OTP of the client and consumes it on success
+func (c *Controller) checkSMSCode(store repository.Store, clientID int, code string) (bool, error) {
+	switch err := c.Auth.ConsumeOTP(store, clientID, "2fa", code); err {
+	case nil:
+		return true, nil
+	case auth.ErrOTPNotFound, auth.ErrOTPExpired, auth.ErrOTPInvalid, auth.ErrOTPAttemptsExceeded:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Helper function to count a failed second factor. Once too many failed the token it was tried with, a
+// challenge or an access token, is revoked and the second factor locked. The count is only reset by a
+// successful verification.
+func recordMFAFailure(store repository.Store, claims *middleware.Claims, settings *models.MFASettings, now time.Time) error {
+	attempts := settings.FailedAttempts + 1
+	var lockedUntil *time.Time
+	if attempts >= maxMFAAttempts {
+		lockout := mfaLockoutFor(attempts)
+		log.Printf("Too many failed second factor attempts for client %d, token revoked and locked for %s", claims.ClientID, lockout)
+		if err := revokeAccessToken(store, claims); err != nil {
+			return err
+		}
+		until := now.Add(lockout)
+		lockedUntil = &until
+	}
+	return store.MFA().SetFailedAttempts(int(claims.ClientID), attempts, lockedUntil)
+}
+
+// mfaLockoutFor returns how long the second factor is locked after the given number of failed attempts
+func mfaLockoutFor(attempts int) time.Duration {
+	lockout := mfaLockout
+	for i := maxMFAAttempts; i < attempts && lockout < mfaMaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > mfaMaxLockout {
+		return mfaMaxLockout
+	}
+	return lockout
+}
+
+// Helper function to refuse second factor attempts while the client is locked out
+func checkMFALockout(settings *models.MFASettings, now time.Time) error {
+	if settings.LockedUntil == nil || !now.Before(*settings.LockedUntil) {
+		return nil
+	}
+	return &auth.Error{
+		Kind:       auth.KindTooManyRequests,
+		Message:    "Too many failed attempts, try again later",
+		RetryAfter: settings.LockedUntil.Sub(now),
+	}
+}
+
+// checkTOTP validates a code and rejects replays of an already used time step
+func checkTOTP(settings *models.MFASettings, code string) (int64, bool) {
+	if settings.TOTPSecret == "" {
+		return 0, false
+	}
+
+	step, ok := utils.ValidateTOTP(settings.TOTPSecret, code, time.Now())
+	if !ok {
+		return 0, false
+	}
+	if settings.TOTPLastStep != nil && step <= *settings.TOTPLastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+// totpIssuer is the account issuer shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Identity Service"
+}
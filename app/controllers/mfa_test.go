@@ -0,0 +1,360 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var smsCode = regexp.MustCompile(`\b\d{6}\b`)
+
+// enableSMSTwoFactor switches the client to SMS codes and returns its recovery codes
+func enableSMSTwoFactor(t *testing.T, c *Controller, contact string) []string {
+	t.Helper()
+
+	token := login(t, c, contact)["token"].(string)
+	rec := serve(t, middleware.JWTMiddleware(http.HandlerFunc(c.EnableSMSTwoFactor)), "/auth/2fa/sms/enable", nil, token)
+
+	var codes []string
+	for _, code := range responseData(t, rec)["recovery_codes"].([]interface{}) {
+		codes = append(codes, code.(string))
+	}
+	if len(codes) == 0 {
+		t.Fatal("no recovery codes issued")
+	}
+	return codes
+}
+
+// lastSMSCode returns the code in the latest text sent to the contact
+func lastSMSCode(t *testing.T, recorder *notify.Recorder, contact string) string {
+	t.Helper()
+
+	sms, ok := recorder.Last(contact)
+	if !ok {
+		t.Fatalf("no text sent to %s", contact)
+	}
+	code := smsCode.FindString(sms.Message)
+	if code == "" {
+		t.Fatalf("no code in %q", sms.Message)
+	}
+	return code
+}
+
+func TestSMSTwoFactorLogin(t *testing.T) {
+	c, recorder := newTestController(t)
+	createTestClient(t, c, "254700000101")
+	enableSMSTwoFactor(t, c, "254700000101")
+
+	data := login(t, c, "254700000101")
+	if data["mfa_required"] != true || data["token"] != nil {
+		t.Fatalf("login did not return a challenge: %v", data)
+	}
+	challenge := data["challenge_token"].(string)
+
+	input := models.VerifyTwoFactorInput{ChallengeToken: challenge, Code: lastSMSCode(t, recorder, "254700000101")}
+	data = responseData(t, serve(t, http.HandlerFunc(c.VerifyTwoFactor), "/auth/login/verify-2fa", input, ""))
+	if data["token"] == nil || data["refresh_token"] == nil {
+		t.Fatalf("verification did not issue tokens: %v", data)
+	}
+
+	// The challenge can only be completed once
+	rec := serve(t, http.HandlerFunc(c.VerifyTwoFactor), "/auth/login/verify-2fa", input, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("second verification status = %d, want 401", rec.Code)
+	}
+}
+
+func TestTwoFactorWrongCode(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000102")
+	enableSMSTwoFactor(t, c, "254700000102")
+
+	challenge := login(t, c, "254700000102")["challenge_token"].(string)
+	input := models.VerifyTwoFactorInput{ChallengeToken: challenge, Code: "not-a-code"}
+	rec := serve(t, http.HandlerFunc(c.VerifyTwoFactor), "/auth/login/verify-2fa", input, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+
+	// The failure is committed although the request failed
+	settings, err := c.Store.MFA().Settings(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.FailedAttempts != 1 {
+		t.Fatalf("failed attempts = %d, want 1", settings.FailedAttempts)
+	}
+}
+
+func TestTwoFactorRecoveryCode(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000103")
+	codes := enableSMSTwoFactor(t, c, "254700000103")
+
+	challenge := login(t, c, "254700000103")["challenge_token"].(string)
+	input := models.VerifyTwoFactorInput{ChallengeToken: challenge, RecoveryCode: codes[0]}
+	responseData(t, serve(t, http.HandlerFunc(c.VerifyTwoFactor), "/auth/login/verify-2fa", input, ""))
+
+	count, err := c.Store.MFA().CountRecoveryCodes(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(codes)-1 {
+		t.Fatalf("unused recovery codes = %d, want %d", count, len(codes)-1)
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000104")
+	codes := enableSMSTwoFactor(t, c, "254700000104")
+
+	verify := func(challenge string, input models.VerifyTwoFactorInput) int {
+		input.ChallengeToken = challenge
+		return serve(t, http.HandlerFunc(c.VerifyTwoFactor), "/auth/login/verify-2fa", input, "").Code
+	}
+	settings := func() *models.MFASettings {
+		settings, err := c.Store.MFA().Settings(client.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return settings
+	}
+	expireLockout := func() {
+		past := time.Now().Add(-time.Second)
+		if err := c.Store.MFA().SetFailedAttempts(client.ID, settings().FailedAttempts, &past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	challenge := login(t, c, "254700000104")["challenge_token"].(string)
+	for i := 0; i < maxMFAAttempts; i++ {
+		if status := verify(challenge, models.VerifyTwoFactorInput{Code: "not-a-code"}); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %d, want 401", i+1, status)
+		}
+	}
+	if s := settings(); s.FailedAttempts != maxMFAAttempts || s.LockedUntil == nil {
+		t.Fatalf("failed attempts = %d, locked until %v, want %d and a lockout", s.FailedAttempts, s.LockedUntil, maxMFAAttempts)
+	}
+
+	// No new challenge is issued and other challenges can't be used while locked
+	rec := serve(t, http.HandlerFunc(c.Login), "/auth/login", models.LoginInput{Contact: "254700000104", Password: testPassword}, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("login status = %d, want 429 with Retry-After", rec.Code)
+	}
+	challenge, err := middleware.GenerateChallengeToken(uint(client.ID), mfaChallengePurpose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := verify(challenge, models.VerifyTwoFactorInput{RecoveryCode: codes[0]}); status != http.StatusTooManyRequests {
+		t.Fatalf("verification status while locked = %d, want 429", status)
+	}
+
+	// The count is kept, the next failure locks for longer
+	expireLockout()
+	if status := verify(challenge, models.VerifyTwoFactorInput{Code: "not-a-code"}); status != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", status)
+	}
+	s := settings()
+	if s.FailedAttempts != maxMFAAttempts+1 || s.LockedUntil == nil || time.Until(*s.LockedUntil) <= mfaLockout {
+		t.Fatalf("failed attempts = %d, locked until %v, want %d and a longer lockout", s.FailedAttempts, s.LockedUntil, maxMFAAttempts+1)
+	}
+
+	// Only a successful verification resets it
+	expireLockout()
+	challenge, err = middleware.GenerateChallengeToken(uint(client.ID), mfaChallengePurpose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := verify(challenge, models.VerifyTwoFactorInput{RecoveryCode: codes[0]}); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if s := settings(); s.FailedAttempts != 0 || s.LockedUntil != nil {
+		t.Fatalf("failed attempts = %d, locked until %v, want a reset", s.FailedAttempts, s.LockedUntil)
+	}
+}
+
+func TestDisableSMSTwoFactor(t *testing.T) {
+	c, recorder := newTestController(t)
+	client := createTestClient(t, c, "254700000105")
+	enableSMSTwoFactor(t, c, "254700000105")
+	token, err := c.accessToken(client.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disable := middleware.JWTMiddleware(http.HandlerFunc(c.DisableSMSTwoFactor))
+
+	// Without a code one is texted
+	rec := serve(t, disable, "/auth/2fa/sms/disable", models.DisableSMSTwoFactorInput{}, token)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body.String())
+	}
+	code := lastSMSCode(t, recorder, "254700000105")
+
+	rec = serve(t, disable, "/auth/2fa/sms/disable", models.DisableSMSTwoFactorInput{Code: "000000" + code}, token)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code status = %d, want 401", rec.Code)
+	}
+
+	responseData(t, serve(t, disable, "/auth/2fa/sms/disable", models.DisableSMSTwoFactorInput{Code: code}, token))
+	settings, err := c.Store.MFA().Settings(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := c.Store.MFA().CountRecoveryCodes(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Method != "none" || count != 0 {
+		t.Fatalf("method = %s with %d recovery codes, want none without codes", settings.Method, count)
+	}
+}
+
+func TestTwoFactorCodeCooldown(t *testing.T) {
+	c, _ := newTestController(t)
+	createTestClient(t, c, "254700000106")
+	enableSMSTwoFactor(t, c, "254700000106")
+
+	login(t, c, "254700000106")
+	rec := serve(t, http.HandlerFunc(c.Login), "/auth/login", models.LoginInput{Contact: "254700000106", Password: testPassword}, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("second login status = %d, want 429 with Retry-After", rec.Code)
+	}
+}
+
+// totpCode computes the authenticator app code of the given 30 second step (RFC 6238)
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff%1000000)
+}
+
+// enableTOTP enrolls an authenticator app for the client and returns its secret and recovery codes
+func enableTOTP(t *testing.T, c *Controller, clientID int) (string, []string) {
+	t.Helper()
+
+	token, err := c.accessToken(clientID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(t, middleware.JWTMiddleware(http.HandlerFunc(c.EnrollTOTP)), "/auth/2fa/totp/enroll", nil, token)
+	secret := responseData(t, rec)["secret"].(string)
+
+	input := models.TOTPCodeInput{Code: totpCode(t, secret, time.Now().Unix()/30)}
+	rec = serve(t, middleware.JWTMiddleware(http.HandlerFunc(c.ConfirmTOTP)), "/auth/2fa/totp/confirm", input, token)
+	var codes []string
+	for _, code := range responseData(t, rec)["recovery_codes"].([]interface{}) {
+		codes = append(codes, code.(string))
+	}
+	return secret, codes
+}
+
+func TestDisableTOTP(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000107")
+	secret, _ := enableTOTP(t, c, client.ID)
+	token, err := c.accessToken(client.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disable := middleware.JWTMiddleware(http.HandlerFunc(c.DisableTOTP))
+
+	// The code of the confirmed step can't be replayed, the next one is accepted
+	input := models.DisableTOTPInput{Code: totpCode(t, secret, time.Now().Unix()/30+1)}
+	responseData(t, serve(t, disable, "/auth/2fa/totp/disable", input, token))
+	settings, err := c.Store.MFA().Settings(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Method != "none" {
+		t.Fatalf("method = %s, want none", settings.Method)
+	}
+}
+
+func TestDisableTOTPLockout(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000108")
+	_, codes := enableTOTP(t, c, client.ID)
+	disable := middleware.JWTMiddleware(http.HandlerFunc(c.DisableTOTP))
+	token, err := c.accessToken(client.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxMFAAttempts; i++ {
+		rec := serve(t, disable, "/auth/2fa/totp/disable", models.DisableTOTPInput{Code: "000000"}, token)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %d, want 401", i+1, rec.Code)
+		}
+	}
+
+	// The token that guessed is revoked, and a new one can't try again while locked
+	if rec := serve(t, disable, "/auth/2fa/totp/disable", models.DisableTOTPInput{RecoveryCode: codes[0]}, token); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked token status = %d, want 401", rec.Code)
+	}
+	token, err = c.accessToken(client.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(t, disable, "/auth/2fa/totp/disable", models.DisableTOTPInput{RecoveryCode: codes[0]}, token)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("status while locked = %d, want 429 with Retry-After", rec.Code)
+	}
+
+	// Once the lockout is over a recovery code disables it
+	past := time.Now().Add(-time.Second)
+	if err := c.Store.MFA().SetFailedAttempts(client.ID, maxMFAAttempts, &past); err != nil {
+		t.Fatal(err)
+	}
+	responseData(t, serve(t, disable, "/auth/2fa/totp/disable", models.DisableTOTPInput{RecoveryCode: codes[0]}, token))
+	settings, err := c.Store.MFA().Settings(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Method != "none" || settings.FailedAttempts != 0 {
+		t.Fatalf("method = %s with %d failed attempts, want none and a reset", settings.Method, settings.FailedAttempts)
+	}
+}
+
+func TestEnableSMSTwoFactorChecks(t *testing.T) {
+	c, _ := newTestController(t)
+	enable := middleware.JWTMiddleware(http.HandlerFunc(c.EnableSMSTwoFactor))
+
+	unverified := &models.Client{UUID: "uuid-254700000109", Type: models.ClientTypeLandlord, Contact: "254700000109", IsActive: true}
+	if err := c.Store.Clients().Create(unverified); err != nil {
+		t.Fatal(err)
+	}
+	token, err := c.accessToken(unverified.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(t, enable, "/auth/2fa/sms/enable", nil, token); rec.Code != http.StatusForbidden {
+		t.Fatalf("unverified client status = %d, want 403", rec.Code)
+	}
+
+	client := createTestClient(t, c, "254700000110")
+	enableSMSTwoFactor(t, c, "254700000110")
+	if token, err = c.accessToken(client.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(t, enable, "/auth/2fa/sms/enable", nil, token); rec.Code != http.StatusConflict {
+		t.Fatalf("second enable status = %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,619 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scopes the provider understands, the OIDC standard ones map to models.Client fields
+var supportedScopes = []string{"openid", "profile", "email", "phone"}
+
+var supportedGrantTypes = []string{"authorization_code", "refresh_token", "client_credentials"}
+
+const authorizationCodeTTL = 5 * time.Minute
+
+var errInvalidOAuthClient = errors.New("invalid client")
+
+// oauthError is an OAuth2 error returned from a store transaction, see writeOAuthTxError
+type oauthError struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func invalidGrant(description string) *oauthError {
+	return &oauthError{Status: http.StatusBadRequest, Code: "invalid_grant", Description: description}
+}
+
+// idTokenClaims are the claims of an OpenID Connect ID token. Purpose keeps them from passing
+// as access tokens.
+type idTokenClaims struct {
+	Purpose             string            `json:"purpose"`
+	Nonce               string            `json:"nonce,omitempty"`
+	AuthTime            *jwt.NumericDate  `json:"auth_time,omitempty"`
+	Name                string            `json:"name,omitempty"`
+	Email               string            `json:"email,omitempty"`
+	PhoneNumber         string            `json:"phone_number,omitempty"`
+	PhoneNumberVerified bool              `json:"phone_number_verified,omitempty"`
+	ClientType          models.ClientType `json:"client_type,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// OpenIDConfiguration publishes the provider metadata.
+// @Summary OpenID Connect discovery
+// @Description Provider metadata as defined by OpenID Connect Discovery 1.0
+// @Tags OIDC
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Provider metadata"
+// @Router /.well-known/openid-configuration [get]
+func (c *Controller) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(middleware.Issuer(), "/")
+
+	config := map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"scopes_supported":                      supportedScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 supportedGrantTypes,
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{middleware.SigningKeys().Algorithm()},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "email", "phone_number", "phone_number_verified", "client_type",
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// Authorize starts the authorization code flow.
+// @Summary OAuth2 authorization endpoint
+// @Description Issues an authorization code for the signed in client. The client is identified by a Bearer token, or by contact and password posted from the login page. PKCE (S256) is required.
+// @Tags OIDC
+// @Accept x-www-form-urlencoded
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Relying party client ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string true "Space separated scopes, must include openid"
+// @Param state query string false "Opaque value returned to the relying party"
+// @Param nonce query string false "Value copied into the ID token"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 302 {string} string "Redirect to redirect_uri with code and state"
+// @Failure 400 {string} string "Unknown client or redirect URI"
+// @Failure 401 {string} string "Invalid credentials"
+// @Router /oauth/authorize [get]
+func (c *Controller) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	params := r.Form
+
+	client, err := c.Store.OAuth().GetClient(params.Get("client_id"))
+	if err != nil || !containsString(client.GrantTypes, "authorization_code") {
+		http.Error(w, "Unknown client", http.StatusBadRequest)
+		return
+	}
+
+	// Never redirect to an unregistered URI, errors are shown to the user instead
+	redirectURI := params.Get("redirect_uri")
+	if !containsString(client.RedirectURIs, redirectURI) {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	state := params.Get("state")
+
+	if params.Get("response_type") != "code" {
+		redirectOAuthError(w, r, redirectURI, state, "unsupported_response_type", "Only the code response type is supported")
+		return
+	}
+
+	scopes := strings.Fields(params.Get("scope"))
+	if !containsString(scopes, "openid") {
+		redirectOAuthError(w, r, redirectURI, state, "invalid_scope", "The openid scope is required")
+		return
+	}
+	for _, scope := range scopes {
+		if !containsString(client.Scopes, scope) {
+			redirectOAuthError(w, r, redirectURI, state, "invalid_scope", fmt.Sprintf("Scope %s is not allowed", scope))
+			return
+		}
+	}
+
+	challenge := params.Get("code_challenge")
+	if challenge == "" || params.Get("code_challenge_method") != "S256" {
+		redirectOAuthError(w, r, redirectURI, state, "invalid_request", "PKCE with code_challenge_method S256 is required")
+		return
+	}
+
+	// Resolve the signed in client from the access token or the login form
+	var clientID int
+	var authTime time.Time
+	if claims := middleware.GetClaimsFromContext(r.Context()); claims != nil && claims.Scope == "" && claims.IssuedAt != nil {
+		clientID = int(claims.ClientID)
+		authTime = claims.IssuedAt.Time
+	} else if r.Method == http.MethodPost && r.PostForm.Get("contact") != "" {
+		identity, err := c.Auth.Login(r.PostForm.Get("contact"), r.PostForm.Get("password"))
+		if err != nil {
+			c.redirectToLogin(w, r, redirectURI, state, "invalid_credentials")
+			return
+		}
+		// The login page has to complete the second factor and come back with the access token
+		if identity.MFAMethod != "none" {
+			c.redirectToLogin(w, r, redirectURI, state, "mfa_required")
+			return
+		}
+		clientID = identity.ID
+		authTime = time.Now()
+	} else {
+		c.redirectToLogin(w, r, redirectURI, state, "")
+		return
+	}
+
+	code, codeHash, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		http.Error(w, "Failed to generate authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.Store.OAuth().CreateCode(&models.AuthorizationCode{
+		Hash:          codeHash,
+		OAuthClientID: client.ClientID,
+		ClientID:      clientID,
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         params.Get("nonce"),
+		CodeChallenge: challenge,
+		AuthTime:      authTime,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		http.Error(w, "Failed to store authorization code", http.StatusInternalServerError)
+		fmt.Printf("Error storing authorization code: %s", err.Error())
+		return
+	}
+
+	redirectWithParams(w, r, redirectURI, url.Values{"code": {code}, "state": {state}})
+}
+
+// Token is the OAuth2 token endpoint.
+// @Summary OAuth2 token endpoint
+// @Description Exchanges an authorization code (with PKCE verifier), a refresh token or service client credentials for tokens. Confidential clients authenticate with HTTP Basic or client_secret_post.
+// @Tags OIDC
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI used in the authorization request"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param scope formData string false "Space separated scopes requested by a service client"
+// @Param client_id formData string false "Client ID when not using HTTP Basic"
+// @Param client_secret formData string false "Client secret when not using HTTP Basic"
+// @Success 200 {object} map[string]interface{} "Access token, refresh token and ID token"
+// @Failure 400 {object} map[string]string "OAuth2 error"
+// @Failure 401 {object} map[string]string "Client authentication failed"
+// @Router /oauth/token [post]
+func (c *Controller) Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid request payload")
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if !containsString(supportedGrantTypes, grantType) {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	client, err := c.authenticateOAuthClient(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+	if !containsString(client.GrantTypes, grantType) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", fmt.Sprintf("Client may not use the %s grant", grantType))
+		return
+	}
+
+	switch grantType {
+	case "authorization_code":
+		c.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		c.exchangeRefreshToken(w, r, client)
+	case "client_credentials":
+		c.exchangeClientCredentials(w, r, client)
+	}
+}
+
+// UserInfo returns the claims of the client the access token was issued to.
+// @Summary OpenID Connect userinfo
+// @Description Returns the claims allowed by the scope of the access token
+// @Tags OIDC
+// @Produce json
+// @Success 200 {object} map[string]interface{} "User claims"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /userinfo [get]
+func (c *Controller) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil || claims.Service != "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	client, err := c.Store.Clients().GetByID(int(claims.ClientID))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	// Tokens from the password login carry no scope and may read every claim
+	granted := strings.Fields(claims.Scope)
+	allowed := func(scope string) bool {
+		return claims.Scope == "" || containsString(granted, scope)
+	}
+
+	info := map[string]interface{}{"sub": client.UUID}
+	if allowed("profile") {
+		info["name"] = client.Name
+		info["client_type"] = client.Type
+	}
+	if allowed("email") && client.Email != "" {
+		info["email"] = client.Email
+	}
+	if allowed("phone") {
+		info["phone_number"] = client.Contact
+		info["phone_number_verified"] = client.IsVerified
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// RegisterOAuthClient creates a relying party and returns its client ID and, for confidential clients, its secret
+func (c *Controller) RegisterOAuthClient(name string, redirectURIs []string, public bool) (string, string, error) {
+	clientID, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	client := &models.OAuthClient{
+		ClientID:     clientID,
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Scopes:       supportedScopes,
+		GrantTypes:   []string{"authorization_code", "refresh_token"},
+	}
+
+	var secret string
+	if !public {
+		secret, _, err = middleware.GenerateOpaqueToken()
+		if err != nil {
+			return "", "", err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to hash client secret: %v", err)
+		}
+		client.SecretHash = string(hash)
+	}
+
+	if err := c.Store.OAuth().CreateClient(client); err != nil {
+		return "", "", err
+	}
+
+	return clientID, secret, nil
+}
+
+func (c *Controller) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	var grant *models.AuthorizationCode
+	var identity *models.Client
+	var refreshToken string
+
+	err := c.Store.InTx(func(store repository.Store) error {
+		var err error
+		grant, err = store.OAuth().GetCodeForUpdate(middleware.HashOpaqueToken(r.PostForm.Get("code")))
+		if err == repository.ErrNotFound {
+			return invalidGrant("Invalid authorization code")
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if grant.UsedAt != nil || now.After(grant.ExpiresAt) {
+			return invalidGrant("Authorization code expired or already used")
+		}
+		if grant.OAuthClientID != client.ClientID || grant.RedirectURI != r.PostForm.Get("redirect_uri") {
+			return invalidGrant("Authorization code was issued to another client or redirect_uri")
+		}
+		if !verifyPKCE(grant.CodeChallenge, r.PostForm.Get("code_verifier")) {
+			return invalidGrant("Invalid code_verifier")
+		}
+
+		if err := store.OAuth().MarkCodeUsed(grant.ID, now); err != nil {
+			return err
+		}
+
+		identity, err = store.Clients().GetByID(grant.ClientID)
+		if err == repository.ErrNotFound {
+			return invalidGrant("Client not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		refreshToken, err = issueRefreshToken(store, grant.ClientID, grant.Scope, client.ClientID, now)
+		return err
+	})
+	if err != nil {
+		writeOAuthTxError(w, err, "Failed to redeem authorization code")
+		return
+	}
+
+	accessToken, err := c.accessToken(grant.ClientID, grant.Scope)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	idToken, err := generateIDToken(identity, client.ClientID, grant.Scope, grant.Nonce, grant.AuthTime)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate ID token")
+		return
+	}
+
+	writeTokenResponse(w, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(middleware.TokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"id_token":      idToken,
+		"scope":         grant.Scope,
+	})
+}
+
+func (c *Controller) exchangeRefreshToken(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	rotated, err := c.rotateRefreshToken(r.PostForm.Get("refresh_token"), client.ClientID)
+	if err != nil {
+		switch err {
+		case errRefreshTokenInvalid, errRefreshTokenRevoked, errRefreshTokenExpired, errRefreshTokenClient, errClientInactive:
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		default:
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to rotate refresh token")
+			fmt.Printf("Error rotating refresh token: %s", err.Error())
+		}
+		return
+	}
+
+	accessToken, err := c.accessToken(rotated.ClientID, rotated.Scope)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(middleware.TokenTTL.Seconds()),
+		"refresh_token": rotated.RefreshToken,
+	}
+	if rotated.Scope != "" {
+		response["scope"] = rotated.Scope
+	}
+	writeTokenResponse(w, response)
+}
+
+// Helper function to authenticate a relying party with client_secret_basic, client_secret_post or none for public clients
+func (c *Controller) authenticateOAuthClient(r *http.Request) (*models.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		return nil, errInvalidOAuthClient
+	}
+
+	client, err := c.Store.OAuth().GetClient(clientID)
+	if err != nil {
+		return nil, errInvalidOAuthClient
+	}
+
+	// Public clients have no secret, PKCE protects their codes
+	if client.SecretHash == "" {
+		return client, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)); err != nil {
+		return nil, errInvalidOAuthClient
+	}
+	return client, nil
+}
+
+// Helper function to send the user to the login page, or report the error to the relying party when there is none
+func (c *Controller) redirectToLogin(w http.ResponseWriter, r *http.Request, redirectURI, state, loginError string) {
+	loginURL := os.Getenv("OIDC_LOGIN_URL")
+	if loginURL == "" {
+		switch loginError {
+		case "":
+		case "mfa_required":
+			http.Error(w, "Two-factor authentication required", http.StatusUnauthorized)
+			return
+		default:
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		redirectOAuthError(w, r, redirectURI, state, "login_required", "The client is not signed in")
+		return
+	}
+
+	// The login page posts the same authorization parameters back with contact and password
+	params := url.Values{}
+	for key, values := range r.Form {
+		if key != "contact" && key != "password" {
+			params[key] = values
+		}
+	}
+	if loginError != "" {
+		params.Set("error", loginError)
+	}
+	redirectWithParams(w, r, loginURL, params)
+}
+
+func generateIDToken(client *models.Client, audience, scope, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := idTokenClaims{
+		Purpose:  middleware.IDTokenPurpose,
+		Nonce:    nonce,
+		AuthTime: jwt.NewNumericDate(authTime),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.Issuer(),
+			Subject:   client.UUID,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(middleware.TokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	scopes := strings.Fields(scope)
+	if containsString(scopes, "profile") {
+		claims.Name = client.Name
+		claims.ClientType = client.Type
+	}
+	if containsString(scopes, "email") {
+		claims.Email = client.Email
+	}
+	if containsString(scopes, "phone") {
+		claims.PhoneNumber = client.Contact
+		claims.PhoneNumberVerified = client.IsVerified
+	}
+
+	return middleware.SigningKeys().Sign(claims)
+}
+
+// verifyPKCE checks an S256 code verifier against the challenge from the authorization request
+func verifyPKCE(challenge, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func redirectOAuthError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	redirectWithParams(w, r, redirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {state},
+	})
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, target string, params url.Values) {
+	u, err := url.Parse(target)
+	if err != nil {
+		http.Error(w, "Invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	response := map[string]string{"error": code}
+	if description != "" {
+		response["error_description"] = description
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to answer an error returned from a store transaction on an OAuth endpoint.
+// oauthErrors are answered as they are, anything else is logged and answered as a server_error.
+func writeOAuthTxError(w http.ResponseWriter, err error, fallback string) {
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		writeOAuthError(w, oauthErr.Status, oauthErr.Code, oauthErr.Description)
+		return
+	}
+	log.Printf("%s: %v", fallback, err)
+	writeOAuthError(w, http.StatusInternalServerError, "server_error", fallback)
+}
+
+func writeTokenResponse(w http.ResponseWriter, response map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
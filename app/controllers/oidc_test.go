@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestOAuthClient stores a public relying party allowed to use refresh tokens
+func createTestOAuthClient(t *testing.T, c *Controller, clientID string) *models.OAuthClient {
+	t.Helper()
+
+	client := &models.OAuthClient{
+		ClientID:     clientID,
+		Name:         clientID,
+		RedirectURIs: []string{"https://" + clientID + ".example/callback"},
+		Scopes:       supportedScopes,
+		GrantTypes:   []string{"authorization_code", "refresh_token"},
+	}
+	if err := c.Store.OAuth().CreateClient(client); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// refreshAtTokenEndpoint presents the refresh token at /oauth/token as the relying party
+func refreshAtTokenEndpoint(t *testing.T, c *Controller, clientID, refreshToken string) (int, map[string]interface{}) {
+	t.Helper()
+
+	form := url.Values{"grant_type": {"refresh_token"}, "client_id": {clientID}, "refresh_token": {refreshToken}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := httptest.NewRecorder()
+	c.Token(rec, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, response
+}
+
+func TestRefreshTokenBoundToOAuthClient(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000201")
+	createTestOAuthClient(t, c, "first-app")
+	createTestOAuthClient(t, c, "second-app")
+
+	refreshToken, err := issueRefreshToken(c.Store, client.ID, "openid", "first-app", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither another relying party nor the first-party endpoint can use it
+	status, response := refreshAtTokenEndpoint(t, c, "second-app", refreshToken)
+	if status != http.StatusBadRequest || response["error"] != "invalid_grant" {
+		t.Fatalf("other client got %d %v, want 400 invalid_grant", status, response)
+	}
+	rec := serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: refreshToken}, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("first-party refresh status = %d, want 401", rec.Code)
+	}
+
+	// The rejected attempts left the token usable by the client it was issued to, and so is the rotated one
+	status, response = refreshAtTokenEndpoint(t, c, "first-app", refreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, response)
+	}
+	status, response = refreshAtTokenEndpoint(t, c, "first-app", response["refresh_token"].(string))
+	if status != http.StatusOK {
+		t.Fatalf("rotated token status = %d, want 200: %v", status, response)
+	}
+}
+
+// introspect asks /oauth/introspect about the token as the service client
+func introspect(t *testing.T, c *Controller, clientID, secret, token string) map[string]interface{} {
+	t.Helper()
+
+	form := url.Values{"token": {token}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+
+	rec := httptest.NewRecorder()
+	c.Introspect(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("introspection status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestIDTokenIsNotAnAccessToken(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000202")
+	idToken, err := generateIDToken(client, "first-app", "openid profile", "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInfo := middleware.JWTMiddleware(http.HandlerFunc(c.UserInfo))
+	if rec := serve(t, userInfo, "/userinfo", nil, idToken); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("protected route status = %d, want 401", rec.Code)
+	}
+
+	serviceID, secret, err := c.RegisterServiceClient("billing", []string{"users:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := introspect(t, c, serviceID, secret, idToken); response["active"] != false {
+		t.Fatalf("ID token introspected as %v, want inactive", response)
+	}
+
+	// An access token of the same client still passes both
+	token, err := c.accessToken(client.ID, "openid profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(t, userInfo, "/userinfo", nil, token); rec.Code != http.StatusOK {
+		t.Fatalf("access token status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if response := introspect(t, c, serviceID, secret, token); response["active"] != true {
+		t.Fatalf("access token introspected as %v, want active", response)
+	}
+}
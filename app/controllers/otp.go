@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"encoding/json"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"log"
+	"net/http"
+)
+
+// ResendOTP sends a new code for a purpose and invalidates the earlier ones.
+// @Summary Resend OTP
+// @Description Sends a new activation, reset or 2fa code. Earlier unused codes for the purpose stop working. Resends are limited by a cooldown and a daily cap, the response says when the next one is allowed. 2fa codes are requested with the challenge token from /auth/login instead of the id.
+// @Tags Client
+// @Accept json
+// @Produce json
+// @Param resend body models.ResendOTPInput true "Client and purpose"
+// @Success 200 {object} models.Response "Code sent with next_resend_at"
+// @Failure 400 {string} string "Invalid purpose or nothing to resend"
+// @Failure 401 {string} string "Invalid or expired challenge"
+// @Failure 404 {string} string "Client not found"
+// @Failure 429 {string} string "Resend not allowed yet"
+// @Router /auth/resend-otp [post]
+func (c *Controller) ResendOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input models.ResendOTPInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Login codes only go to clients that got past the password
+	clientID := input.LandlordID
+	if input.Purpose == "2fa" {
+		claims, err := middleware.ValidateChallengeToken(input.ChallengeToken, mfaChallengePurpose)
+		if err != nil {
+			http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+			return
+		}
+		revoked, err := middleware.IsTokenRevoked(claims)
+		if err != nil {
+			http.Error(w, "Failed to check challenge", http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+			return
+		}
+		clientID = int(claims.ClientID)
+	}
+
+	resend, err := c.Auth.ResendOTP(clientID, input.Purpose, input.OTPChannel)
+	if err != nil {
+		writeAuthError(w, err, "Failed to send OTP")
+		return
+	}
+
+	// Login codes skip the outbox, they expire with the challenge
+	if resend.Code != "" {
+		if err = c.deliverTwoFactorCode(resend.Client, resend.Code, resend.Policy.TTL); err != nil {
+			http.Error(w, "Failed to send OTP", http.StatusInternalServerError)
+			log.Printf("Error resending 2fa OTP: %v", err)
+			return
+		}
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "A new code has been sent",
+		Data: map[string]interface{}{
+			"purpose":        input.Purpose,
+			"next_resend_at": resend.NextResendAt,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"net/http"
+)
+
+var errNotPolicyTenant = errors.New("Only agencies can override the OTP policy")
+
+// Helper function to describe a policy in a response
+func otpPolicyData(policy utils.OTPPolicy) map[string]interface{} {
+	return map[string]interface{}{
+		"length":                  policy.Length,
+		"alphabet":                policy.Alphabet,
+		"ttl_seconds":             int(policy.TTL.Seconds()),
+		"max_attempts":            policy.MaxAttempts,
+		"resend_cooldown_seconds": int(policy.ResendCooldown.Seconds()),
+	}
+}
+
+// Helper function to check the client is an agency, only agencies override the policy
+func (c *Controller) policyTenant(r *http.Request) (int, error) {
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		return 0, auth.ErrClientNotFound
+	}
+
+	client, err := c.Store.Clients().GetByID(int(clientID))
+	if err != nil {
+		return 0, err
+	}
+	if client.Type != models.ClientTypeAgency {
+		return 0, errNotPolicyTenant
+	}
+	return int(clientID), nil
+}
+
+// Helper function to answer a failed policyTenant
+func writePolicyTenantError(w http.ResponseWriter, err error) {
+	switch err {
+	case auth.ErrClientNotFound, repository.ErrNotFound:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case errNotPolicyTenant:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// Helper function to check a purpose from the path
+func checkOTPPurpose(purpose string) error {
+	for _, p := range utils.OTPPurposes {
+		if p == purpose {
+			return nil
+		}
+	}
+	return auth.ErrOTPPurposeUnsupported
+}
+
+// GetOTPPolicy returns the OTP policy of every purpose for the clients of the agency.
+// @Summary OTP policy
+// @Description Returns the configured policy, the agency's override and the resulting policy of each purpose.
+// @Tags OTP policy
+// @Produce json
+// @Success 200 {object} models.Response "Policies by purpose"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Router /auth/otp-policy [get]
+func (c *Controller) GetOTPPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := c.policyTenant(r)
+	if err != nil {
+		writePolicyTenantError(w, err)
+		return
+	}
+
+	policies := make(map[string]interface{}, len(utils.OTPPurposes))
+	for _, purpose := range utils.OTPPurposes {
+		override, err := c.Store.OTPs().GetPolicyOverride(tenantID, purpose)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		effective, err := c.Auth.OTPPolicy(c.Store, tenantID, purpose)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		policies[purpose] = map[string]interface{}{
+			"configured": otpPolicyData(c.Auth.BaseOTPPolicy(purpose)),
+			"override":   overrideFields(override),
+			"effective":  otpPolicyData(effective),
+		}
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "OTP policy",
+		Data:    policies,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to list the fields an override sets
+func overrideFields(o *models.OTPPolicyOverride) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if o == nil {
+		return fields
+	}
+	if o.Length != nil {
+		fields["length"] = *o.Length
+	}
+	if o.Alphabet != nil {
+		fields["alphabet"] = *o.Alphabet
+	}
+	if o.TTLSeconds != nil {
+		fields["ttl_seconds"] = *o.TTLSeconds
+	}
+	if o.MaxAttempts != nil {
+		fields["max_attempts"] = *o.MaxAttempts
+	}
+	if o.ResendCooldownSeconds != nil {
+		fields["resend_cooldown_seconds"] = *o.ResendCooldownSeconds
+	}
+	return fields
+}
+
+// SaveOTPPolicy overrides the OTP policy of a purpose for the clients of the agency.
+// @Summary Override the OTP policy
+// @Description Fields left out keep the configured value. The resulting policy has to stay within the service limits.
+// @Tags OTP policy
+// @Accept json
+// @Produce json
+// @Param purpose path string true "activation, reset or 2fa"
+// @Param policy body models.OTPPolicyInput true "Override"
+// @Success 200 {object} models.Response "Override saved with the resulting policy"
+// @Failure 400 {string} string "Unknown purpose or invalid policy"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Router /auth/otp-policy/{purpose} [put]
+func (c *Controller) SaveOTPPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := c.policyTenant(r)
+	if err != nil {
+		writePolicyTenantError(w, err)
+		return
+	}
+
+	purpose := mux.Vars(r)["purpose"]
+	if err := checkOTPPurpose(purpose); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var input models.OTPPolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	override := models.OTPPolicyOverride{
+		TenantID:              tenantID,
+		Purpose:               purpose,
+		Length:                input.Length,
+		Alphabet:              input.Alphabet,
+		TTLSeconds:            input.TTLSeconds,
+		MaxAttempts:           input.MaxAttempts,
+		ResendCooldownSeconds: input.ResendCooldownSeconds,
+	}
+	policy := auth.ApplyOTPPolicyOverride(c.Auth.BaseOTPPolicy(purpose), &override)
+	if err := policy.Validate(); err != nil {
+		http.Error(w, "Invalid OTP policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = c.Store.OTPs().SavePolicyOverride(&override); err != nil {
+		http.Error(w, "Failed to save OTP policy", http.StatusInternalServerError)
+		return
+	}
+
+	effective, err := c.Auth.OTPPolicy(c.Store, tenantID, purpose)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "OTP policy saved",
+		Data: map[string]interface{}{
+			"purpose":   purpose,
+			"effective": otpPolicyData(effective),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteOTPPolicy removes the override of a purpose, the configured policy applies again.
+// @Summary Delete an OTP policy override
+// @Tags OTP policy
+// @Produce json
+// @Param purpose path string true "activation, reset or 2fa"
+// @Success 200 {object} models.Response "Override deleted"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency"
+// @Failure 404 {string} string "No override for the purpose"
+// @Router /auth/otp-policy/{purpose} [delete]
+func (c *Controller) DeleteOTPPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := c.policyTenant(r)
+	if err != nil {
+		writePolicyTenantError(w, err)
+		return
+	}
+
+	deleted, err := c.Store.OTPs().DeletePolicyOverride(tenantID, mux.Vars(r)["purpose"])
+	if err != nil {
+		http.Error(w, "Failed to delete OTP policy", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "OTP policy override not found", http.StatusNotFound)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "OTP policy override deleted",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
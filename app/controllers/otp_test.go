@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Codes a client can be sent a day, the cap of the auth package
+const dailyCodeCap = 10
+
+func TestResendOTPDailyCap(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000701")
+
+	// Without a cooldown only the daily cap limits resends
+	policy := utils.DefaultOTPPolicy
+	policy.ResendCooldown = 0
+	c.Auth.OTPPolicies = map[string]utils.OTPPolicy{"reset": policy}
+
+	input := models.ResendOTPInput{LandlordID: client.ID, Purpose: "reset"}
+	start := time.Now()
+	var data map[string]interface{}
+	for i := 0; i < dailyCodeCap; i++ {
+		data = responseData(t, serve(t, http.HandlerFunc(c.ResendOTP), "/auth/resend-otp", input, ""))
+	}
+
+	// The last code of the day was sent, the next one is allowed once the first is a day old
+	next, err := time.Parse(time.RFC3339, data["next_resend_at"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(24 * time.Hour); next.Before(want.Add(-time.Second)) || next.After(want.Add(time.Minute)) {
+		t.Fatalf("next_resend_at = %s, want %s", next, want)
+	}
+
+	rec := serve(t, http.HandlerFunc(c.ResendOTP), "/auth/resend-otp", input, "")
+	retryAfter, _ := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if rec.Code != http.StatusTooManyRequests || time.Duration(retryAfter)*time.Second < 23*time.Hour {
+		t.Fatalf("status = %d with Retry-After %ds, want 429 until the cap frees up", rec.Code, retryAfter)
+	}
+}
@@ -0,0 +1,514 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"github.com/kimoresteve/identity-service/app/webauthn"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a registration or login ceremony may take
+const webAuthnChallengeTTL = 5 * time.Minute
+
+var errWebAuthnChallenge = errors.New("unknown or expired challenge")
+
+var errInvalidPasskey = utils.NewAPIError("Invalid passkey", http.StatusUnauthorized, nil)
+
+// BeginPasskeyRegistration starts registering a passkey for the signed in client.
+// @Summary Start passkey registration
+// @Description Returns the options for navigator.credentials.create()
+// @Tags Passkeys
+// @Produce json
+// @Success 200 {object} models.Response "Credential creation options under publicKey"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/webauthn/register/begin [post]
+func (c *Controller) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rp, err := webAuthnRelyingParty()
+	if err != nil {
+		log.Printf("WebAuthn is not configured: %v", err)
+		http.Error(w, "Passkeys are not available", http.StatusInternalServerError)
+		return
+	}
+
+	client, err := c.Store.Clients().GetByID(int(clientID))
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	existing, err := c.passkeyDescriptors(client.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := c.createWebAuthnChallenge(&client.ID, "registration")
+	if err != nil {
+		http.Error(w, "Failed to start registration", http.StatusInternalServerError)
+		return
+	}
+
+	// The uuid is the user handle, it identifies the client without revealing the contact
+	user := webauthn.User{
+		ID:          webauthn.Base64URL(client.UUID),
+		Name:        client.Contact,
+		DisplayName: client.Name,
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Passkey registration started",
+		Data: map[string]interface{}{
+			"publicKey": rp.CreationOptions(challenge, user, existing),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// FinishPasskeyRegistration stores the passkey created by the browser.
+// @Summary Finish passkey registration
+// @Tags Passkeys
+// @Accept json
+// @Produce json
+// @Param credential body models.PasskeyRegistrationInput true "Result of navigator.credentials.create()"
+// @Success 201 {object} models.Response "Passkey registered"
+// @Failure 400 {string} string "Invalid passkey"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 409 {string} string "Passkey already registered"
+// @Router /auth/webauthn/register/finish [post]
+func (c *Controller) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var input models.PasskeyRegistrationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	rp, err := webAuthnRelyingParty()
+	if err != nil {
+		log.Printf("WebAuthn is not configured: %v", err)
+		http.Error(w, "Passkeys are not available", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, owner, err := c.consumeWebAuthnChallenge(input.Credential.Response.ClientDataJSON, "registration")
+	if err != nil || owner == nil || *owner != int(clientID) {
+		http.Error(w, "Invalid or expired challenge", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, &input.Credential)
+	if err != nil {
+		log.Printf("Passkey registration failed for client %d: %v", clientID, err)
+		http.Error(w, "Invalid passkey", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	passkey := &models.Passkey{
+		ClientID:       int(clientID),
+		CredentialID:   credential.ID,
+		PublicKey:      credential.PublicKey,
+		SignCount:      credential.SignCount,
+		Transports:     credential.Transports,
+		BackupEligible: credential.BackupEligible,
+		Name:           name,
+		CreatedAt:      time.Now(),
+	}
+	if err := c.Store.WebAuthn().CreatePasskey(passkey); err != nil {
+		if err == repository.ErrDuplicate {
+			http.Error(w, "Passkey already registered", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to store passkey", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Passkey registered",
+		Data: map[string]interface{}{
+			"id":   passkey.ID,
+			"name": name,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// BeginPasskeyLogin starts a passwordless login.
+// @Summary Start passkey login
+// @Description Returns the options for navigator.credentials.get(). Without a contact any passkey discoverable by the browser can be used.
+// @Tags Passkeys
+// @Accept json
+// @Produce json
+// @Param login body models.PasskeyLoginBeginInput false "Contact to limit the login to"
+// @Success 200 {object} models.Response "Credential request options under publicKey"
+// @Router /auth/webauthn/login/begin [post]
+func (c *Controller) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input models.PasskeyLoginBeginInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	rp, err := webAuthnRelyingParty()
+	if err != nil {
+		log.Printf("WebAuthn is not configured: %v", err)
+		http.Error(w, "Passkeys are not available", http.StatusInternalServerError)
+		return
+	}
+
+	// Unknown contacts get the same answer as clients without passkeys
+	var owner *int
+	var allow []webauthn.CredentialDescriptor
+	if input.Contact != "" {
+		client, err := c.Store.Clients().GetByContact(input.Contact)
+		if err != nil && err != repository.ErrNotFound {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			owner = &client.ID
+			if allow, err = c.passkeyDescriptors(client.ID); err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	challenge, err := c.createWebAuthnChallenge(owner, "login")
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Passkey login started",
+		Data: map[string]interface{}{
+			"publicKey": rp.RequestOptions(challenge, allow),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// FinishPasskeyLogin completes a passwordless login.
+// @Summary Finish passkey login
+// @Description Verifies the assertion from navigator.credentials.get() and issues access and refresh tokens. Passkeys verify the user themselves, so no second factor is asked for.
+// @Tags Passkeys
+// @Accept json
+// @Produce json
+// @Param assertion body webauthn.AssertionResponse true "Result of navigator.credentials.get()"
+// @Success 200 {object} models.Response "Login successful with access and refresh token"
+// @Failure 401 {string} string "Invalid passkey"
+// @Failure 403 {string} string "Account not verified or inactive"
+// @Router /auth/webauthn/login/finish [post]
+func (c *Controller) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input webauthn.AssertionResponse
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	rp, err := webAuthnRelyingParty()
+	if err != nil {
+		log.Printf("WebAuthn is not configured: %v", err)
+		http.Error(w, "Passkeys are not available", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, owner, err := c.consumeWebAuthnChallenge(input.Response.ClientDataJSON, "login")
+	if err != nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	var client *models.Client
+	err = c.Store.InTx(func(store repository.Store) error {
+		passkey, err := store.WebAuthn().GetPasskeyForUpdate(input.RawID)
+		if err == repository.ErrNotFound {
+			return errInvalidPasskey
+		}
+		if err != nil {
+			return err
+		}
+
+		if owner != nil && *owner != passkey.ClientID {
+			return errInvalidPasskey
+		}
+		if client, err = store.Clients().GetByID(passkey.ClientID); err != nil {
+			return err
+		}
+		if len(input.Response.UserHandle) > 0 && string(input.Response.UserHandle) != client.UUID {
+			return errInvalidPasskey
+		}
+
+		credential := webauthn.Credential{ID: passkey.CredentialID, PublicKey: passkey.PublicKey, SignCount: passkey.SignCount}
+		signCount, err := rp.VerifyAssertion(challenge, &credential, &input)
+		if err != nil {
+			log.Printf("Passkey login failed for client %d: %v", passkey.ClientID, err)
+			return errInvalidPasskey
+		}
+		return store.WebAuthn().UpdateSignCount(passkey.ID, signCount, time.Now())
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to update passkey")
+		return
+	}
+
+	if !client.IsVerified {
+		http.Error(w, "Account not verified", http.StatusForbidden)
+		return
+	}
+	if !client.IsActive {
+		http.Error(w, "Account is inactive", http.StatusForbidden)
+		return
+	}
+
+	c.completeLogin(w, client)
+}
+
+// ListPasskeys lists the passkeys of the signed in client.
+// @Summary List passkeys
+// @Tags Passkeys
+// @Produce json
+// @Success 200 {object} models.Response "Registered passkeys"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/webauthn/credentials [get]
+func (c *Controller) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stored, err := c.Store.WebAuthn().ListPasskeys(int(clientID))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	passkeys := []map[string]interface{}{}
+	for _, stored := range stored {
+		passkey := map[string]interface{}{
+			"id":         stored.ID,
+			"name":       stored.Name,
+			"created_at": stored.CreatedAt,
+		}
+		if stored.LastUsedAt != nil {
+			passkey["last_used_at"] = *stored.LastUsedAt
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Passkeys",
+		Data: map[string]interface{}{
+			"passkeys": passkeys,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeletePasskey removes a passkey of the signed in client, e.g. when the device is lost.
+// @Summary Delete passkey
+// @Tags Passkeys
+// @Produce json
+// @Param id path int true "Passkey ID"
+// @Success 200 {object} models.Response "Passkey deleted"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 404 {string} string "Passkey not found"
+// @Router /auth/webauthn/credentials/{id} [delete]
+func (c *Controller) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid passkey id", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := c.Store.WebAuthn().DeletePasskey(id, int(clientID))
+	if err != nil {
+		http.Error(w, "Failed to delete passkey", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Passkey deleted",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to build the relying party from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and WEBAUTHN_ORIGINS.
+// Without configuration the issuer is the only origin and its host the relying party id.
+func webAuthnRelyingParty() (*webauthn.RelyingParty, error) {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{strings.TrimSuffix(middleware.Issuer(), "/")}
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		u, err := url.Parse(origins[0])
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("cannot derive relying party id from origin %q", origins[0])
+		}
+		rpID = u.Hostname()
+	}
+
+	name := os.Getenv("WEBAUTHN_RP_NAME")
+	if name == "" {
+		name = totpIssuer()
+	}
+
+	return &webauthn.RelyingParty{
+		ID:      rpID,
+		Name:    name,
+		Origins: origins,
+		Timeout: webAuthnChallengeTTL,
+	}, nil
+}
+
+// Helper function to list the passkeys of a client for allowCredentials and excludeCredentials
+func (c *Controller) passkeyDescriptors(clientID int) ([]webauthn.CredentialDescriptor, error) {
+	passkeys, err := c.Store.WebAuthn().ListPasskeys(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptors []webauthn.CredentialDescriptor
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         passkey.CredentialID,
+			Transports: passkey.Transports,
+		})
+	}
+	return descriptors, nil
+}
+
+// Helper function to store a new ceremony challenge, expired ones are purged on the way
+func (c *Controller) createWebAuthnChallenge(clientID *int, ceremony string) ([]byte, error) {
+	challenge, err := webauthn.GenerateChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err = c.Store.WebAuthn().PurgeChallenges(now); err != nil {
+		log.Printf("Failed to purge expired WebAuthn challenges: %v", err)
+	}
+
+	err = c.Store.WebAuthn().CreateChallenge(&models.WebAuthnChallenge{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		ClientID:  clientID,
+		Ceremony:  ceremony,
+		ExpiresAt: now.Add(webAuthnChallengeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// Helper function to look up the ceremony a response answers and use up its challenge.
+// The challenge is deleted before the response is verified, so a failed attempt can't be retried.
+func (c *Controller) consumeWebAuthnChallenge(clientDataJSON []byte, ceremony string) ([]byte, *int, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	challenge, err := clientData.ChallengeBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := c.Store.WebAuthn().ConsumeChallenge(base64.RawURLEncoding.EncodeToString(challenge), ceremony)
+	if err == repository.ErrNotFound {
+		return nil, nil, errWebAuthnChallenge
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, nil, errWebAuthnChallenge
+	}
+	return challenge, stored.ClientID, nil
+}
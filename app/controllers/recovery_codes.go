@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"github.com/kimoresteve/identity-service/app/utils"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"time"
+)
+
+// Recovery codes handed out when two-factor authentication is enabled or the codes are regenerated
+const recoveryCodeCount = 10
+
+// RecoveryCodes reports how many unused recovery codes are left.
+// @Summary Recovery code status
+// @Tags Two-factor
+// @Produce json
+// @Success 200 {object} models.Response "Number of unused recovery codes"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/2fa/recovery-codes [get]
+func (c *Controller) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	remaining, err := c.Store.MFA().CountRecoveryCodes(int(clientID))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Recovery codes",
+		Data: map[string]interface{}{
+			"remaining": remaining,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the client with a fresh set.
+// @Summary Regenerate recovery codes
+// @Description Invalidates the previous recovery codes. The new codes are only shown once.
+// @Tags Two-factor
+// @Produce json
+// @Success 200 {object} models.Response "New recovery codes and how many were left unused"
+// @Failure 400 {string} string "Two-factor authentication is not enabled"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/2fa/recovery-codes/regenerate [post]
+func (c *Controller) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var codes []string
+	var previous int
+	err := c.Store.InTx(func(store repository.Store) error {
+		settings, err := store.MFA().SettingsForUpdate(int(clientID))
+		if err != nil {
+			return err
+		}
+		if settings.Method == "none" {
+			return utils.NewAPIError("Two-factor authentication is not enabled", http.StatusBadRequest, nil)
+		}
+
+		if previous, err = store.MFA().CountRecoveryCodes(int(clientID)); err != nil {
+			return err
+		}
+		codes, err = issueRecoveryCodes(store, int(clientID))
+		return err
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to generate recovery codes")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Recovery codes regenerated, store them somewhere safe",
+		Data: map[string]interface{}{
+			"recovery_codes":     codes,
+			"remaining":          len(codes),
+			"previous_remaining": previous,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// issueRecoveryCodes replaces the recovery codes of a client and returns the new codes in plain text
+func issueRecoveryCodes(store repository.Store, clientID int) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(utils.NormalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash recovery code: %v", err)
+		}
+		hashes = append(hashes, string(hash))
+	}
+
+	if err := store.MFA().ReplaceRecoveryCodes(clientID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkRecoveryCode compares a code with the unused recovery codes of the client and uses it up on success
+func checkRecoveryCode(store repository.Store, clientID int, code string) (bool, error) {
+	stored, err := store.MFA().UnusedRecoveryCodes(clientID)
+	if err != nil {
+		return false, err
+	}
+
+	normalized := []byte(utils.NormalizeRecoveryCode(code))
+	for _, sc := range stored {
+		if bcrypt.CompareHashAndPassword([]byte(sc.Hash), normalized) != nil {
+			continue
+		}
+		if err := store.MFA().UseRecoveryCode(sc.ID, time.Now()); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
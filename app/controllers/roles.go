@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"strconv"
+)
+
+// Helper function to read the caller and the role id from the path
+func roleRequest(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	roleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid role id", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return int(callerID), roleID, true
+}
+
+// Helper function to answer with data under a key
+func writeData(w http.ResponseWriter, status int, message, key string, value interface{}) {
+	response := models.Response{
+		Success: true,
+		Message: message,
+		Data: map[string]interface{}{
+			key: value,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListPermissions returns the permission catalog roles are built from.
+// @Summary List permissions
+// @Tags Roles
+// @Produce json
+// @Success 200 {object} models.Response "Permissions"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/permissions [get]
+func (c *Controller) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	permissions, err := c.Auth.ListPermissions()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	writeData(w, http.StatusOK, "Permissions", "permissions", permissions)
+}
+
+// CreateRole defines a role of the agency or landlord.
+// @Summary Create a role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param role body models.RoleInput true "Role"
+// @Success 201 {object} models.Response "Role created"
+// @Failure 400 {string} string "Invalid request or unknown permission"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 409 {string} string "A role with this name already exists"
+// @Router /auth/roles [post]
+func (c *Controller) CreateRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input models.RoleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	role, err := c.Auth.CreateRole(int(callerID), auth.NewRole{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to create role")
+		return
+	}
+
+	writeData(w, http.StatusCreated, "Role created", "role", role)
+}
+
+// ListRoles returns the roles of the agency or landlord with their permissions.
+// @Summary List roles
+// @Tags Roles
+// @Produce json
+// @Success 200 {object} models.Response "Roles"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Router /auth/roles [get]
+func (c *Controller) ListRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roles, err := c.Auth.ListRoles(int(callerID))
+	if err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	writeData(w, http.StatusOK, "Roles", "roles", roles)
+}
+
+// GetRole returns one role of the agency or landlord.
+// @Summary Get a role
+// @Tags Roles
+// @Produce json
+// @Param id path int true "Role ID"
+// @Success 200 {object} models.Response "Role"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "Role not found"
+// @Router /auth/roles/{id} [get]
+func (c *Controller) GetRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, roleID, ok := roleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	role, err := c.Auth.GetRole(callerID, roleID)
+	if err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	writeData(w, http.StatusOK, "Role", "role", role)
+}
+
+// UpdateRole renames a role or replaces its permissions, the users holding it get the new permissions.
+// @Summary Update a role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param id path int true "Role ID"
+// @Param role body models.RoleUpdateInput true "Fields to change"
+// @Success 200 {object} models.Response "Role updated"
+// @Failure 400 {string} string "Invalid request or unknown permission"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "Role not found"
+// @Failure 409 {string} string "A role with this name already exists"
+// @Router /auth/roles/{id} [patch]
+func (c *Controller) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, roleID, ok := roleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.RoleUpdateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	role, err := c.Auth.UpdateRole(callerID, roleID, auth.RoleUpdate{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to update role")
+		return
+	}
+
+	writeData(w, http.StatusOK, "Role updated", "role", role)
+}
+
+// DeleteRole removes a role, the users holding it lose it.
+// @Summary Delete a role
+// @Tags Roles
+// @Produce json
+// @Param id path int true "Role ID"
+// @Success 200 {object} models.Response "Role deleted"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "Role not found"
+// @Router /auth/roles/{id} [delete]
+func (c *Controller) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, roleID, ok := roleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.Auth.DeleteRole(callerID, roleID); err != nil {
+		writeAuthError(w, err, "Failed to delete role")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Role deleted",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetUserRoles returns the roles assigned to a staff account.
+// @Summary List the roles of a user
+// @Tags Roles
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.Response "Roles"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User not found"
+// @Router /auth/users/{id}/roles [get]
+func (c *Controller) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := c.Auth.UserRoles(callerID, userID)
+	if err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	writeData(w, http.StatusOK, "Roles", "roles", roles)
+}
+
+// SetUserRoles replaces the roles assigned to a staff account.
+// @Summary Assign roles to a user
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param roles body models.UserRolesInput true "Roles the user should have, an empty list removes them all"
+// @Success 200 {object} models.Response "Roles assigned"
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User or role not found"
+// @Router /auth/users/{id}/roles [put]
+func (c *Controller) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.UserRolesInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	roles, err := c.Auth.SetUserRoles(callerID, userID, input.RoleIDs)
+	if err != nil {
+		writeAuthError(w, err, "Failed to assign roles")
+		return
+	}
+
+	writeData(w, http.StatusOK, "Roles assigned", "roles", roles)
+}
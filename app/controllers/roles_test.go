@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"testing"
+)
+
+func TestAssignedRoleTakesEffect(t *testing.T) {
+	c, _ := newTestController(t)
+	owner := createTestClient(t, c, "254700000501")
+	staff := createTestUser(t, c, owner, "254700000502")
+	colleague := createTestUser(t, c, owner, "254700000503")
+
+	updateUser := middleware.RequirePermission("users:write")(http.HandlerFunc(c.UpdateUser))
+	position := "Caretaker"
+	update := models.UserUpdateInput{Position: &position}
+	if rec := send(t, c, updateUser, http.MethodPatch, "/auth/users/{id}", idVars(colleague.ID), update, staff.ID); rec.Code != http.StatusForbidden {
+		t.Fatalf("status without a role = %d, want 403", rec.Code)
+	}
+
+	// The owner defines a role and assigns it through the API
+	createRole := middleware.RequirePermission("roles:write")(http.HandlerFunc(c.CreateRole))
+	input := models.RoleInput{Name: "User admins", Permissions: []string{"roles:read", "users:read", "users:write"}}
+	role := responseData(t, send(t, c, createRole, http.MethodPost, "/auth/roles", nil, input, owner.ID))["role"].(map[string]interface{})
+
+	setUserRoles := middleware.RequirePermission("roles:write")(http.HandlerFunc(c.SetUserRoles))
+	assignment := models.UserRolesInput{RoleIDs: []int{int(role["id"].(float64))}}
+	responseData(t, send(t, c, setUserRoles, http.MethodPut, "/auth/users/{id}/roles", idVars(staff.ID), assignment, owner.ID))
+
+	// A new token carries the permissions of the role
+	responseData(t, send(t, c, updateUser, http.MethodPatch, "/auth/users/{id}", idVars(colleague.ID), update, staff.ID))
+	user, err := c.Store.Users().GetByID(colleague.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Position != position {
+		t.Fatalf("position = %q, want %q", user.Position, position)
+	}
+
+	listRoles := middleware.RequirePermission("roles:read")(http.HandlerFunc(c.ListRoles))
+	roles := responseData(t, send(t, c, listRoles, http.MethodGet, "/auth/roles", nil, nil, staff.ID))["roles"].([]interface{})
+	if len(roles) != 1 || roles[0].(map[string]interface{})["id"] != role["id"] {
+		t.Fatalf("staff sees roles %v, want the owner's role", roles)
+	}
+
+	// The role doesn't grant roles:write, so the staff member can't grant itself more
+	if rec := send(t, c, createRole, http.MethodPost, "/auth/roles", nil, input, staff.ID); rec.Code != http.StatusForbidden {
+		t.Fatalf("create role status = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+)
+
+// RegisterServiceClient creates a confidential service account allowed to request the given scopes
+// and returns its client ID and secret. The secret is only stored hashed.
+func (c *Controller) RegisterServiceClient(serviceName string, scopes []string) (string, string, error) {
+	clientID, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, _, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to hash client secret: %v", err)
+	}
+
+	err = c.Store.OAuth().CreateClient(&models.OAuthClient{
+		ClientID:    clientID,
+		SecretHash:  string(hash),
+		Name:        serviceName,
+		ServiceName: serviceName,
+		Scopes:      scopes,
+		GrantTypes:  []string{"client_credentials"},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return clientID, secret, nil
+}
+
+// exchangeClientCredentials issues a service token for an authenticated service client
+func (c *Controller) exchangeClientCredentials(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	// Only confidential clients can prove who they are without a user
+	if client.SecretHash == "" {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	scopes := client.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !containsString(client.Scopes, scope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("Scope %s is not allowed", scope))
+				return
+			}
+		}
+		scopes = requested
+	}
+	scope := strings.Join(scopes, " ")
+
+	serviceName := client.Name
+	if client.ServiceName != "" {
+		serviceName = client.ServiceName
+	}
+
+	token, err := middleware.GenerateServiceToken(serviceName, scope)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	writeTokenResponse(w, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(middleware.ServiceTokenTTL.Seconds()),
+		"scope":        scope,
+	})
+}
+
+// Helper function used by endpoints that only service clients may call
+func (c *Controller) authenticateServiceClient(r *http.Request) (*models.OAuthClient, error) {
+	client, err := c.authenticateOAuthClient(r)
+	if err != nil {
+		return nil, err
+	}
+	if client.SecretHash == "" || !containsString(client.GrantTypes, "client_credentials") {
+		return nil, errInvalidOAuthClient
+	}
+	return client, nil
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"net/http"
+	"strings"
+)
+
+var (
+	errMessageTypeUnsupported = errors.New("type must be " + strings.Join(notify.MessageTypes, ", "))
+	errNotTemplateTenant      = errors.New("Only agencies and landlords can manage message templates")
+)
+
+// Helper function to check the client may manage templates, they are shared by the clients it owns
+func (c *Controller) templateTenant(r *http.Request) (int, error) {
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		return 0, auth.ErrClientNotFound
+	}
+
+	client, err := c.Store.Clients().GetByID(int(clientID))
+	if err != nil {
+		return 0, err
+	}
+	if client.Type != models.ClientTypeAgency && client.Type != models.ClientTypeLandlord {
+		return 0, errNotTemplateTenant
+	}
+	return int(clientID), nil
+}
+
+// Helper function to answer a failed templateTenant
+func writeTemplateTenantError(w http.ResponseWriter, err error) {
+	switch err {
+	case auth.ErrClientNotFound, repository.ErrNotFound:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case errNotTemplateTenant:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// SetPreferredLanguage changes the language notifications are sent to the client in.
+// @Summary Set preferred language
+// @Tags Client
+// @Accept json
+// @Produce json
+// @Param language body models.PreferredLanguageInput true "Preferred language"
+// @Success 200 {object} models.Response "Preferred language updated"
+// @Failure 400 {string} string "Unsupported language"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/preferred-language [put]
+func (c *Controller) SetPreferredLanguage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input models.PreferredLanguageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if input.PreferredLanguage == "" {
+		http.Error(w, auth.ErrLanguageUnsupported.Error(), http.StatusBadRequest)
+		return
+	}
+	locale, err := auth.PreferredLocale(input.PreferredLanguage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = c.Store.Clients().SetPreferredLanguage(int(clientID), locale); err != nil {
+		http.Error(w, "Failed to update preferred language", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Preferred language updated",
+		Data: map[string]interface{}{
+			"preferred_language": locale,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListMessageTemplates returns the template overrides of the agency or landlord with the built in defaults.
+// @Summary List message templates
+// @Tags Templates
+// @Produce json
+// @Success 200 {object} models.Response "Overrides, message types and locales"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency or landlord"
+// @Router /auth/templates [get]
+func (c *Controller) ListMessageTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := c.templateTenant(r)
+	if err != nil {
+		writeTemplateTenantError(w, err)
+		return
+	}
+
+	overrides, err := c.Store.Templates().List(tenantID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Message templates",
+		Data: map[string]interface{}{
+			"templates": overrides,
+			"types":     notify.MessageTypes,
+			"locales":   notify.Locales,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SaveMessageTemplate overrides a message type in one language for the clients of the agency or landlord.
+// @Summary Save a message template
+// @Description Subject and body are Go text/template sources. They can use {{.Name}}, {{.Code}} and {{.ExpiresIn}} (minutes). An empty subject keeps the built in one.
+// @Tags Templates
+// @Accept json
+// @Produce json
+// @Param template body models.MessageTemplateInput true "Template"
+// @Success 200 {object} models.Response "Template saved"
+// @Failure 400 {string} string "Unknown type or locale, or the template does not render"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency or landlord"
+// @Router /auth/templates [put]
+func (c *Controller) SaveMessageTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := c.templateTenant(r)
+	if err != nil {
+		writeTemplateTenantError(w, err)
+		return
+	}
+
+	var input models.MessageTemplateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	kind, locale, err := checkTemplateKey(input.Type, input.Locale)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	template := notify.Template{Subject: input.Subject, Body: input.Body}
+	if err = notify.ValidateTemplate(template); err != nil {
+		http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = c.Store.Templates().Save(&models.MessageTemplate{
+		TenantID: tenantID,
+		Type:     kind,
+		Locale:   locale,
+		Subject:  template.Subject,
+		Body:     template.Body,
+	})
+	if err != nil {
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Template saved",
+		Data: map[string]interface{}{
+			"type":   kind,
+			"locale": locale,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteMessageTemplate removes an override, the built in template is used again.
+// @Summary Delete a message template
+// @Tags Templates
+// @Produce json
+// @Param type path string true "Message type"
+// @Param locale path string true "Locale"
+// @Success 200 {object} models.Response "Template deleted"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Not an agency or landlord"
+// @Failure 404 {string} string "Template not found"
+// @Router /auth/templates/{type}/{locale} [delete]
+func (c *Controller) DeleteMessageTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID, err := c.templateTenant(r)
+	if err != nil {
+		writeTemplateTenantError(w, err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	deleted, err := c.Store.Templates().Delete(tenantID, vars["type"], vars["locale"])
+	if err != nil {
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Template deleted",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to check the message type and locale of a template
+func checkTemplateKey(kind, locale string) (string, string, error) {
+	known := false
+	for _, t := range notify.MessageTypes {
+		known = known || t == kind
+	}
+	if !known {
+		return "", "", errMessageTypeUnsupported
+	}
+	if locale == "" {
+		return "", "", errors.New("locale is required")
+	}
+	locale, err := auth.PreferredLocale(locale)
+	if err != nil {
+		return "", "", errors.New("locale must be " + strings.Join(notify.Locales, " or "))
+	}
+	return kind, locale, nil
+}
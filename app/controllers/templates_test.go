@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSaveMessageTemplateRejectsBrokenTemplates(t *testing.T) {
+	c, _ := newTestController(t)
+	owner := createTestClient(t, c, "254700000701")
+	save := middleware.JWTMiddleware(http.HandlerFunc(c.SaveMessageTemplate))
+
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		err     string
+	}{
+		{"body doesn't parse", "", "Your code is {{.Code", "invalid body"},
+		{"subject doesn't parse", "{{if .Name}}", "Your code is {{.Code}}", "invalid subject"},
+		{"unknown field", "", "Your code is {{.Pin}}", "failed to render body"},
+		{"unknown template", "", "{{template \"footer\"}} {{.Code}}", "failed to render body"},
+		{"call of a field", "{{call .Name}}", "Your code is {{.Code}}", "failed to render subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := models.MessageTemplateInput{Type: notify.MessageActivationOTP, Locale: "en", Subject: tt.subject, Body: tt.body}
+			rec := send(t, c, save, http.MethodPut, "/auth/templates", nil, input, owner.ID)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.err) {
+				t.Fatalf("status = %d (%s), want 400 with %q", rec.Code, strings.TrimSpace(rec.Body.String()), tt.err)
+			}
+		})
+	}
+
+	templates, err := c.Store.Templates().List(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 0 {
+		t.Fatalf("%d broken templates were saved", len(templates))
+	}
+
+	// A template that renders is saved
+	input := models.MessageTemplateInput{Type: notify.MessageActivationOTP, Locale: "sw", Body: "Nambari {{.Code}}"}
+	if data := responseData(t, send(t, c, save, http.MethodPut, "/auth/templates", nil, input, owner.ID)); data["locale"] != "sw" {
+		t.Fatalf("saved for locale %v, want sw", data["locale"])
+	}
+}
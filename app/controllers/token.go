@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RefreshToken exchanges a refresh token for a new access and refresh token pair.
+// @Summary Refresh tokens
+// @Description Rotates a refresh token. Replaying a token that was already rotated revokes the whole token family.
+// @Tags Client
+// @Accept json
+// @Produce json
+// @Param refresh body models.RefreshTokenInput true "Refresh token"
+// @Success 200 {object} models.Response "Tokens refreshed successfully"
+// @Failure 400 {string} string "Invalid request payload"
+// @Failure 401 {string} string "Invalid, expired or revoked refresh token"
+// @Router /auth/refresh [post]
+func (c *Controller) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input models.RefreshTokenInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Tokens issued to relying parties are only rotated at /oauth/token
+	rotated, err := c.rotateRefreshToken(input.RefreshToken, "")
+	if err != nil {
+		switch err {
+		case errRefreshTokenInvalid, errRefreshTokenRevoked, errRefreshTokenExpired, errRefreshTokenClient, errClientInactive:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			http.Error(w, "Failed to rotate refresh token", http.StatusInternalServerError)
+			fmt.Printf("Error rotating refresh token: %s", err.Error())
+		}
+		return
+	}
+
+	token, err := c.accessToken(rotated.ClientID, rotated.Scope)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Tokens refreshed successfully",
+		Data: map[string]interface{}{
+			"token":         token,
+			"refresh_token": rotated.RefreshToken,
+			"token_type":    "Bearer",
+			"expires_in":    int(middleware.TokenTTL.Seconds()),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Logout revokes the access token used for the request.
+// @Summary Logout
+// @Description Revokes the current access token and, when given, the refresh token family it was issued with
+// @Tags Client
+// @Accept json
+// @Produce json
+// @Param logout body models.LogoutInput false "Refresh token to revoke"
+// @Success 200 {object} models.Response "Logged out successfully"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/logout [post]
+func (c *Controller) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The body is optional, a bare logout only revokes the access token
+	var input models.LogoutInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	}
+	defer r.Body.Close()
+
+	now := time.Now()
+	err := c.Store.InTx(func(store repository.Store) error {
+		if err := revokeAccessToken(store, claims); err != nil {
+			return err
+		}
+
+		// Only the caller's own sessions can be ended, unknown tokens are ignored
+		if input.RefreshToken != "" {
+			token, err := store.Tokens().GetRefreshToken(middleware.HashRefreshToken(input.RefreshToken))
+			if err != nil && err != repository.ErrNotFound {
+				return err
+			}
+			if err == nil && token.ClientID == int(claims.ClientID) {
+				if err := store.Tokens().RevokeRefreshFamily(token.FamilyID, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Expired entries no longer need to be on the denylist
+		return store.Tokens().PurgeRevokedAccessTokens(now)
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to revoke token")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Logged out successfully",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// LogoutAll revokes every token issued to the client.
+// @Summary Logout from all devices
+// @Description Invalidates all access tokens issued so far and revokes every refresh token of the client
+// @Tags Client
+// @Produce json
+// @Success 200 {object} models.Response "Logged out of all sessions"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Router /auth/logout-all [post]
+func (c *Controller) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	err := c.Store.InTx(func(store repository.Store) error {
+		if err := store.Tokens().SetInvalidBefore(int(clientID), now); err != nil {
+			return err
+		}
+		return store.Tokens().RevokeClientRefreshTokens(int(clientID), now)
+	})
+	if err != nil {
+		writeAPIError(w, err, "Failed to revoke tokens")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Logged out of all sessions",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Helper function to put an access token on the denylist until it expires
+func revokeAccessToken(store repository.Store, claims *middleware.Claims) error {
+	// Tokens issued before jti was introduced can only be revoked by logout-all
+	if claims.ID == "" {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(middleware.TokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return store.Tokens().RevokeAccessToken(claims.ID, int(claims.ClientID), expiresAt)
+}
+
+var (
+	errRefreshTokenInvalid = errors.New("Invalid refresh token")
+	errRefreshTokenRevoked = errors.New("Refresh token has been revoked")
+	errRefreshTokenExpired = errors.New("Refresh token expired")
+	errRefreshTokenClient  = errors.New("Refresh token was issued to another client")
+	errClientInactive      = errors.New("Client is not active")
+)
+
+type rotatedRefreshToken struct {
+	ClientID     int
+	RefreshToken string
+	Scope        string
+}
+
+// Helper function to exchange a refresh token for a new one in the same family. oauthClientID is the
+// relying party presenting the token, empty for first-party clients.
+// Replaying a token that was already rotated revokes the whole family.
+func (c *Controller) rotateRefreshToken(refreshToken, oauthClientID string) (*rotatedRefreshToken, error) {
+	var rotated *rotatedRefreshToken
+	var reused *models.RefreshToken
+
+	err := c.Store.InTx(func(store repository.Store) error {
+		stored, err := store.Tokens().GetRefreshTokenForUpdate(middleware.HashRefreshToken(refreshToken))
+		if err == repository.ErrNotFound {
+			return errRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if stored.OAuthClientID != oauthClientID {
+			return errRefreshTokenClient
+		}
+
+		now := time.Now()
+
+		// Token was revoked by a logout
+		if stored.RevokedAt != nil && stored.ReplacedBy == nil {
+			return errRefreshTokenRevoked
+		}
+
+		// A rotated token being presented again means it was copied, kill the whole session.
+		// The revocation has to be committed, so this is not returned as an error.
+		if stored.RevokedAt != nil {
+			reused = stored
+			return store.Tokens().RevokeRefreshFamily(stored.FamilyID, now)
+		}
+
+		if now.After(stored.ExpiresAt) {
+			return errRefreshTokenExpired
+		}
+		client, err := store.Clients().GetByID(stored.ClientID)
+		if err != nil {
+			return err
+		}
+		if !client.IsActive {
+			return errClientInactive
+		}
+
+		newToken, next, err := storeRefreshToken(store, stored, now)
+		if err != nil {
+			return err
+		}
+		if err := store.Tokens().MarkRefreshTokenReplaced(stored.ID, next.ID, now); err != nil {
+			return err
+		}
+
+		rotated = &rotatedRefreshToken{
+			ClientID:     stored.ClientID,
+			RefreshToken: newToken,
+			Scope:        stored.Scope,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused != nil {
+		log.Printf("Refresh token reuse detected for client %d, family %s revoked", reused.ClientID, reused.FamilyID)
+		return nil, errRefreshTokenRevoked
+	}
+	return rotated, nil
+}
+
+// Helper function to issue an access token carrying the client's role, user id and, when they
+// are embedded, permissions
+func (c *Controller) accessToken(clientID int, scope string) (string, error) {
+	subject, err := c.Auth.TokenSubject(clientID)
+	if err != nil {
+		return "", err
+	}
+	return middleware.GenerateAccessToken(subject, scope)
+}
+
+// Helper function to start a new refresh token family for a fresh login, oauthClientID is the
+// relying party the tokens are issued to, empty for first-party logins
+func issueRefreshToken(store repository.Store, clientID int, scope, oauthClientID string, now time.Time) (string, error) {
+	familyID, err := middleware.GenerateTokenFamily()
+	if err != nil {
+		return "", err
+	}
+
+	family := &models.RefreshToken{ClientID: clientID, FamilyID: familyID, Scope: scope, OAuthClientID: oauthClientID}
+	token, _, err := storeRefreshToken(store, family, now)
+	return token, err
+}
+
+// Helper function to persist a new refresh token in the family of the given one
+func storeRefreshToken(store repository.Store, family *models.RefreshToken, now time.Time) (string, *models.RefreshToken, error) {
+	token, hash, err := middleware.GenerateRefreshToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	stored := &models.RefreshToken{
+		ClientID:      family.ClientID,
+		Hash:          hash,
+		FamilyID:      family.FamilyID,
+		Scope:         family.Scope,
+		OAuthClientID: family.OAuthClientID,
+		ExpiresAt:     now.Add(middleware.RefreshTokenTTL),
+		CreatedAt:     now,
+	}
+	if err := store.Tokens().CreateRefreshToken(stored); err != nil {
+		return "", nil, err
+	}
+	return token, stored, nil
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"testing"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	c, _ := newTestController(t)
+	createTestClient(t, c, "254700000001")
+
+	first := login(t, c, "254700000001")["refresh_token"].(string)
+
+	rec := serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: first}, "")
+	second := responseData(t, rec)["refresh_token"].(string)
+	if second == "" || second == first {
+		t.Fatalf("refresh token was not rotated")
+	}
+
+	// Replaying the rotated token revokes the family, the token it was rotated into included
+	rec = serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: first}, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replay status = %d, want 401", rec.Code)
+	}
+	rec = serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: second}, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status after reuse = %d, want 401", rec.Code)
+	}
+}
+
+func TestRefreshTokenInactiveClient(t *testing.T) {
+	c, _ := newTestController(t)
+	client := createTestClient(t, c, "254700000002")
+	refreshToken := login(t, c, "254700000002")["refresh_token"].(string)
+
+	if err := c.Store.Clients().SetActive(client.ID, false, client.UpdatedAt); err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: refreshToken}, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	c, _ := newTestController(t)
+	createTestClient(t, c, "254700000003")
+	data := login(t, c, "254700000003")
+	token, refreshToken := data["token"].(string), data["refresh_token"].(string)
+
+	logout := middleware.JWTMiddleware(http.HandlerFunc(c.Logout))
+	responseData(t, serve(t, logout, "/auth/logout", models.LogoutInput{RefreshToken: refreshToken}, token))
+
+	// Both the access token and the refresh token stop working
+	if rec := serve(t, logout, "/auth/logout", nil, token); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("access token status = %d, want 401", rec.Code)
+	}
+	rec := serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: refreshToken}, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token status = %d, want 401", rec.Code)
+	}
+}
+
+func TestLogoutKeepsOtherClientsTokens(t *testing.T) {
+	c, _ := newTestController(t)
+	createTestClient(t, c, "254700000004")
+	createTestClient(t, c, "254700000005")
+	token := login(t, c, "254700000004")["token"].(string)
+	otherRefreshToken := login(t, c, "254700000005")["refresh_token"].(string)
+
+	logout := middleware.JWTMiddleware(http.HandlerFunc(c.Logout))
+	responseData(t, serve(t, logout, "/auth/logout", models.LogoutInput{RefreshToken: otherRefreshToken}, token))
+
+	rec := serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: otherRefreshToken}, "")
+	responseData(t, rec)
+}
+
+func TestLogoutAll(t *testing.T) {
+	c, _ := newTestController(t)
+	createTestClient(t, c, "254700000006")
+	first := login(t, c, "254700000006")
+	second := login(t, c, "254700000006")
+
+	logoutAll := middleware.JWTMiddleware(http.HandlerFunc(c.LogoutAll))
+	responseData(t, serve(t, logoutAll, "/auth/logout-all", nil, first["token"].(string)))
+
+	for _, data := range []map[string]interface{}{first, second} {
+		rec := serve(t, http.HandlerFunc(c.RefreshToken), "/auth/refresh", models.RefreshTokenInput{RefreshToken: data["refresh_token"].(string)}, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("refresh token status = %d, want 401", rec.Code)
+		}
+	}
+}
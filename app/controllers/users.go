@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kimoresteve/identity-service/app/auth"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"strconv"
+)
+
+// Helper function to describe a user in a response, without its password hash
+func userData(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 user.ID,
+		"uuid":               user.UUID,
+		"first_name":         user.FirstName,
+		"last_name":          user.LastName,
+		"position":           user.Position,
+		"email":              user.Email,
+		"contact":            user.Contact,
+		"is_verified":        user.IsVerified,
+		"is_active":          user.IsActive,
+		"preferred_language": user.PreferredLanguage,
+		"owner_id":           user.OwnerID,
+		"owner_type":         user.OwnerType,
+		"created_at":         user.CreatedAt,
+		"updated_at":         user.UpdatedAt,
+	}
+}
+
+// Helper function to read the caller and the user id from the path
+func userRequest(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return int(callerID), userID, true
+}
+
+// Helper function to answer with a single user
+func writeUser(w http.ResponseWriter, status int, message string, user *models.User) {
+	response := models.Response{
+		Success: true,
+		Message: message,
+		Data:    userData(user),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// AddUser creates a staff account for the agency or landlord.
+// @Summary Create a user
+// @Description Creates a user under the caller's tenant. The user gets an activation code and signs in once verified.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param user body models.UserInput true "User"
+// @Success 201 {object} models.Response "User created"
+// @Failure 400 {string} string "Invalid request or missing fields"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 409 {string} string "Email or contact already exists"
+// @Router /auth/users [post]
+func (c *Controller) AddUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input models.UserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := c.Auth.CreateUser(int(callerID), auth.NewUser{
+		FirstName:         input.FirstName,
+		LastName:          input.LastName,
+		Position:          input.Position,
+		Email:             input.Email,
+		Contact:           input.Contact,
+		Password:          input.Password,
+		OTPChannel:        input.OTPChannel,
+		PreferredLanguage: input.PreferredLanguage,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to create user")
+		return
+	}
+
+	writeUser(w, http.StatusCreated, "User created successfully", user)
+}
+
+// ListUsers returns the staff accounts of the agency or landlord.
+// @Summary List users
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.Response "Users"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Router /auth/users [get]
+func (c *Controller) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, ok := middleware.GetClientIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := c.Auth.ListUsers(int(callerID))
+	if err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	list := make([]map[string]interface{}, 0, len(users))
+	for i := range users {
+		list = append(list, userData(&users[i]))
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "Users",
+		Data: map[string]interface{}{
+			"users": list,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetUser returns one staff account of the agency or landlord.
+// @Summary Get a user
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.Response "User"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User not found"
+// @Router /auth/users/{id} [get]
+func (c *Controller) GetUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := c.Auth.GetUser(callerID, userID)
+	if err != nil {
+		writeAuthError(w, err, "Database error")
+		return
+	}
+
+	writeUser(w, http.StatusOK, "User", user)
+}
+
+// UpdateUser changes the profile of a staff account.
+// @Summary Update a user
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param user body models.UserUpdateInput true "Fields to change"
+// @Success 200 {object} models.Response "User updated"
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User not found"
+// @Router /auth/users/{id} [patch]
+func (c *Controller) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.UserUpdateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := c.Auth.UpdateUser(callerID, userID, auth.UserUpdate{
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Position:  input.Position,
+	})
+	if err != nil {
+		writeAuthError(w, err, "Failed to update user")
+		return
+	}
+
+	writeUser(w, http.StatusOK, "User updated", user)
+}
+
+// DeactivateUser stops a staff account from signing in, its tokens stop working.
+// @Summary Deactivate a user
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.Response "User deactivated"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User not found"
+// @Router /auth/users/{id}/deactivate [post]
+func (c *Controller) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	c.setUserActive(w, r, false, "User deactivated")
+}
+
+// ActivateUser lets a deactivated staff account sign in again.
+// @Summary Activate a user
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.Response "User activated"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User not found"
+// @Router /auth/users/{id}/activate [post]
+func (c *Controller) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	c.setUserActive(w, r, true, "User activated")
+}
+
+// Helper function to activate or deactivate the user in the path
+func (c *Controller) setUserActive(w http.ResponseWriter, r *http.Request, active bool, message string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := c.Auth.SetUserActive(callerID, userID, active)
+	if err != nil {
+		writeAuthError(w, err, "Failed to update user")
+		return
+	}
+
+	writeUser(w, http.StatusOK, message, user)
+}
+
+// DeleteUser removes a staff account.
+// @Summary Delete a user
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.Response "User deleted"
+// @Failure 401 {string} string "Missing or invalid token"
+// @Failure 403 {string} string "Missing permission"
+// @Failure 404 {string} string "User not found"
+// @Router /auth/users/{id} [delete]
+func (c *Controller) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := userRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.Auth.DeleteUser(callerID, userID); err != nil {
+		writeAuthError(w, err, "Failed to delete user")
+		return
+	}
+
+	response := models.Response{
+		Success: true,
+		Message: "User deleted",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
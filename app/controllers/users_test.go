@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// createTestUser stores a staff account of the owner without roles
+func createTestUser(t *testing.T, c *Controller, owner *models.Client, contact string) *models.User {
+	t.Helper()
+
+	now := time.Now()
+	user := &models.User{
+		Client: models.Client{
+			UUID:      "uuid-" + contact,
+			Type:      models.ClientTypeUser,
+			Name:      "Staff Member",
+			Contact:   contact,
+			IsActive:  true,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		FirstName: "Staff",
+		LastName:  "Member",
+		OwnerID:   owner.ID,
+		OwnerType: string(owner.Type),
+	}
+	if err := c.Store.Clients().Create(&user.Client); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store.Users().Create(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// grantPermissions gives the user a role of its owner holding the permissions
+func grantPermissions(t *testing.T, c *Controller, user *models.User, permissions ...string) {
+	t.Helper()
+
+	role := &models.Role{Name: "role-" + user.Contact, OwnerID: user.OwnerID, OwnerType: user.OwnerType, Permissions: permissions}
+	if err := c.Store.Roles().Create(role); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store.Roles().SetUserRoles(user.ID, []int{role.ID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListUsersRequiresPermission(t *testing.T) {
+	c, _ := newTestController(t)
+	owner := createTestClient(t, c, "254700000401")
+	staff := createTestUser(t, c, owner, "254700000402")
+
+	listUsers := middleware.RequirePermission("users:read")(http.HandlerFunc(c.ListUsers))
+
+	// Staff without a role granting users:read are turned away, owners hold every permission
+	if rec := send(t, c, listUsers, http.MethodGet, "/auth/users", nil, nil, staff.ID); rec.Code != http.StatusForbidden {
+		t.Fatalf("staff status = %d, want 403", rec.Code)
+	}
+	if rec := send(t, c, listUsers, http.MethodGet, "/auth/users", nil, nil, owner.ID); rec.Code != http.StatusOK {
+		t.Fatalf("owner status = %d, want 200", rec.Code)
+	}
+}
+
+func TestStaffActsForItsOwner(t *testing.T) {
+	c, _ := newTestController(t)
+	owner := createTestClient(t, c, "254700000403")
+	staff := createTestUser(t, c, owner, "254700000404")
+	colleague := createTestUser(t, c, owner, "254700000405")
+	other := createTestClient(t, c, "254700000406")
+	stranger := createTestUser(t, c, other, "254700000407")
+	grantPermissions(t, c, staff, "users:read")
+
+	listUsers := middleware.RequirePermission("users:read")(http.HandlerFunc(c.ListUsers))
+	rec := send(t, c, listUsers, http.MethodGet, "/auth/users", nil, nil, staff.ID)
+	listed := map[int]bool{}
+	for _, user := range responseData(t, rec)["users"].([]interface{}) {
+		listed[int(user.(map[string]interface{})["id"].(float64))] = true
+	}
+	if len(listed) != 2 || !listed[staff.ID] || !listed[colleague.ID] {
+		t.Fatalf("listed users %v, want %d and %d", listed, staff.ID, colleague.ID)
+	}
+
+	// The users of another owner can't be reached
+	getUser := middleware.RequirePermission("users:read")(http.HandlerFunc(c.GetUser))
+	rec = send(t, c, getUser, http.MethodGet, "/auth/users/{id}", idVars(colleague.ID), nil, staff.ID)
+	responseData(t, rec)
+	rec = send(t, c, getUser, http.MethodGet, "/auth/users/{id}", idVars(stranger.ID), nil, staff.ID)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("other owner's user status = %d, want 404", rec.Code)
+	}
+}
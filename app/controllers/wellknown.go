@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"encoding/json"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"log"
+	"net/http"
+)
+
+// JWKS publishes the public keys tokens are signed with.
+// @Summary JSON Web Key Set
+// @Description Public keys downstream services use to verify tokens, including rotated keys whose tokens have not expired yet
+// @Tags Keys
+// @Produce json
+// @Success 200 {object} middleware.JWKS "Key set"
+// @Router /.well-known/jwks.json [get]
+func (c *Controller) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(middleware.SigningKeys().JWKS()); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
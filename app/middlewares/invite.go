@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"strconv"
+	"time"
+)
+
+// InvitePurpose marks the tokens sent with staff invitations
+const InvitePurpose = "invite"
+
+// GenerateInviteToken creates the token an invitee accepts an invitation with. The returned jti is
+// stored on the invitation, only the token sent last is accepted.
+func GenerateInviteToken(invitationID int, now time.Time) (token, jti string, expiresAt time.Time, err error) {
+	jti, err = generateTokenID()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	expiresAt = now.Add(InviteTokenTTL)
+
+	claims := &Claims{
+		Purpose: InvitePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(invitationID),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    Issuer(),
+			ID:        jti,
+		},
+	}
+
+	token, err = signingKeys.Sign(claims)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, jti, expiresAt, nil
+}
+
+// ValidateInviteToken validates an invite token and returns the invitation it was sent for with its jti
+func ValidateInviteToken(tokenString string) (int, string, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+	if claims.Purpose != InvitePurpose {
+		return 0, "", fmt.Errorf("token is not an invite token")
+	}
+
+	invitationID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid invite token subject")
+	}
+	return invitationID, claims.ID, nil
+}
@@ -7,32 +7,157 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // JWT configuration from environment variables
-var  (
-	jwtSecret []byte
-	tokenTTL  time.Duration
+var (
+	signingKeys     *KeySet
+	TokenTTL        time.Duration
+	RefreshTokenTTL time.Duration
+	InviteTokenTTL  time.Duration
+
+	// EmbedPermissions puts the caller's permissions in access tokens, RequirePermission then
+	// trusts them for the life of the token instead of resolving them
+	EmbedPermissions bool
 )
 
+// ServiceTokenTTL is the lifetime of client credentials tokens
+const ServiceTokenTTL = 1 * time.Hour
+
+// Kid of the JWT_SECRET key when JWT_SIGNING_KEY_ID is not set
+const defaultHMACKeyID = "hs256"
+
+// ChallengeTokenTTL is how long a client has to complete the second login step
+const ChallengeTokenTTL = 5 * time.Minute
+
+// IDTokenPurpose marks OpenID Connect ID tokens, they are signed with the same keys as access tokens
+const IDTokenPurpose = "id_token"
+
 // Initialize JWT configuration from environment variables
 func init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+	// Access tokens are short-lived, clients renew them with a refresh token
+	TokenTTL = 15 * time.Minute // Default 15 minutes
+	if ttlMinutes, err := strconv.Atoi(os.Getenv("JWT_TTL_MINUTES")); err == nil && ttlMinutes > 0 {
+		TokenTTL = time.Duration(ttlMinutes) * time.Minute
+	}
+
+	RefreshTokenTTL = 30 * 24 * time.Hour // Default 30 days
+	if ttlHours, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_TTL_HOURS")); err == nil && ttlHours > 0 {
+		RefreshTokenTTL = time.Duration(ttlHours) * time.Hour
 	}
-	jwtSecret = []byte(secret)
 
-	// Optional: Configure token TTL from environment
-	ttlHours := os.Getenv("JWT_TTL_HOURS")
-	if ttlHours == "" {
-		tokenTTL = 24 * time.Hour // Default 24 hours
+	InviteTokenTTL = 72 * time.Hour // Default 3 days
+	if ttlHours, err := strconv.Atoi(os.Getenv("INVITE_TTL_HOURS")); err == nil && ttlHours > 0 {
+		InviteTokenTTL = time.Duration(ttlHours) * time.Hour
+	}
+
+	EmbedPermissions, _ = strconv.ParseBool(os.Getenv("JWT_EMBED_PERMISSIONS"))
+
+	// The legacy key window is checked against TokenTTL, so the key set is loaded last
+	keys, err := loadKeySet()
+	if err != nil {
+		log.Fatal(err)
+	}
+	signingKeys = keys
+}
+
+// loadKeySet builds the signing keys from the environment.
+// JWT_SIGNING_ALG selects HS256 (default, JWT_SECRET), RS256 or EdDSA (JWT_SIGNING_KEY_FILE).
+// Keys listed in JWT_VERIFY_KEY_FILES only verify, keep a rotated key there until its tokens expired.
+// Entries are file paths, prefixed with "kid=" when the key was signing under a custom JWT_SIGNING_KEY_ID.
+// JWT_LEGACY_HS256_UNTIL (RFC 3339) keeps tokens signed with JWT_SECRET before kid headers were added,
+// or before moving to RS256 or EdDSA, verifying until then. It can't be further away than the access
+// token TTL, set it to the time of the cutover plus JWT_TTL_MINUTES.
+func loadKeySet() (*KeySet, error) {
+	secret := os.Getenv("JWT_SECRET")
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	keyID := os.Getenv("JWT_SIGNING_KEY_ID")
+
+	var keys *KeySet
+	if alg == "" || alg == jwt.SigningMethodHS256.Alg() {
+		if secret == "" {
+			return nil, fmt.Errorf("JWT_SECRET environment variable is required")
+		}
+		if keyID == "" {
+			keyID = defaultHMACKeyID
+		}
+		keys = NewKeySet(NewHMACKey(keyID, []byte(secret)))
 	} else {
-		// Parse custom TTL if needed
-		tokenTTL = 24 * time.Hour
+		var active *SigningKey
+		var err error
+		if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+			active, err = LoadSigningKeyFile(path, keyID)
+		} else {
+			log.Printf("Warning: JWT_SIGNING_KEY_FILE is not set, signing with an ephemeral %s key", alg)
+			active, err = GenerateSigningKey(alg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if active.Method.Alg() != alg {
+			return nil, fmt.Errorf("signing key is %s but JWT_SIGNING_ALG is %s", active.Method.Alg(), alg)
+		}
+		keys = NewKeySet(active)
+	}
+
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFY_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		kid := ""
+		if i := strings.Index(path, "="); i > 0 {
+			kid, path = path[:i], path[i+1:]
+		}
+		key, err := LoadVerificationKeyFile(path, kid)
+		if err != nil {
+			return nil, err
+		}
+		keys.AddVerificationKey(key)
 	}
+
+	if value := os.Getenv("JWT_LEGACY_HS256_UNTIL"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_LEGACY_HS256_UNTIL: %v", err)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("JWT_LEGACY_HS256_UNTIL requires JWT_SECRET")
+		}
+		if time.Until(until) > TokenTTL {
+			return nil, fmt.Errorf("JWT_LEGACY_HS256_UNTIL is more than the access token TTL away")
+		}
+		addLegacyHMACKeys(keys, []byte(secret), until)
+	}
+
+	return keys, nil
+}
+
+// addLegacyHMACKeys accepts tokens signed with the shared secret until the given time. They have
+// no kid when they predate kid headers, and the default HS256 kid when they predate RS256 or EdDSA.
+func addLegacyHMACKeys(keys *KeySet, secret []byte, until time.Time) {
+	if !time.Now().Before(until) {
+		return
+	}
+	keys.AddVerificationKeyUntil(NewHMACKey("", secret), until)
+	if keys.Algorithm() != jwt.SigningMethodHS256.Alg() {
+		keys.AddVerificationKeyUntil(NewHMACKey(defaultHMACKeyID, secret), until)
+	}
+}
+
+// Issuer returns the issuer identifier put in tokens, OIDC_ISSUER falls back to SERVICE_NAME
+func Issuer() string {
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return os.Getenv("SERVICE_NAME")
+}
+
+// SigningKeys returns the key set tokens are signed and verified with
+func SigningKeys() *KeySet {
+	return signingKeys
 }
 
 // Claims represents the JWT claims structure
@@ -41,6 +166,10 @@ type Claims struct {
 	UserID   uint   `json:"user_id,omitempty"`
 	Role     string `json:"role,omitempty"`
 	Service  string `json:"service,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Permissions is the space separated permission set, only present when EmbedPermissions is on
+	Permissions string `json:"perms,omitempty"`
+	Purpose     string `json:"purpose,omitempty"` // set on challenge, invite and ID tokens only
 	jwt.RegisteredClaims
 }
 
@@ -51,7 +180,7 @@ type Claims struct {
 //		//UserID:   userID,
 //		//Role:     role,
 //		RegisteredClaims: jwt.RegisteredClaims{
-//			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
+//			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
 //			IssuedAt:  jwt.NewNumericDate(time.Now()),
 //			NotBefore: jwt.NewNumericDate(time.Now()),
 //			//Issuer:    os.Getenv("SERVICE_NAME"), // Optional: identify issuing service
@@ -63,33 +192,111 @@ type Claims struct {
 //}
 
 func GenerateJWT(clientID uint) (string, error) {
+	return GenerateJWTWithScope(clientID, "")
+}
+
+// GenerateJWTWithScope creates an access token limited to the given OAuth scope
+func GenerateJWTWithScope(clientID uint, scope string) (string, error) {
+	return GenerateAccessToken(Subject{ClientID: clientID}, scope)
+}
+
+// Subject is who an access token is issued to
+type Subject struct {
+	ClientID    uint
+	UserID      uint     // set for staff users
+	Role        string   // the client type
+	Permissions []string // embedded when EmbedPermissions is on
+}
+
+// GenerateAccessToken creates an access token for the subject limited to the given OAuth scope
+func GenerateAccessToken(subject Subject, scope string) (string, error) {
+	jti, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
+
 	claims := &Claims{
-		ClientID: clientID,
-		//UserID:   userID,
-		//Role:     role,
+		ClientID: subject.ClientID,
+		UserID:   subject.UserID,
+		Role:     subject.Role,
+		Scope:    scope,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			//Issuer:    os.Getenv("SERVICE_NAME"), // Optional: identify issuing service
+			Issuer:    Issuer(),
 		},
 	}
+	if EmbedPermissions {
+		claims.Permissions = strings.Join(subject.Permissions, " ")
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signingKeys.Sign(claims)
 }
 
 // ValidateToken validates a JWT token and returns claims
 func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// ID tokens tell a relying party who signed in, they never grant access
+	if claims.Purpose == IDTokenPurpose {
+		return nil, fmt.Errorf("token is an ID token, not an access token")
+	}
+
+	// Challenge tokens only unlock the next login step, they never grant access
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("token is a %s challenge, not an access token", claims.Purpose)
+	}
+
+	return claims, nil
+}
+
+// GenerateChallengeToken creates a short lived token proving the first login step succeeded
+func GenerateChallengeToken(clientID uint, purpose string) (string, error) {
+	jti, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{
+		ClientID: clientID,
+		Purpose:  purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ChallengeTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer(),
+			ID:        jti,
+		},
+	}
+
+	return signingKeys.Sign(claims)
+}
+
+// ValidateChallengeToken validates a challenge token issued for the given purpose
+func ValidateChallengeToken(tokenString string, purpose string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("token is not a %s challenge", purpose)
+	}
+
+	return claims, nil
+}
+
+// parseToken verifies the signature and expiry of any token issued by this service
+func parseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	// The key set picks the key by kid and validates the signing method
+	token, err := jwt.ParseWithClaims(tokenString, claims, signingKeys.Keyfunc)
 
 	if err != nil {
 		return nil, fmt.Errorf("token parsing error: %w", err)
@@ -131,6 +338,18 @@ func JWTMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Reject tokens revoked by logout
+		revoked, err := IsTokenRevoked(claims)
+		if err != nil {
+			log.Printf("Failed to check token revocation: %v", err)
+			http.Error(w, `{"error": "Failed to check token status"}`, http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			http.Error(w, `{"error": "Token has been revoked"}`, http.StatusUnauthorized)
+			return
+		}
+
 		// Add claims to request context
 		ctx := context.WithValue(r.Context(), "jwt_claims", claims)
 		ctx = context.WithValue(ctx, "client_id", claims.ClientID)
@@ -151,9 +370,11 @@ func OptionalJWTMiddleware(next http.Handler) http.Handler {
 			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 			if tokenStr != authHeader {
 				if claims, err := ValidateToken(tokenStr); err == nil {
-					ctx := context.WithValue(r.Context(), "jwt_claims", claims)
-					ctx = context.WithValue(ctx, "client_id", claims.ClientID)
-					r = r.WithContext(ctx)
+					if revoked, err := IsTokenRevoked(claims); err == nil && !revoked {
+						ctx := context.WithValue(r.Context(), "jwt_claims", claims)
+						ctx = context.WithValue(ctx, "client_id", claims.ClientID)
+						r = r.WithContext(ctx)
+					}
 				}
 			}
 		}
@@ -182,6 +403,28 @@ func RequireRole(role string) func(http.Handler) http.Handler {
 	}
 }
 
+// RequireScope middleware that requires the token to carry the given OAuth scope
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetClaimsFromContext(r.Context())
+			if claims == nil {
+				http.Error(w, `{"error": "No authentication claims found"}`, http.StatusInternalServerError)
+				return
+			}
+
+			for _, granted := range strings.Fields(claims.Scope) {
+				if granted == scope {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			http.Error(w, `{"error": "Insufficient scope"}`, http.StatusForbidden)
+		}))
+	}
+}
+
 // Helper functions to extract data from context
 
 // GetClaimsFromContext extracts JWT claims from request context
@@ -216,21 +459,27 @@ func GetUserRoleFromContext(ctx context.Context) (string, bool) {
 	return "", false
 }
 
-// GenerateServiceToken creates tokens for service-to-service communication
-func GenerateServiceToken(serviceName string) (string, error) {
+// GenerateServiceToken creates tokens for service-to-service communication, limited to the given scope
+func GenerateServiceToken(serviceName string, scope string) (string, error) {
+	jti, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
+
 	claims := &Claims{
 		ClientID: 0, // Service accounts use 0
 		Role:     "service",
 		Service:  serviceName,
+		Scope:    scope,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)), // Shorter TTL for service tokens
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ServiceTokenTTL)), // Shorter TTL for service tokens
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    os.Getenv("SERVICE_NAME"),
+			Issuer:    Issuer(),
 			Subject:   "service-account",
+			ID:        jti,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signingKeys.Sign(claims)
 }
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AllPermissions is held by agencies and landlords, it grants every permission in their tenant
+const AllPermissions = "*"
+
+// PermissionCacheTTL is how long resolved permissions are reused for the same token,
+// role changes reach tokens that are already in use after at most this long
+const PermissionCacheTTL = time.Minute
+
+// Resolved permission sets kept before expired ones are pruned
+const permissionCacheSize = 1024
+
+// PermissionResolver looks up the permissions a client holds
+type PermissionResolver interface {
+	// ResolvePermissions returns the permission names of the client, AllPermissions for owners
+	ResolvePermissions(clientID uint) ([]string, error)
+}
+
+var permissionResolver PermissionResolver
+
+// SetPermissionResolver configures the resolver consulted by RequirePermission
+func SetPermissionResolver(resolver PermissionResolver) {
+	permissionResolver = resolver
+}
+
+type cachedPermissions struct {
+	names     []string
+	expiresAt time.Time
+}
+
+var permissionCache = struct {
+	sync.Mutex
+	entries map[string]cachedPermissions
+}{entries: make(map[string]cachedPermissions)}
+
+// Permissions returns the permissions validated claims grant. Permissions embedded in the token are
+// used as they are, otherwise they are resolved and cached by jti.
+func Permissions(claims *Claims) ([]string, error) {
+	if claims.Permissions != "" {
+		return strings.Fields(claims.Permissions), nil
+	}
+
+	now := time.Now()
+	if claims.ID != "" {
+		permissionCache.Lock()
+		cached, ok := permissionCache.entries[claims.ID]
+		permissionCache.Unlock()
+		if ok && now.Before(cached.expiresAt) {
+			return cached.names, nil
+		}
+	}
+
+	if permissionResolver == nil {
+		return nil, fmt.Errorf("no permission resolver configured")
+	}
+	names, err := permissionResolver.ResolvePermissions(claims.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ID != "" {
+		expiresAt := now.Add(PermissionCacheTTL)
+		if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(expiresAt) {
+			expiresAt = claims.ExpiresAt.Time
+		}
+
+		permissionCache.Lock()
+		if len(permissionCache.entries) >= permissionCacheSize {
+			for jti, entry := range permissionCache.entries {
+				if !now.Before(entry.expiresAt) {
+					delete(permissionCache.entries, jti)
+				}
+			}
+		}
+		permissionCache.entries[claims.ID] = cachedPermissions{names: names, expiresAt: expiresAt}
+		permissionCache.Unlock()
+	}
+	return names, nil
+}
+
+// HasPermission reports whether a permission set grants the permission
+func HasPermission(granted []string, permission string) bool {
+	for _, name := range granted {
+		if name == permission || name == AllPermissions {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission middleware that requires the caller to hold the given permission through its roles.
+// Agencies and landlords hold every permission, service tokens need it as a scope.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetClaimsFromContext(r.Context())
+			if claims == nil {
+				http.Error(w, `{"error": "No authentication claims found"}`, http.StatusInternalServerError)
+				return
+			}
+
+			var granted []string
+			if claims.Role == "service" {
+				granted = strings.Fields(claims.Scope)
+			} else {
+				var err error
+				if granted, err = Permissions(claims); err != nil {
+					log.Printf("Failed to resolve permissions: %v", err)
+					http.Error(w, `{"error": "Failed to check permissions"}`, http.StatusInternalServerError)
+					return
+				}
+			}
+
+			if !HasPermission(granted, permission) {
+				http.Error(w, `{"error": "Insufficient permissions"}`, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// GenerateRefreshToken creates an opaque refresh token and the hash that is stored for it.
+// Only the hash is persisted, the token itself is handed to the client once.
+func GenerateRefreshToken() (string, string, error) {
+	return GenerateOpaqueToken()
+}
+
+// HashRefreshToken returns the lookup hash of a refresh token
+func HashRefreshToken(token string) string {
+	return HashOpaqueToken(token)
+}
+
+// GenerateOpaqueToken creates a random URL safe token and its lookup hash
+func GenerateOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the SHA-256 lookup hash of an opaque token
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateTokenFamily creates an identifier shared by all refresh tokens of one login session
+func GenerateTokenFamily() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token family: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generateTokenID creates the jti used to revoke individual access tokens
+func generateTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,21 @@
+package middleware
+
+// RevocationStore reports whether a token was revoked on the server
+type RevocationStore interface {
+	IsRevoked(claims *Claims) (bool, error)
+}
+
+var revocationStore RevocationStore
+
+// SetRevocationStore configures the store consulted by the JWT middlewares
+func SetRevocationStore(store RevocationStore) {
+	revocationStore = store
+}
+
+// IsTokenRevoked checks validated claims against the configured revocation store
+func IsTokenRevoked(claims *Claims) (bool, error) {
+	if revocationStore == nil {
+		return false, nil
+	}
+	return revocationStore.IsRevoked(claims)
+}
@@ -0,0 +1,346 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SigningKey is a key tokens are signed or verified with
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey // []byte for HMAC, nil for verify-only keys
+	Public  crypto.PublicKey  // []byte for HMAC
+}
+
+// JWK is the public part of a signing key as published on the JWKS endpoint
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet holds the key new tokens are signed with and the keys tokens are still verified with.
+// Keys replaced by a rotation keep verifying until the tokens they signed have expired.
+type KeySet struct {
+	mu      sync.RWMutex
+	active  *SigningKey
+	keys    map[string]*SigningKey
+	retired map[string]time.Time
+}
+
+// NewKeySet creates a key set signing with the given key
+func NewKeySet(active *SigningKey) *KeySet {
+	return &KeySet{
+		active:  active,
+		keys:    map[string]*SigningKey{active.ID: active},
+		retired: map[string]time.Time{},
+	}
+}
+
+// AddVerificationKey accepts tokens signed by key without using it to sign new ones
+func (ks *KeySet) AddVerificationKey(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[key.ID] = key
+}
+
+// AddVerificationKeyUntil accepts tokens signed by key until the given time, the key is dropped after
+func (ks *KeySet) AddVerificationKeyUntil(key *SigningKey, until time.Time) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[key.ID] = key
+	ks.retired[key.ID] = until
+}
+
+// Rotate makes next the signing key. The previous key keeps verifying for the given grace period,
+// which should be at least the lifetime of the longest lived token it signed.
+func (ks *KeySet) Rotate(next *SigningKey, grace time.Duration) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.retired[ks.active.ID] = time.Now().Add(grace)
+	ks.active = next
+	ks.keys[next.ID] = next
+	delete(ks.retired, next.ID)
+}
+
+// Algorithm returns the alg new tokens are signed with
+func (ks *KeySet) Algorithm() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active.Method.Alg()
+}
+
+// Sign signs claims with the active key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.active
+	ks.mu.RUnlock()
+
+	if key.Private == nil {
+		return "", fmt.Errorf("signing key %s has no private key", key.ID)
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.pruneLocked()
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+// JWKS returns the public keys tokens may currently be verified with
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.pruneLocked()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if jwk, ok := publicJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// pruneLocked drops retired keys whose grace period is over
+func (ks *KeySet) pruneLocked() {
+	now := time.Now()
+	for kid, until := range ks.retired {
+		if now.After(until) {
+			delete(ks.keys, kid)
+			delete(ks.retired, kid)
+		}
+	}
+}
+
+// NewHMACKey creates a shared secret key
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key. An empty id is replaced by the key thumbprint.
+func NewSigningKey(id string, private crypto.PrivateKey) (*SigningKey, error) {
+	key := &SigningKey{ID: id, Private: private}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Public = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Public = k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", private)
+	}
+
+	if key.ID == "" {
+		key.ID = thumbprint(key)
+	}
+	return key, nil
+}
+
+// NewVerificationKey wraps an RSA or Ed25519 public key
+func NewVerificationKey(id string, public crypto.PublicKey) (*SigningKey, error) {
+	key := &SigningKey{ID: id, Public: public}
+
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	if key.ID == "" {
+		key.ID = thumbprint(key)
+	}
+	return key, nil
+}
+
+// GenerateSigningKey creates a fresh key for the given algorithm (RS256 or EdDSA)
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %v", err)
+		}
+		return NewSigningKey("", private)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %v", err)
+		}
+		return NewSigningKey("", private)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// LoadSigningKeyFile reads a PEM encoded private key (PKCS#8 or PKCS#1)
+func LoadSigningKeyFile(path, id string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %v", path, err)
+	}
+
+	return NewSigningKey(id, private)
+}
+
+// LoadVerificationKeyFile reads a PEM encoded public or private key, only the public part is kept.
+// An empty id is replaced by the key thumbprint.
+func LoadVerificationKeyFile(path, id string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		key, err := LoadSigningKeyFile(path, id)
+		if err != nil {
+			return nil, err
+		}
+		return NewVerificationKey(key.ID, key.Public)
+	}
+
+	var public crypto.PublicKey
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+	}
+
+	return NewVerificationKey(id, public)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+func publicJWK(key *SigningKey) (JWK, bool) {
+	switch k := key.Public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: key.Method.Alg(),
+			Kid: key.ID,
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: key.Method.Alg(),
+			Kid: key.ID,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, true
+	}
+
+	// Shared secrets are never published
+	return JWK{}, false
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as the default kid
+func thumbprint(key *SigningKey) string {
+	jwk, ok := publicJWK(key)
+	if !ok {
+		return ""
+	}
+
+	// Members in lexicographic order as required by the RFC
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+// The package reads JWT_SECRET when it is loaded, the tests need it to be set
+
+// signWith signs test claims with the key under the given kid, no kid header when it is empty
+func signWith(t *testing.T, key *SigningKey, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.Method, &Claims{
+		ClientID:         1,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// verifies reports whether the key set accepts the token
+func verifies(ks *KeySet, token string) bool {
+	_, err := jwt.ParseWithClaims(token, &Claims{}, ks.Keyfunc)
+	return err == nil
+}
+
+func generateKey(t *testing.T, alg string) *SigningKey {
+	t.Helper()
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeySetSelectsKeyByKid(t *testing.T) {
+	active := generateKey(t, "RS256")
+	previous := generateKey(t, "EdDSA")
+	ks := NewKeySet(active)
+	ks.AddVerificationKey(previous)
+
+	signed, err := ks.Sign(&Claims{ClientID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifies(ks, signed) {
+		t.Fatal("token signed by the active key was rejected")
+	}
+	if !verifies(ks, signWith(t, previous, previous.ID)) {
+		t.Fatal("token signed by the verification key was rejected")
+	}
+
+	stranger := generateKey(t, "EdDSA")
+	tests := map[string]string{
+		"unknown kid":        signWith(t, stranger, stranger.ID),
+		"no kid":             signWith(t, previous, ""),
+		"key of another kid": signWith(t, stranger, previous.ID),
+		"alg of another kid": signWith(t, NewHMACKey("", []byte("secret")), active.ID),
+	}
+	for name, token := range tests {
+		if verifies(ks, token) {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	first := generateKey(t, "EdDSA")
+	ks := NewKeySet(first)
+	token := signWith(t, first, first.ID)
+
+	second := generateKey(t, "EdDSA")
+	ks.Rotate(second, time.Hour)
+	if ks.Algorithm() != "EdDSA" || !verifies(ks, token) {
+		t.Fatal("token of the rotated key was rejected during the grace period")
+	}
+	if len(ks.JWKS().Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want both", len(ks.JWKS().Keys))
+	}
+
+	// Once the grace period is over the key is neither accepted nor published
+	third := generateKey(t, "RS256")
+	ks.Rotate(third, -time.Second)
+	if ks.Algorithm() != "RS256" || !verifies(ks, token) {
+		t.Fatal("token of the first key was rejected during its grace period")
+	}
+	if verifies(ks, signWith(t, second, second.ID)) {
+		t.Fatal("token of a key past its grace period was accepted")
+	}
+	for _, jwk := range ks.JWKS().Keys {
+		if jwk.Kid == second.ID {
+			t.Fatal("key past its grace period is still published")
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := generateKey(t, "RS256")
+	edKey := generateKey(t, "EdDSA")
+	ks := NewKeySet(rsaKey)
+	ks.AddVerificationKey(edKey)
+	ks.AddVerificationKey(NewHMACKey("hs256", []byte("secret")))
+
+	published := map[string]JWK{}
+	for _, jwk := range ks.JWKS().Keys {
+		published[jwk.Kid] = jwk
+	}
+	if len(published) != 2 {
+		t.Fatalf("JWKS has %d keys, want the RSA and Ed25519 keys only", len(published))
+	}
+	if jwk := published[rsaKey.ID]; jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.N == "" || jwk.E == "" {
+		t.Fatalf("RSA key published as %+v", jwk)
+	}
+	if jwk := published[edKey.ID]; jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.X == "" {
+		t.Fatalf("Ed25519 key published as %+v", jwk)
+	}
+
+	// The default kid is the thumbprint, it doesn't change when the key is reloaded
+	reloaded, err := NewVerificationKey("", rsaKey.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.ID != rsaKey.ID {
+		t.Fatalf("kid = %s after reloading, want %s", reloaded.ID, rsaKey.ID)
+	}
+}
+
+func TestLegacyHMACKey(t *testing.T) {
+	const secret = "legacy secret"
+	t.Setenv("JWT_SECRET", secret)
+	t.Setenv("JWT_SIGNING_ALG", "EdDSA")
+	t.Setenv("JWT_SIGNING_KEY_FILE", "")
+	legacy := NewHMACKey("", []byte(secret))
+
+	tests := []struct {
+		name   string
+		until  string
+		accept bool
+	}{
+		{"not configured", "", false},
+		{"before the deadline", time.Now().Add(TokenTTL / 2).Format(time.RFC3339), true},
+		{"after the deadline", time.Now().Add(-time.Minute).Format(time.RFC3339), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_LEGACY_HS256_UNTIL", tt.until)
+			ks, err := loadKeySet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, kid := range []string{"", defaultHMACKeyID} {
+				if verifies(ks, signWith(t, legacy, kid)) != tt.accept {
+					t.Errorf("token with kid %q accepted = %v, want %v", kid, !tt.accept, tt.accept)
+				}
+			}
+		})
+	}
+
+	// The window can't outlast the access tokens signed before the cutover
+	t.Setenv("JWT_LEGACY_HS256_UNTIL", time.Now().Add(TokenTTL+time.Hour).Format(time.RFC3339))
+	if _, err := loadKeySet(); err == nil {
+		t.Fatal("a deadline past the access token TTL was accepted")
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"github.com/kimoresteve/identity-service/app/webauthn"
+	"time"
+)
 
 type ClientType string
 
@@ -20,8 +23,11 @@ type Client struct {
 	Password   string     `json:"password"`
 	IsVerified bool       `json:"is_verified"`
 	IsActive   bool       `json:"is_active"`
+	MFAMethod  string     `json:"mfa_method,omitempty"`
 	CreatedAt  time.Time  `json:"created_at"`
 	UpdatedAt  time.Time  `json:"updated_at"`
+
+	PreferredLanguage string `json:"preferred_language,omitempty"`
 }
 
 type Agency struct {
@@ -61,18 +67,82 @@ type LoginInput struct {
 	Password string `json:"password"`
 }
 
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type TOTPCodeInput struct {
+	Code string `json:"code"`
+}
+
+type PasskeyRegistrationInput struct {
+	Name       string                        `json:"name,omitempty"` // label shown in the list of passkeys
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+type PasskeyLoginBeginInput struct {
+	Contact string `json:"contact,omitempty"` // limits the login to passkeys of this client
+}
+
+type VerifyTwoFactorInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code,omitempty"` // used instead of code when the second factor is lost
+}
+
+type DisableTOTPInput struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"` // used instead of code when the authenticator is lost
+}
+
+type DisableSMSTwoFactorInput struct {
+	Code         string `json:"code,omitempty"`          // texted by a request without code and recovery code
+	RecoveryCode string `json:"recovery_code,omitempty"` // used instead of code when the phone is lost
+}
+
 type Verify struct {
 	Otp      string `json:"otp"`
 	ClientID int    `json:"id"`
 }
 
 type ResendOTPInput struct {
-	LandlordID int    `json:"id"`
-	Purpose    string `json:"purpose"`
+	LandlordID     int    `json:"id"`
+	Purpose        string `json:"purpose"`                   // activation, reset or 2fa
+	ChallengeToken string `json:"challenge_token,omitempty"` // identifies the client instead of id for 2fa
+	OTPChannel     string `json:"otp_channel,omitempty"`     // sms (default) or email, not for 2fa
 }
 
+// ForgotPasswordInput identifies the client by contact or email, the code is sent to whichever is given
 type ForgotPasswordInput struct {
-	Contact string `json:"contact"`
+	Contact string `json:"contact,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+type PreferredLanguageInput struct {
+	PreferredLanguage string `json:"preferred_language"` // en or sw
+}
+
+// MessageTemplateInput overrides one message type in one language, subject and body are text/template
+// sources that can use {{.Name}}, {{.Code}} and {{.ExpiresIn}} (minutes), staff_invite uses {{.Name}},
+// {{.Inviter}}, {{.Link}} and {{.ExpiresInHours}} instead
+type MessageTemplateInput struct {
+	Type    string `json:"type"` // activation_otp, reset_otp, login_otp or staff_invite
+	Locale  string `json:"locale"`
+	Subject string `json:"subject,omitempty"` // only used for email
+	Body    string `json:"body"`
+}
+
+// OTPPolicyInput overrides the OTP policy of one purpose for the clients of an agency, omitted fields keep the configured value
+type OTPPolicyInput struct {
+	Length                *int    `json:"length,omitempty"`
+	Alphabet              *string `json:"alphabet,omitempty"` // letters and digits, codes are case insensitive without lowercase letters
+	TTLSeconds            *int    `json:"ttl_seconds,omitempty"`
+	MaxAttempts           *int    `json:"max_attempts,omitempty"`
+	ResendCooldownSeconds *int    `json:"resend_cooldown_seconds,omitempty"`
 }
 
 type ResetPasswordInput struct {
@@ -80,3 +150,223 @@ type ResetPasswordInput struct {
 	OTP      string `json:"otp"`
 	ID       int    `json:"id"`
 }
+
+// UserInput is a staff account an agency or landlord creates, it gets an activation code like any other client
+type UserInput struct {
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Position   string `json:"position,omitempty"`
+	Email      string `json:"email"`
+	Contact    string `json:"contact"`
+	Password   string `json:"password"`
+	OTPChannel string `json:"otp_channel,omitempty"` // sms (default) or email
+
+	PreferredLanguage string `json:"preferred_language,omitempty"` // the owner's language when omitted
+}
+
+// UserUpdateInput changes a user's profile, omitted fields are kept
+type UserUpdateInput struct {
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+	Position  *string `json:"position,omitempty"`
+}
+
+// LandlordUpdateInput changes the profile of a landlord an agency represents, omitted fields are kept
+type LandlordUpdateInput struct {
+	Name    *string `json:"name,omitempty"`
+	Address *string `json:"address,omitempty"`
+}
+
+// InvitationInput invites a staff member, the invite goes to the email or contact the channel picks
+type InvitationInput struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Position  string `json:"position,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Contact   string `json:"contact,omitempty"`
+	Channel   string `json:"channel,omitempty"` // sms (default) or email
+
+	PreferredLanguage string `json:"preferred_language,omitempty"` // the owner's language when omitted
+}
+
+// AcceptInvitationInput creates the invited user with its own password. Email and contact are
+// only used when the invitation doesn't have them.
+type AcceptInvitationInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Contact  string `json:"contact,omitempty"`
+}
+
+// Invitation is a pending, accepted or revoked staff invitation of an agency or landlord
+type Invitation struct {
+	ID                int        `json:"id"`
+	OwnerID           int        `json:"owner_id"`
+	OwnerType         string     `json:"owner_type"`
+	FirstName         string     `json:"first_name"`
+	LastName          string     `json:"last_name"`
+	Position          string     `json:"position,omitempty"`
+	Email             string     `json:"email,omitempty"`
+	Contact           string     `json:"contact,omitempty"`
+	Channel           string     `json:"channel"`
+	PreferredLanguage string     `json:"preferred_language"`
+	TokenID           string     `json:"-"` // jti of the last token sent
+	ExpiresAt         time.Time  `json:"expires_at"`
+	SentAt            time.Time  `json:"sent_at"`
+	AcceptedAt        *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	UserID            *int       `json:"user_id,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// Permission is an entry of the system-wide permission catalog, named resource:action
+type Permission struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Role is a named set of permissions an agency or landlord assigns to its users
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	OwnerID     int       `json:"owner_id"`
+	OwnerType   string    `json:"owner_type"` // "agency" or "landlord"
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoleInput defines a role, permissions are names from the catalog
+type RoleInput struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleUpdateInput changes a role, omitted fields are kept and permissions replaces the whole set
+type RoleUpdateInput struct {
+	Name        *string   `json:"name,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Permissions *[]string `json:"permissions,omitempty"`
+}
+
+// UserRolesInput replaces the roles of a user
+type UserRolesInput struct {
+	RoleIDs []int `json:"role_ids"`
+}
+
+// OTPCode is a stored one-time code, only its keyed hash is kept
+type OTPCode struct {
+	ID             int
+	ClientID       int
+	Purpose        string // activation, reset or 2fa
+	Hash           string
+	FailedAttempts int
+	Used           bool
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// OTPPolicyOverride is an agency's override of the OTP policy of one purpose, nil fields keep the configured value
+type OTPPolicyOverride struct {
+	TenantID              int
+	Purpose               string
+	Length                *int
+	Alphabet              *string
+	TTLSeconds            *int
+	MaxAttempts           *int
+	ResendCooldownSeconds *int
+}
+
+// MessageTemplate is an agency's or landlord's override of one message type in one language
+type MessageTemplate struct {
+	TenantID  int       `json:"-"`
+	Type      string    `json:"type"`
+	Locale    string    `json:"locale"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RefreshToken is a stored refresh token, only its hash is kept
+type RefreshToken struct {
+	ID            int
+	ClientID      int
+	Hash          string
+	FamilyID      string // shared by the tokens rotated from the same login
+	Scope         string
+	OAuthClientID string // relying party the family was issued to, empty for first-party logins
+	ReplacedBy    *int   // the token this one was rotated into
+	ExpiresAt     time.Time
+	RevokedAt     *time.Time
+	CreatedAt     time.Time
+}
+
+// MFASettings are the second factor settings of a client. A TOTP secret while the method
+// is still none is an enrollment waiting to be confirmed.
+type MFASettings struct {
+	Method         string // none, totp or sms
+	TOTPSecret     string
+	TOTPLastStep   *int64 // last accepted time step, codes of earlier steps are replays
+	FailedAttempts int    // since the last successful verification
+	LockedUntil    *time.Time
+}
+
+// RecoveryCode is a stored recovery code, only its bcrypt hash is kept
+type RecoveryCode struct {
+	ID       int
+	ClientID int
+	Hash     string
+	UsedAt   *time.Time
+}
+
+// OAuthClient is a registered relying party or service account
+type OAuthClient struct {
+	ClientID     string
+	SecretHash   string // empty for public clients
+	Name         string
+	ServiceName  string // put in service tokens instead of the name when set
+	RedirectURIs []string
+	Scopes       []string
+	GrantTypes   []string
+}
+
+// AuthorizationCode is an issued authorization code, only its hash is kept
+type AuthorizationCode struct {
+	ID            int
+	Hash          string
+	OAuthClientID string
+	ClientID      int
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+// Passkey is a registered WebAuthn credential
+type Passkey struct {
+	ID             int
+	ClientID       int
+	CredentialID   []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	Transports     []string
+	BackupEligible bool
+	Name           string
+	LastUsedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+// WebAuthnChallenge is the challenge of a registration or login ceremony in progress
+type WebAuthnChallenge struct {
+	ID        int
+	Challenge string // base64url
+	ClientID  *int   // nil for logins that may use any discoverable passkey
+	Ceremony  string // registration or login
+	ExpiresAt time.Time
+}
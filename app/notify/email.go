@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EmailSender sends a plain text email
+type EmailSender interface {
+	SendEmail(to, subject, body string) error
+}
+
+// NewEmailSenderFromEnv builds the sender named by EMAIL_PROVIDER: smtp, file, log or memory.
+// Without EMAIL_PROVIDER email delivery is disabled and nil is returned.
+func NewEmailSenderFromEnv() (EmailSender, error) {
+	switch provider := strings.ToLower(os.Getenv("EMAIL_PROVIDER")); provider {
+	case "":
+		return nil, nil
+	case "smtp":
+		sender, err := NewSMTPSenderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return sender, nil
+	case "file":
+		return &FileMailbox{Dir: envOrDefault("EMAIL_MAILBOX_DIR", "mailbox")}, nil
+	case "log":
+		return &LogSender{}, nil
+	case "memory":
+		return &Recorder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_PROVIDER %q", provider)
+	}
+}
+
+// SMTPSender delivers email through an SMTP relay, upgrading to TLS when the server offers STARTTLS
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string // no authentication when empty
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+// NewSMTPSenderFromEnv configures the sender from SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func NewSMTPSenderFromEnv() (*SMTPSender, error) {
+	env, err := requireEnv("SMTP_HOST", "SMTP_FROM")
+	if err != nil {
+		return nil, fmt.Errorf("smtp email provider: %v", err)
+	}
+
+	return &SMTPSender{
+		Host:     env["SMTP_HOST"],
+		Port:     envOrDefault("SMTP_PORT", "587"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     env["SMTP_FROM"],
+		Timeout:  15 * time.Second,
+	}, nil
+}
+
+// SendEmail implements EmailSender
+func (s *SMTPSender) SendEmail(to, subject, body string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.Host, s.Port), s.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	// Bounds the whole conversation, net/smtp has no timeouts of its own
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if s.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+	}
+
+	if err = client.Mail(s.From); err != nil {
+		return fmt.Errorf("failed to set sender: %v", err)
+	}
+	if err = client.Rcpt(to); err != nil {
+		return fmt.Errorf("failed to set recipient: %v", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %v", err)
+	}
+	if _, err = w.Write(formatEmail(s.From, to, subject, body, time.Now())); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+
+	return client.Quit()
+}
+
+// FileMailbox writes every email to its own .eml file in Dir instead of sending it,
+// for local development and tests without a mail server
+type FileMailbox struct {
+	Dir string
+}
+
+var mailboxSeq uint64
+
+// SendEmail implements EmailSender
+func (m *FileMailbox) SendEmail(to, subject, body string) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mailbox: %v", err)
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%d.eml", now.Format("20060102T150405.000000000"), atomic.AddUint64(&mailboxSeq, 1))
+	if err := os.WriteFile(filepath.Join(m.Dir, name), formatEmail("noreply@localhost", to, subject, body, now), 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %v", err)
+	}
+	return nil
+}
+
+// Header values come from user input, line breaks would let them add headers
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+// formatEmail builds an RFC 5322 plain text message
+func formatEmail(from, to, subject, body string, date time.Time) []byte {
+	from, to, subject = headerSanitizer.Replace(from), headerSanitizer.Replace(to), headerSanitizer.Replace(subject)
+
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one session on a local port and records the envelope and message
+type fakeSMTP struct {
+	addr     string
+	from     string
+	to       string
+	data     string
+	rejectTo bool // answers RCPT with a permanent failure
+	done     chan struct{}
+}
+
+func newFakeSMTP(t *testing.T, rejectTo bool) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	f := &fakeSMTP{addr: listener.Addr().String(), rejectTo: rejectTo, done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		f.serve(textproto.NewConn(conn))
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(c *textproto.Conn) {
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			c.PrintfLine("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			f.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			c.PrintfLine("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			if f.rejectTo {
+				c.PrintfLine("550 No such user")
+				continue
+			}
+			f.to = strings.Trim(line[len("RCPT TO:"):], "<>")
+			c.PrintfLine("250 OK")
+		case verb == "DATA":
+			c.PrintfLine("354 Go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			f.data = string(data)
+			c.PrintfLine("250 Queued")
+		case verb == "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func (f *fakeSMTP) sender(t *testing.T) *SMTPSender {
+	host, port, err := net.SplitHostPort(f.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SMTPSender{Host: host, Port: port, From: "noreply@example.com", Timeout: 5 * time.Second}
+}
+
+func TestSMTPSender(t *testing.T) {
+	server := newFakeSMTP(t, false)
+	if err := server.sender(t).SendEmail("client@example.com", "Your code", "Your code is 123456\nIt expires soon"); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if server.from != "noreply@example.com" || server.to != "client@example.com" {
+		t.Fatalf("envelope from %q to %q", server.from, server.to)
+	}
+	for _, want := range []string{"To: client@example.com\n", "Subject: Your code\n", "\nYour code is 123456\nIt expires soon\n"} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("message %q doesn't contain %q", server.data, want)
+		}
+	}
+}
+
+func TestSMTPSenderErrors(t *testing.T) {
+	server := newFakeSMTP(t, true)
+	err := server.sender(t).SendEmail("nobody@example.com", "Your code", "Your code is 123456")
+	if err == nil || !strings.Contains(err.Error(), "failed to set recipient") || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("err = %v, want the recipient to be refused", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	sender := &SMTPSender{Host: host, Port: port, From: "noreply@example.com", Timeout: time.Second}
+	if err := sender.SendEmail("client@example.com", "Your code", "Your code is 123456"); err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Fatalf("err = %v, want a connection error", err)
+	}
+}
+
+func TestFileMailbox(t *testing.T) {
+	mailbox := &FileMailbox{Dir: filepath.Join(t.TempDir(), "mailbox")}
+	for i := 0; i < 2; i++ {
+		if err := mailbox.SendEmail("client@example.com", fmt.Sprintf("Message %d", i), "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(mailbox.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("mailbox has %d files, want one per email", len(files))
+	}
+	message, err := os.ReadFile(filepath.Join(mailbox.Dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(message), "To: client@example.com\r\n") {
+		t.Fatalf("mailbox file %q isn't the email", message)
+	}
+}
+
+func TestFormatEmail(t *testing.T) {
+	message := string(formatEmail("noreply@example.com", "client@example.com\r\nBcc: attacker@example.com", "Código\nBcc: attacker@example.com", "one\ntwo", time.Now()))
+	headers, body, _ := strings.Cut(message, "\r\n\r\n")
+
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(headers + "\r\n\r\n")))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := header["Bcc"]; ok {
+		t.Fatal("a line break in a header value added a header")
+	}
+	if subject := header.Get("Subject"); !strings.HasPrefix(subject, "=?utf-8?q?") {
+		t.Fatalf("Subject = %q, want it Q-encoded", subject)
+	}
+	if body != "one\r\ntwo\r\n" {
+		t.Fatalf("body = %q, want CRLF line endings", body)
+	}
+}
+
+func TestEmailSenderFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_FROM", "noreply@example.com")
+
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{"", "<nil>"},
+		{"smtp", "*notify.SMTPSender"},
+		{"File", "*notify.FileMailbox"},
+		{"log", "*notify.LogSender"},
+		{"memory", "*notify.Recorder"},
+	}
+	for _, tt := range tests {
+		t.Setenv("EMAIL_PROVIDER", tt.provider)
+		sender, err := NewEmailSenderFromEnv()
+		if err != nil {
+			t.Fatalf("%q: %v", tt.provider, err)
+		}
+		if got := fmt.Sprintf("%T", sender); got != tt.want {
+			t.Errorf("EMAIL_PROVIDER=%q built %s, want %s", tt.provider, got, tt.want)
+		}
+	}
+
+	t.Setenv("EMAIL_PROVIDER", "smtp")
+	t.Setenv("SMTP_HOST", "")
+	if _, err := NewEmailSenderFromEnv(); err == nil || !strings.Contains(err.Error(), "SMTP_HOST") {
+		t.Fatalf("err = %v, want the missing setting named", err)
+	}
+}
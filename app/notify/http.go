@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// HTTPSender posts messages as JSON ({"to", "message", "sender_id"}) to a gateway,
+// for providers or internal relays that don't need a dedicated integration
+type HTTPSender struct {
+	URL        string
+	Token      string // sent as a bearer token when set
+	SenderID   string
+	HTTPClient *http.Client
+}
+
+// NewHTTPSenderFromEnv configures the sender from SMS_HTTP_URL, SMS_HTTP_TOKEN and SMS_SENDER_ID
+func NewHTTPSenderFromEnv() (*HTTPSender, error) {
+	env, err := requireEnv("SMS_HTTP_URL")
+	if err != nil {
+		return nil, fmt.Errorf("http SMS provider: %v", err)
+	}
+
+	return &HTTPSender{
+		URL:        env["SMS_HTTP_URL"],
+		Token:      os.Getenv("SMS_HTTP_TOKEN"),
+		SenderID:   os.Getenv("SMS_SENDER_ID"),
+		HTTPClient: sharedHTTPClient,
+	}, nil
+}
+
+// SendSMS implements SMSSender, any 2xx response counts as accepted
+func (s *HTTPSender) SendSMS(msisdn, message string) error {
+	payload := map[string]interface{}{
+		"to":      msisdn,
+		"message": message,
+	}
+	if s.SenderID != "" {
+		payload["sender_id"] = s.SenderID
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", s.URL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	client := s.HTTPClient
+	if client == nil {
+		client = sharedHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
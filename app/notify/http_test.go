@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSender(t *testing.T) {
+	var got map[string]string
+	var authorization string
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+		w.Write([]byte("gateway says no"))
+	}))
+	defer server.Close()
+
+	sender := &HTTPSender{URL: server.URL, Token: "gateway-token", SenderID: "ACME", HTTPClient: server.Client()}
+	if err := sender.SendSMS("254700000001", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got["to"] != "254700000001" || got["message"] != "hello" || got["sender_id"] != "ACME" {
+		t.Fatalf("gateway got %v", got)
+	}
+	if authorization != "Bearer gateway-token" {
+		t.Fatalf("Authorization = %q, want the bearer token", authorization)
+	}
+
+	// Optional settings are left out of the request
+	sender.Token, sender.SenderID = "", ""
+	if err := sender.SendSMS("254700000001", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["sender_id"]; ok || authorization != "" {
+		t.Fatalf("gateway got %v with Authorization %q, want neither the sender ID nor a token", got, authorization)
+	}
+
+	status = http.StatusBadGateway
+	err := sender.SendSMS("254700000001", "hello")
+	if err == nil || !strings.Contains(err.Error(), "502") || !strings.Contains(err.Error(), "gateway says no") {
+		t.Fatalf("err = %v, want the status and response", err)
+	}
+}
+
+func TestSMSSenderFromEnv(t *testing.T) {
+	t.Setenv("SMS_USERNAME", "254700000000")
+	t.Setenv("SMS_PASSWORD", "secret")
+	t.Setenv("SMS_SENDER_ID", "ACME")
+	t.Setenv("SMS_HTTP_URL", "http://gateway.invalid/send")
+
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{"", "*notify.IntouchSender"},
+		{"intouch", "*notify.IntouchSender"},
+		{"HTTP", "*notify.HTTPSender"},
+		{"log", "*notify.LogSender"},
+		{"memory", "*notify.Recorder"},
+	}
+	for _, tt := range tests {
+		t.Setenv("SMS_PROVIDER", tt.provider)
+		sender, err := NewSMSSenderFromEnv()
+		if err != nil {
+			t.Fatalf("%q: %v", tt.provider, err)
+		}
+		if got := fmt.Sprintf("%T", sender); got != tt.want {
+			t.Errorf("SMS_PROVIDER=%q built %s, want %s", tt.provider, got, tt.want)
+		}
+	}
+
+	t.Setenv("SMS_PROVIDER", "carrier-pigeon")
+	if _, err := NewSMSSenderFromEnv(); err == nil {
+		t.Fatal("unknown provider was accepted")
+	}
+	t.Setenv("SMS_PROVIDER", "intouch")
+	t.Setenv("SMS_PASSWORD", "")
+	if _, err := NewSMSSenderFromEnv(); err == nil || !strings.Contains(err.Error(), "SMS_PASSWORD") {
+		t.Fatalf("err = %v, want the missing setting named", err)
+	}
+}
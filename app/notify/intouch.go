@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Lifetime assumed for API keys when the provider doesn't say
+const defaultAPIKeyTTL = 50 * time.Minute
+
+// Keys are refreshed this long before they expire so a send never races the expiry
+const apiKeyRefreshMargin = time.Minute
+
+var errAPIKeyRejected = errors.New("API key rejected")
+
+// IntouchSender sends transactional SMS through the Intouch VAS API. The API key the
+// account credentials are exchanged for is cached and shared by concurrent sends.
+type IntouchSender struct {
+	APIKeyURL  string
+	SendURL    string
+	Username   string // account msisdn
+	Password   string
+	SenderID   string
+	APIKeyTTL  time.Duration // used when the key response has no expires_in
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	apiKey    string
+	apiKeyExp time.Time
+}
+
+// NewIntouchSenderFromEnv configures the sender from SMS_USERNAME, SMS_PASSWORD and SMS_SENDER_ID.
+// SMS_API_KEY_URL and SMS_SEND_URL override the production endpoints, SMS_API_KEY_TTL_MINUTES
+// the assumed key lifetime.
+func NewIntouchSenderFromEnv() (*IntouchSender, error) {
+	env, err := requireEnv("SMS_USERNAME", "SMS_PASSWORD", "SMS_SENDER_ID")
+	if err != nil {
+		return nil, fmt.Errorf("intouch SMS provider: %v", err)
+	}
+
+	ttl := defaultAPIKeyTTL
+	if minutes, err := strconv.Atoi(os.Getenv("SMS_API_KEY_TTL_MINUTES")); err == nil && minutes > 0 {
+		ttl = time.Duration(minutes) * time.Minute
+	}
+
+	return &IntouchSender{
+		APIKeyURL:  envOrDefault("SMS_API_KEY_URL", "https://identity-service.intouchvas.io/auth/api-key"),
+		SendURL:    envOrDefault("SMS_SEND_URL", "https://sms-service.intouchvas.io/message/send/transactional"),
+		Username:   env["SMS_USERNAME"],
+		Password:   env["SMS_PASSWORD"],
+		SenderID:   env["SMS_SENDER_ID"],
+		APIKeyTTL:  ttl,
+		HTTPClient: sharedHTTPClient,
+	}, nil
+}
+
+// SendSMS implements SMSSender. A send the provider rejects with 401 is retried once with a fresh key.
+func (s *IntouchSender) SendSMS(msisdn, message string) error {
+	token, err := s.cachedAPIKey()
+	if err != nil {
+		return fmt.Errorf("failed to get API key: %v", err)
+	}
+
+	err = s.send(token, msisdn, message)
+	if errors.Is(err, errAPIKeyRejected) {
+		s.invalidateAPIKey(token)
+		if token, err = s.cachedAPIKey(); err != nil {
+			return fmt.Errorf("failed to get API key: %v", err)
+		}
+		err = s.send(token, msisdn, message)
+	}
+	return err
+}
+
+func (s *IntouchSender) send(token, msisdn, message string) error {
+	payload := map[string]interface{}{
+		"msisdn":    msisdn,
+		"message":   message,
+		"sender_id": s.SenderID,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", s.SendURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", token)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		io.Copy(io.Discard, resp.Body)
+		return errAPIKeyRejected
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// cachedAPIKey returns the cached key, fetching a new one when it is missing or about to expire.
+// The lock is held during the fetch so concurrent sends share one request.
+func (s *IntouchSender) cachedAPIKey() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.apiKey != "" && time.Now().Add(apiKeyRefreshMargin).Before(s.apiKeyExp) {
+		return s.apiKey, nil
+	}
+
+	key, ttl, err := s.fetchAPIKey()
+	if err != nil {
+		return "", err
+	}
+	s.apiKey = key
+	s.apiKeyExp = time.Now().Add(ttl)
+	return key, nil
+}
+
+// invalidateAPIKey drops a rejected key, unless another send has already replaced it
+func (s *IntouchSender) invalidateAPIKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.apiKey == key {
+		s.apiKey = ""
+	}
+}
+
+func (s *IntouchSender) fetchAPIKey() (string, time.Duration, error) {
+	req, err := http.NewRequest("GET", s.APIKeyURL, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	encodedCredentials := base64.StdEncoding.EncodeToString([]byte(s.Username + ":" + s.Password))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Basic "+encodedCredentials)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return "", 0, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("failed to parse token response: %v", err)
+	}
+	if result.Token == "" {
+		return "", 0, errors.New("empty API key in token response")
+	}
+
+	ttl := s.APIKeyTTL
+	if result.ExpiresIn > 0 {
+		ttl = time.Duration(result.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 {
+		ttl = defaultAPIKeyTTL
+	}
+	return result.Token, ttl, nil
+}
+
+func (s *IntouchSender) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return sharedHTTPClient
+}
@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIntouch is an Intouch API that hands out numbered keys and accepts sends with the current one
+type fakeIntouch struct {
+	mu         sync.Mutex
+	keys       int      // keys issued so far
+	expiresIn  int      // returned with every key, 0 leaves it out
+	sends      []string // keys the sends came with
+	rejectKeys int      // sends answered 401 before the key is honoured
+	sendStatus int      // answers sends with a valid key when set
+}
+
+func (f *fakeIntouch) server(t *testing.T) (*httptest.Server, *IntouchSender) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/api-key", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "254700000000" || password != "secret" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		f.keys++
+		response := map[string]interface{}{"token": fmt.Sprintf("key-%d", f.keys)}
+		if f.expiresIn > 0 {
+			response["expires_in"] = f.expiresIn
+		}
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("/message/send/transactional", func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload["msisdn"] == "" || payload["sender_id"] != "ACME" {
+			http.Error(w, "bad payload", http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		key := r.Header.Get("api-key")
+		f.sends = append(f.sends, key)
+		switch {
+		case key != fmt.Sprintf("key-%d", f.keys) || f.rejectKeys > 0:
+			if f.rejectKeys > 0 {
+				f.rejectKeys--
+			}
+			http.Error(w, "expired key", http.StatusUnauthorized)
+		case f.sendStatus != 0:
+			http.Error(w, "provider error", f.sendStatus)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &IntouchSender{
+		APIKeyURL:  server.URL + "/auth/api-key",
+		SendURL:    server.URL + "/message/send/transactional",
+		Username:   "254700000000",
+		Password:   "secret",
+		SenderID:   "ACME",
+		APIKeyTTL:  time.Hour,
+		HTTPClient: server.Client(),
+	}
+}
+
+func TestIntouchCachesAPIKey(t *testing.T) {
+	fake := &fakeIntouch{}
+	_, sender := fake.server(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sender.SendSMS("254700000001", "hello"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fake.keys != 1 || len(fake.sends) != 5 {
+		t.Fatalf("%d keys fetched for %d sends, want one key for 5 sends", fake.keys, len(fake.sends))
+	}
+
+	// A key about to expire is replaced before the send
+	sender.apiKeyExp = time.Now().Add(apiKeyRefreshMargin / 2)
+	if err := sender.SendSMS("254700000001", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.keys != 2 || fake.sends[len(fake.sends)-1] != "key-2" {
+		t.Fatalf("sent with %s after %d key fetches, want a fresh key", fake.sends[len(fake.sends)-1], fake.keys)
+	}
+}
+
+func TestIntouchAPIKeyLifetime(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		ttl       time.Duration
+		want      time.Duration
+	}{
+		{"expires_in from the provider", 600, time.Hour, 10 * time.Minute},
+		{"configured lifetime", 0, time.Hour, time.Hour},
+		{"default lifetime", 0, 0, defaultAPIKeyTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, sender := (&fakeIntouch{expiresIn: tt.expiresIn}).server(t)
+			sender.APIKeyTTL = tt.ttl
+
+			before := time.Now()
+			if _, err := sender.cachedAPIKey(); err != nil {
+				t.Fatal(err)
+			}
+			if sender.apiKeyExp.Before(before.Add(tt.want)) || sender.apiKeyExp.After(time.Now().Add(tt.want)) {
+				t.Fatalf("key expires at %v, want %v from now", sender.apiKeyExp, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntouchRefreshesRejectedKey(t *testing.T) {
+	fake := &fakeIntouch{}
+	_, sender := fake.server(t)
+	if err := sender.SendSMS("254700000001", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider revoked the cached key, the send is retried once with a new one
+	fake.rejectKeys = 1
+	if err := sender.SendSMS("254700000001", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"key-1", "key-1", "key-2"}; strings.Join(fake.sends, ",") != strings.Join(want, ",") {
+		t.Fatalf("sends used keys %v, want %v", fake.sends, want)
+	}
+
+	// A fresh key that is rejected too is not retried again
+	fake.rejectKeys = 2
+	err := sender.SendSMS("254700000001", "hello")
+	if !errors.Is(err, errAPIKeyRejected) {
+		t.Fatalf("err = %v, want the key rejection", err)
+	}
+	if len(fake.sends) != 5 {
+		t.Fatalf("%d sends, want a single retry", len(fake.sends))
+	}
+}
+
+func TestIntouchErrors(t *testing.T) {
+	t.Run("provider error", func(t *testing.T) {
+		fake := &fakeIntouch{sendStatus: http.StatusInternalServerError}
+		_, sender := fake.server(t)
+
+		err := sender.SendSMS("254700000001", "hello")
+		if err == nil || errors.Is(err, errAPIKeyRejected) || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "provider error") {
+			t.Fatalf("err = %v, want the status and response", err)
+		}
+		if fake.keys != 1 || len(fake.sends) != 1 {
+			t.Fatal("a send that failed for another reason than the key was retried")
+		}
+	})
+
+	t.Run("bad credentials", func(t *testing.T) {
+		fake := &fakeIntouch{}
+		_, sender := fake.server(t)
+		sender.Password = "wrong"
+
+		err := sender.SendSMS("254700000001", "hello")
+		if err == nil || !strings.Contains(err.Error(), "failed to get API key") || !strings.Contains(err.Error(), "401") {
+			t.Fatalf("err = %v, want the key request to fail", err)
+		}
+		if len(fake.sends) != 0 {
+			t.Fatal("sent without a key")
+		}
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"token": ""}`))
+		}))
+		defer server.Close()
+		sender := &IntouchSender{APIKeyURL: server.URL, SendURL: server.URL, HTTPClient: server.Client()}
+
+		if err := sender.SendSMS("254700000001", "hello"); err == nil || !strings.Contains(err.Error(), "empty API key") {
+			t.Fatalf("err = %v, want the empty key to be refused", err)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		sender := &IntouchSender{APIKeyURL: server.URL, SendURL: server.URL}
+
+		if err := sender.SendSMS("254700000001", "hello"); err == nil || !strings.Contains(err.Error(), "failed to send request") {
+			t.Fatalf("err = %v, want a transport error", err)
+		}
+	})
+}
@@ -0,0 +1,18 @@
+package notify
+
+import "log"
+
+// LogSender writes messages to the log instead of sending them, for local development
+type LogSender struct{}
+
+// SendSMS implements SMSSender
+func (LogSender) SendSMS(msisdn, message string) error {
+	log.Printf("SMS to %s: %s", msisdn, message)
+	return nil
+}
+
+// SendEmail implements EmailSender
+func (LogSender) SendEmail(to, subject, body string) error {
+	log.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
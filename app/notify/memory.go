@@ -0,0 +1,96 @@
+package notify
+
+import "sync"
+
+// SMS is a text message kept by the Recorder
+type SMS struct {
+	MSISDN  string
+	Message string
+}
+
+// Email is an email kept by the Recorder
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Recorder keeps sent messages in memory so tests can read the codes they contain
+type Recorder struct {
+	mu       sync.Mutex
+	messages []SMS
+	emails   []Email
+	Err      error // returned by SendSMS and SendEmail when set, to simulate provider failures
+}
+
+// SendSMS implements SMSSender
+func (r *Recorder) SendSMS(msisdn, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Err != nil {
+		return r.Err
+	}
+	r.messages = append(r.messages, SMS{MSISDN: msisdn, Message: message})
+	return nil
+}
+
+// SendEmail implements EmailSender
+func (r *Recorder) SendEmail(to, subject, body string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Err != nil {
+		return r.Err
+	}
+	r.emails = append(r.emails, Email{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages returns every text message sent so far
+func (r *Recorder) Messages() []SMS {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SMS(nil), r.messages...)
+}
+
+// Emails returns every email sent so far
+func (r *Recorder) Emails() []Email {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Email(nil), r.emails...)
+}
+
+// Last returns the latest text message sent to msisdn
+func (r *Recorder) Last(msisdn string) (SMS, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.messages) - 1; i >= 0; i-- {
+		if r.messages[i].MSISDN == msisdn {
+			return r.messages[i], true
+		}
+	}
+	return SMS{}, false
+}
+
+// LastEmail returns the latest email sent to an address
+func (r *Recorder) LastEmail(to string) (Email, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.emails) - 1; i >= 0; i-- {
+		if r.emails[i].To == to {
+			return r.emails[i], true
+		}
+	}
+	return Email{}, false
+}
+
+// Reset forgets the recorded messages
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = nil
+	r.emails = nil
+}
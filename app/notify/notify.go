@@ -0,0 +1,80 @@
+// Package notify delivers messages to clients. Senders are picked from the environment
+// so the service can run against a real provider, a generic HTTP gateway or no provider at all.
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// sharedHTTPClient is used by every provider so connections to them are reused.
+// Each timeout bounds one stage of a request, Timeout the request as a whole.
+var sharedHTTPClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	},
+}
+
+// SMSSender sends a text message to a phone number
+type SMSSender interface {
+	SendSMS(msisdn, message string) error
+}
+
+// NewSMSSenderFromEnv builds the sender named by SMS_PROVIDER: intouch (default), http, log or memory
+func NewSMSSenderFromEnv() (SMSSender, error) {
+	switch provider := strings.ToLower(os.Getenv("SMS_PROVIDER")); provider {
+	case "", "intouch":
+		sender, err := NewIntouchSenderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return sender, nil
+	case "http":
+		sender, err := NewHTTPSenderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return sender, nil
+	case "log":
+		return &LogSender{}, nil
+	case "memory":
+		return &Recorder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", provider)
+	}
+}
+
+// Helper function to read a required setting
+func requireEnv(names ...string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	var missing []string
+	for _, name := range names {
+		value := os.Getenv(name)
+		if value == "" {
+			missing = append(missing, name)
+		}
+		values[name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// Helper function to read an optional setting
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
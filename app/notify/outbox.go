@@ -0,0 +1,39 @@
+package notify
+
+import "time"
+
+// Delivery channels of outbox messages
+const (
+	ChannelSMS   = "sms"
+	ChannelEmail = "email"
+)
+
+// Delivery status of outbox messages
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead" // gave up after MaxAttempts
+)
+
+// Message is a notification waiting in the outbox
+type Message struct {
+	Channel   string
+	Recipient string
+	Subject   string // used when delivered by email
+	Body      string
+
+	// Tried whenever delivery on Channel fails, e.g. the email address of an SMS recipient
+	FallbackChannel   string
+	FallbackRecipient string
+}
+
+// OutboxMessage is a message stored in the outbox with its delivery state
+type OutboxMessage struct {
+	Message
+	ID               int64
+	Status           string
+	Attempts         int       // counted when the message is claimed
+	NextAttemptAt    time.Time // also the lease of a claimed message
+	DeliveredChannel string
+	LastError        string
+}
@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Message types a template can be registered for
+const (
+	MessageActivationOTP = "activation_otp"
+	MessageResetOTP      = "reset_otp"
+	MessageLoginOTP      = "login_otp"
+	MessageStaffInvite   = "staff_invite"
+)
+
+// MessageTypes lists every message type, in the order they are documented
+var MessageTypes = []string{MessageActivationOTP, MessageResetOTP, MessageLoginOTP, MessageStaffInvite}
+
+// DefaultLocale is used for clients without a supported preferred language
+const DefaultLocale = "en"
+
+// Locales are the languages built in templates exist for
+var Locales = []string{"en", "sw"}
+
+// Longest subject and body a template may have
+const (
+	maxTemplateSubject = 255
+	maxTemplateBody    = 2000
+)
+
+// Template is the text of one message type in one language. Both parts are text/template
+// sources rendered with TemplateData.
+type Template struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// TemplateData is what a template can refer to
+type TemplateData struct {
+	Name      string // client name, may be empty
+	Code      string
+	ExpiresIn int // minutes
+
+	// Staff invitations only
+	Inviter        string // agency or landlord name
+	Link           string // where to accept the invitation, the bare token when no link is configured
+	ExpiresInHours int
+}
+
+var builtinTemplates = map[string]map[string]Template{
+	MessageActivationOTP: {
+		"en": {
+			Subject: "Your verification code",
+			Body:    "Hello{{if .Name}} {{.Name}}{{end}}, your OTP is {{.Code}}. It expires in {{.ExpiresIn}} minutes.",
+		},
+		"sw": {
+			Subject: "Nambari yako ya uthibitisho",
+			Body:    "Habari{{if .Name}} {{.Name}}{{end}}, nambari yako ya uthibitisho ni {{.Code}}. Itaisha baada ya dakika {{.ExpiresIn}}.",
+		},
+	},
+	MessageResetOTP: {
+		"en": {
+			Subject: "Your password reset code",
+			Body:    "Hello{{if .Name}} {{.Name}}{{end}}, your password reset code is {{.Code}}. It expires in {{.ExpiresIn}} minutes.",
+		},
+		"sw": {
+			Subject: "Nambari ya kubadilisha nenosiri",
+			Body:    "Habari{{if .Name}} {{.Name}}{{end}}, nambari yako ya kubadilisha nenosiri ni {{.Code}}. Itaisha baada ya dakika {{.ExpiresIn}}.",
+		},
+	},
+	MessageLoginOTP: {
+		"en": {
+			Subject: "Your login code",
+			Body:    "Hello{{if .Name}} {{.Name}}{{end}}, your login code is {{.Code}}. It expires in {{.ExpiresIn}} minutes.",
+		},
+		"sw": {
+			Subject: "Nambari yako ya kuingia",
+			Body:    "Habari{{if .Name}} {{.Name}}{{end}}, nambari yako ya kuingia ni {{.Code}}. Itaisha baada ya dakika {{.ExpiresIn}}.",
+		},
+	},
+	MessageStaffInvite: {
+		"en": {
+			Subject: "You have been invited to {{.Inviter}}",
+			Body:    "Hello{{if .Name}} {{.Name}}{{end}}, {{.Inviter}} invited you to join them. Accept the invitation and choose your password at {{.Link}} within {{.ExpiresInHours}} hours.",
+		},
+		"sw": {
+			Subject: "Umealikwa na {{.Inviter}}",
+			Body:    "Habari{{if .Name}} {{.Name}}{{end}}, {{.Inviter}} amekualika ujiunge nao. Kubali mwaliko na uchague nenosiri lako kupitia {{.Link}} ndani ya saa {{.ExpiresInHours}}.",
+		},
+	},
+}
+
+type templateKey struct {
+	kind   string
+	locale string
+}
+
+type parsedTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// TemplateRegistry holds the parsed templates keyed by message type and locale
+type TemplateRegistry struct {
+	mu        sync.RWMutex
+	templates map[templateKey]*parsedTemplate
+}
+
+// NewTemplateRegistry returns a registry with the built in English and Swahili templates
+func NewTemplateRegistry() *TemplateRegistry {
+	r := &TemplateRegistry{templates: make(map[templateKey]*parsedTemplate)}
+	for kind, locales := range builtinTemplates {
+		for locale, t := range locales {
+			if err := r.Register(kind, locale, t); err != nil {
+				panic(fmt.Sprintf("built in template %s/%s: %v", kind, locale, err))
+			}
+		}
+	}
+	return r
+}
+
+// Register adds or replaces the template of a message type in a locale
+func (r *TemplateRegistry) Register(kind, locale string, t Template) error {
+	parsed, err := parseTemplate(t)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates[templateKey{kind, locale}] = parsed
+	return nil
+}
+
+// Locale maps a preferred language such as "sw-KE" to a locale templates exist for
+func (r *TemplateRegistry) Locale(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	for _, locale := range Locales {
+		if locale == language {
+			return locale
+		}
+	}
+	return DefaultLocale
+}
+
+// Render renders a message type in a locale, falling back to the default locale when it has no template there
+func (r *TemplateRegistry) Render(kind, locale string, data TemplateData) (subject, body string, err error) {
+	r.mu.RLock()
+	parsed, ok := r.templates[templateKey{kind, locale}]
+	if !ok {
+		parsed, ok = r.templates[templateKey{kind, DefaultLocale}]
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return "", "", fmt.Errorf("no template for message type %q", kind)
+	}
+	return parsed.execute(data)
+}
+
+// RenderTemplate renders a template that isn't in the registry, such as a tenant override
+func RenderTemplate(t Template, data TemplateData) (subject, body string, err error) {
+	parsed, err := parseTemplate(t)
+	if err != nil {
+		return "", "", err
+	}
+	return parsed.execute(data)
+}
+
+// ValidateTemplate checks a template parses and renders with sample data
+func ValidateTemplate(t Template) error {
+	if strings.TrimSpace(t.Body) == "" {
+		return errors.New("body is required")
+	}
+	if len(t.Subject) > maxTemplateSubject {
+		return fmt.Errorf("subject is longer than %d characters", maxTemplateSubject)
+	}
+	if len(t.Body) > maxTemplateBody {
+		return fmt.Errorf("body is longer than %d characters", maxTemplateBody)
+	}
+	_, _, err := RenderTemplate(t, TemplateData{
+		Name: "Jane", Code: "123456", ExpiresIn: 15,
+		Inviter: "Acme Properties", Link: "https://example.com/invite?token=abc", ExpiresInHours: 72,
+	})
+	return err
+}
+
+func parseTemplate(t Template) (*parsedTemplate, error) {
+	subject, err := template.New("subject").Option("missingkey=error").Parse(t.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject: %v", err)
+	}
+	body, err := template.New("body").Option("missingkey=error").Parse(t.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	return &parsedTemplate{subject: subject, body: body}, nil
+}
+
+func (p *parsedTemplate) execute(data TemplateData) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := p.subject.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("failed to render subject: %v", err)
+	}
+	if err := p.body.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("failed to render body: %v", err)
+	}
+	return subject.String(), body.String(), nil
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateLocale(t *testing.T) {
+	r := NewTemplateRegistry()
+	tests := map[string]string{
+		"sw":     "sw",
+		"sw-KE":  "sw",
+		" SW_ke": "sw",
+		"en-GB":  "en",
+		"fr":     DefaultLocale,
+		"":       DefaultLocale,
+	}
+	for language, want := range tests {
+		if got := r.Locale(language); got != want {
+			t.Errorf("Locale(%q) = %q, want %q", language, got, want)
+		}
+	}
+}
+
+func TestRenderLocaleFallback(t *testing.T) {
+	r := NewTemplateRegistry()
+	if err := r.Register("reminder", "en", Template{Subject: "Reminder", Body: "Hello {{.Name}}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		kind    string
+		locale  string
+		subject string
+		body    string
+	}{
+		{"template in the locale", MessageLoginOTP, "sw", "Nambari yako ya kuingia", "Habari Jane, nambari yako ya kuingia ni 123456. Itaisha baada ya dakika 5."},
+		{"template in the default locale only", "reminder", "sw", "Reminder", "Hello Jane"},
+		{"unknown locale", MessageLoginOTP, "fr", "Your login code", "Hello Jane, your login code is 123456. It expires in 5 minutes."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, body, err := r.Render(tt.kind, tt.locale, TemplateData{Name: "Jane", Code: "123456", ExpiresIn: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tt.subject || body != tt.body {
+				t.Fatalf("rendered %q / %q, want %q / %q", subject, body, tt.subject, tt.body)
+			}
+		})
+	}
+
+	if _, _, err := r.Render("unknown", DefaultLocale, TemplateData{}); err == nil {
+		t.Fatal("a message type without templates rendered")
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template Template
+		err      string // empty when the template is valid
+	}{
+		{"valid", Template{Subject: "Code for {{.Name}}", Body: "Your code is {{.Code}}"}, ""},
+		{"no subject", Template{Body: "Your code is {{.Code}}"}, ""},
+		{"empty body", Template{Subject: "Code", Body: "  "}, "body is required"},
+		{"subject too long", Template{Subject: strings.Repeat("a", maxTemplateSubject+1), Body: "{{.Code}}"}, "subject is longer"},
+		{"body too long", Template{Body: strings.Repeat("a", maxTemplateBody+1)}, "body is longer"},
+		{"subject doesn't parse", Template{Subject: "{{.Name", Body: "{{.Code}}"}, "invalid subject"},
+		{"body doesn't parse", Template{Body: "{{if .Code}}"}, "invalid body"},
+		{"unknown field", Template{Body: "Your code is {{.Pin}}"}, "failed to render body"},
+		{"unknown template", Template{Subject: "{{template \"header\"}}", Body: "{{.Code}}"}, "failed to render subject"},
+		{"call of a field", Template{Body: "{{call .Code}}"}, "failed to render body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTemplate(tt.template)
+			if tt.err == "" && err != nil {
+				t.Fatalf("valid template rejected: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
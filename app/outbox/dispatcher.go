@@ -0,0 +1,161 @@
+// Package outbox delivers the notifications queued in the outbox of the store.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"log"
+	"time"
+)
+
+// Dispatcher delivers outbox messages in the background. A message that can't be delivered on
+// its channel goes to its fallback channel, when neither works it is retried with exponential
+// backoff until MaxAttempts, then dead-lettered. Several dispatchers may run against the same
+// store, claimed messages are skipped by the others.
+type Dispatcher struct {
+	Store        repository.Store
+	SMS          notify.SMSSender
+	Email        notify.EmailSender // email messages fail without one
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration // how long a claimed message is hidden from other dispatchers
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// NewDispatcher creates a dispatcher with the default batch size, intervals and retry policy
+func NewDispatcher(store repository.Store, sms notify.SMSSender, email notify.EmailSender) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		SMS:          sms,
+		Email:        email,
+		BatchSize:    20,
+		PollInterval: 2 * time.Second,
+		Lease:        time.Minute,
+		MaxAttempts:  8,
+		BaseBackoff:  15 * time.Second,
+		MaxBackoff:   time.Hour,
+	}
+}
+
+// Run delivers due messages every PollInterval until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while full batches come back, there is more waiting
+		for {
+			n, err := d.DispatchOnce(ctx)
+			if err != nil {
+				log.Printf("Outbox dispatch error: %v", err)
+				break
+			}
+			if n < d.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchOnce claims one batch of due messages, delivers them and records the outcome.
+// It returns the number of messages claimed.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// The claim pushes the messages past the lease in the transaction that locks them, so a
+	// dispatcher that dies mid-delivery doesn't lose them
+	var messages []notify.OutboxMessage
+	err := d.Store.InTx(func(store repository.Store) error {
+		var err error
+		messages, err = store.Outbox().Claim(time.Now(), d.BatchSize, d.Lease)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range messages {
+		channel, err := d.deliver(m)
+		if err != nil {
+			d.recordFailure(m, err)
+			continue
+		}
+		if err := d.Store.Outbox().MarkSent(m.ID, channel, time.Now()); err != nil {
+			// The message went out, at worst it is sent again once the lease runs out
+			log.Printf("Failed to mark outbox message %d as sent: %v", m.ID, err)
+		}
+	}
+	return len(messages), nil
+}
+
+// deliver sends the message on its channel, then on its fallback, and returns the channel that worked
+func (d *Dispatcher) deliver(m notify.OutboxMessage) (string, error) {
+	err := d.send(m.Channel, m.Recipient, m.Subject, m.Body)
+	if err == nil {
+		return m.Channel, nil
+	}
+	if m.FallbackChannel == "" {
+		return "", err
+	}
+
+	fallbackErr := d.send(m.FallbackChannel, m.FallbackRecipient, m.Subject, m.Body)
+	if fallbackErr != nil {
+		return "", fmt.Errorf("%s: %v; %s: %v", m.Channel, err, m.FallbackChannel, fallbackErr)
+	}
+	log.Printf("Outbox message %d delivered by %s after %s failed: %v", m.ID, m.FallbackChannel, m.Channel, err)
+	return m.FallbackChannel, nil
+}
+
+func (d *Dispatcher) send(channel, recipient, subject, body string) error {
+	switch channel {
+	case notify.ChannelSMS:
+		if d.SMS == nil {
+			return fmt.Errorf("no SMS sender configured")
+		}
+		return d.SMS.SendSMS(recipient, body)
+	case notify.ChannelEmail:
+		if d.Email == nil {
+			return fmt.Errorf("no email sender configured")
+		}
+		return d.Email.SendEmail(recipient, subject, body)
+	default:
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+}
+
+// recordFailure schedules the next attempt or dead-letters the message
+func (d *Dispatcher) recordFailure(m notify.OutboxMessage, deliveryErr error) {
+	dead := m.Attempts >= d.MaxAttempts
+	if dead {
+		log.Printf("Outbox message %d dead-lettered after %d attempts: %v", m.ID, m.Attempts, deliveryErr)
+	}
+
+	err := d.Store.Outbox().MarkFailed(m.ID, deliveryErr.Error(), time.Now().Add(d.backoff(m.Attempts)), dead)
+	if err != nil {
+		log.Printf("Failed to record outbox delivery failure for message %d: %v", m.ID, err)
+	}
+}
+
+// backoff returns the delay before retrying after the given number of attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/repository"
+	"testing"
+	"time"
+)
+
+// newTestDispatcher returns a dispatcher on an empty memory store with recorders for both channels
+func newTestDispatcher() (*Dispatcher, *repository.MemoryStore, *notify.Recorder, *notify.Recorder) {
+	store := repository.NewMemoryStore()
+	sms, email := &notify.Recorder{}, &notify.Recorder{}
+	return NewDispatcher(store, sms, email), store, sms, email
+}
+
+func enqueue(t *testing.T, store repository.Store, m notify.Message) {
+	t.Helper()
+	if err := store.Outbox().Enqueue(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dispatch(t *testing.T, d *Dispatcher) int {
+	t.Helper()
+	n, err := d.DispatchOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDispatchDelivers(t *testing.T) {
+	d, store, sms, _ := newTestDispatcher()
+	enqueue(t, store, notify.Message{Channel: notify.ChannelSMS, Recipient: "254700000001", Body: "Your code is 123456"})
+
+	if n := dispatch(t, d); n != 1 {
+		t.Fatalf("claimed %d messages, want 1", n)
+	}
+	if got, ok := sms.Last("254700000001"); !ok || got.Message != "Your code is 123456" {
+		t.Fatalf("sent %+v, want the queued message", got)
+	}
+
+	m := store.Messages()[0]
+	if m.Status != notify.StatusSent || m.DeliveredChannel != notify.ChannelSMS || m.Attempts != 1 {
+		t.Fatalf("message recorded as %+v, want sent by SMS on the first attempt", m)
+	}
+	if m.Body != "" {
+		t.Fatal("the body of a sent message was kept")
+	}
+	if n := dispatch(t, d); n != 0 {
+		t.Fatalf("claimed %d messages after delivery, want none", n)
+	}
+}
+
+func TestDispatchFallsBack(t *testing.T) {
+	d, store, sms, email := newTestDispatcher()
+	sms.Err = errors.New("gateway down")
+	enqueue(t, store, notify.Message{
+		Channel:           notify.ChannelSMS,
+		Recipient:         "254700000001",
+		Subject:           "Your code",
+		Body:              "Your code is 123456",
+		FallbackChannel:   notify.ChannelEmail,
+		FallbackRecipient: "client@example.com",
+	})
+
+	dispatch(t, d)
+	if got, ok := email.LastEmail("client@example.com"); !ok || got.Subject != "Your code" || got.Body != "Your code is 123456" {
+		t.Fatalf("emailed %+v, want the queued message", got)
+	}
+	if m := store.Messages()[0]; m.Status != notify.StatusSent || m.DeliveredChannel != notify.ChannelEmail {
+		t.Fatalf("message recorded as %+v, want sent by email", m)
+	}
+
+	// When the fallback fails too the message stays queued with both errors
+	email.Err = errors.New("mailbox full")
+	enqueue(t, store, notify.Message{
+		Channel:           notify.ChannelSMS,
+		Recipient:         "254700000002",
+		Body:              "Your code is 654321",
+		FallbackChannel:   notify.ChannelEmail,
+		FallbackRecipient: "other@example.com",
+	})
+	dispatch(t, d)
+	m := store.Messages()[1]
+	if m.Status != notify.StatusPending || m.LastError != "sms: gateway down; email: mailbox full" {
+		t.Fatalf("message recorded as %+v, want pending with both errors", m)
+	}
+}
+
+func TestDispatchBacksOff(t *testing.T) {
+	d, store, sms, _ := newTestDispatcher()
+	sms.Err = errors.New("gateway down")
+	enqueue(t, store, notify.Message{Channel: notify.ChannelSMS, Recipient: "254700000001", Body: "Your code is 123456"})
+
+	before := time.Now()
+	dispatch(t, d)
+	m := store.Messages()[0]
+	if m.Status != notify.StatusPending || m.LastError != "gateway down" {
+		t.Fatalf("message recorded as %+v, want pending with the error", m)
+	}
+	if m.NextAttemptAt.Before(before.Add(d.BaseBackoff)) || m.NextAttemptAt.After(time.Now().Add(d.BaseBackoff)) {
+		t.Fatalf("next attempt at %v, want %v from now", m.NextAttemptAt, d.BaseBackoff)
+	}
+	if n := dispatch(t, d); n != 0 {
+		t.Fatalf("claimed %d messages during the backoff, want none", n)
+	}
+
+	d.BaseBackoff, d.MaxBackoff = 10*time.Second, time.Minute
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{20, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff after %d attempts = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDispatchDeadLetters(t *testing.T) {
+	d, store, sms, _ := newTestDispatcher()
+	sms.Err = errors.New("gateway down")
+	d.BaseBackoff, d.MaxAttempts = 0, 3 // due again right away
+	enqueue(t, store, notify.Message{Channel: notify.ChannelSMS, Recipient: "254700000001", Body: "Your code is 123456"})
+
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		if n := dispatch(t, d); n != 1 {
+			t.Fatalf("attempt %d claimed %d messages, want 1", attempt, n)
+		}
+	}
+
+	m := store.Messages()[0]
+	if m.Status != notify.StatusDead || m.Attempts != d.MaxAttempts || m.LastError != "gateway down" {
+		t.Fatalf("message recorded as %+v, want dead after %d attempts", m, d.MaxAttempts)
+	}
+	if m.Body != "" {
+		t.Fatal("the body of a dead message was kept")
+	}
+
+	sms.Err = nil
+	if n := dispatch(t, d); n != 0 || len(sms.Messages()) != 0 {
+		t.Fatal("a dead message was sent")
+	}
+}
+
+func TestDispatchClaimsBatches(t *testing.T) {
+	d, store, sms, _ := newTestDispatcher()
+	d.BatchSize = 2
+	for _, msisdn := range []string{"254700000001", "254700000002", "254700000003"} {
+		enqueue(t, store, notify.Message{Channel: notify.ChannelSMS, Recipient: msisdn, Body: "hello"})
+	}
+
+	if n := dispatch(t, d); n != 2 {
+		t.Fatalf("first batch has %d messages, want 2", n)
+	}
+	if _, ok := sms.Last("254700000003"); ok {
+		t.Fatal("the newest message went out in the first batch")
+	}
+	if n := dispatch(t, d); n != 1 {
+		t.Fatalf("second batch has %d messages, want 1", n)
+	}
+}
@@ -0,0 +1,1403 @@
+package repository
+
+import (
+	"bytes"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements Store in memory. Transactions run one at a time and are rolled back by
+// restoring a copy of the data taken when they started.
+type MemoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool
+}
+
+type memoryData struct {
+	clients      map[int]models.Client
+	agencies     map[int]models.Agency
+	landlords    map[int]models.Landlord
+	users        map[int]models.User // the embedded client is kept in clients
+	invitations  map[int]models.Invitation
+	permissions  []models.Permission
+	roles        map[int]models.Role
+	userRoles    map[userRoleKey]bool
+	otps         map[int]models.OTPCode
+	policies     map[policyKey]models.OTPPolicyOverride
+	templates    map[templateKey]models.MessageTemplate
+	messages     []notify.OutboxMessage
+	nextClientID int
+	nextOTPID    int
+
+	nextInvitationID int
+	nextRoleID       int
+
+	refreshTokens   map[int]models.RefreshToken
+	revokedTokens   map[string]time.Time // jti to expiry
+	invalidBefore   map[int]time.Time
+	mfa             map[int]models.MFASettings // the method is kept on the client
+	recoveryCodes   map[int]models.RecoveryCode
+	oauthClients    map[string]models.OAuthClient
+	authCodes       map[int]models.AuthorizationCode
+	passkeys        map[int]models.Passkey
+	challenges      map[int]models.WebAuthnChallenge
+	nextTokenID     int
+	nextRecoveryID  int
+	nextAuthCodeID  int
+	nextPasskeyID   int
+	nextChallengeID int
+}
+
+type policyKey struct {
+	tenantID int
+	purpose  string
+}
+
+type userRoleKey struct {
+	userID int
+	roleID int
+}
+
+type templateKey struct {
+	tenantID int
+	kind     string
+	locale   string
+}
+
+// NewMemoryStore returns an empty store
+func NewMemoryStore() *MemoryStore {
+	permissions := make([]models.Permission, len(permissionCatalog))
+	for i, permission := range permissionCatalog {
+		permission.ID = i + 1
+		permissions[i] = permission
+	}
+	return &MemoryStore{
+		mu: &sync.Mutex{},
+		data: &memoryData{
+			clients:      make(map[int]models.Client),
+			agencies:     make(map[int]models.Agency),
+			landlords:    make(map[int]models.Landlord),
+			users:        make(map[int]models.User),
+			invitations:  make(map[int]models.Invitation),
+			permissions:  permissions,
+			roles:        make(map[int]models.Role),
+			userRoles:    make(map[userRoleKey]bool),
+			otps:         make(map[int]models.OTPCode),
+			policies:     make(map[policyKey]models.OTPPolicyOverride),
+			templates:    make(map[templateKey]models.MessageTemplate),
+			nextClientID: 1,
+			nextOTPID:    1,
+
+			nextInvitationID: 1,
+			nextRoleID:       1,
+
+			refreshTokens:   make(map[int]models.RefreshToken),
+			revokedTokens:   make(map[string]time.Time),
+			invalidBefore:   make(map[int]time.Time),
+			mfa:             make(map[int]models.MFASettings),
+			recoveryCodes:   make(map[int]models.RecoveryCode),
+			oauthClients:    make(map[string]models.OAuthClient),
+			authCodes:       make(map[int]models.AuthorizationCode),
+			passkeys:        make(map[int]models.Passkey),
+			challenges:      make(map[int]models.WebAuthnChallenge),
+			nextTokenID:     1,
+			nextRecoveryID:  1,
+			nextAuthCodeID:  1,
+			nextPasskeyID:   1,
+			nextChallengeID: 1,
+		},
+	}
+}
+
+func (d *memoryData) clone() *memoryData {
+	c := *d
+	c.clients = make(map[int]models.Client, len(d.clients))
+	for k, v := range d.clients {
+		c.clients[k] = v
+	}
+	c.agencies = make(map[int]models.Agency, len(d.agencies))
+	for k, v := range d.agencies {
+		c.agencies[k] = v
+	}
+	c.landlords = make(map[int]models.Landlord, len(d.landlords))
+	for k, v := range d.landlords {
+		c.landlords[k] = v
+	}
+	c.users = make(map[int]models.User, len(d.users))
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	c.invitations = make(map[int]models.Invitation, len(d.invitations))
+	for k, v := range d.invitations {
+		c.invitations[k] = v
+	}
+	c.roles = make(map[int]models.Role, len(d.roles))
+	for k, v := range d.roles {
+		c.roles[k] = v
+	}
+	c.userRoles = make(map[userRoleKey]bool, len(d.userRoles))
+	for k, v := range d.userRoles {
+		c.userRoles[k] = v
+	}
+	c.otps = make(map[int]models.OTPCode, len(d.otps))
+	for k, v := range d.otps {
+		c.otps[k] = v
+	}
+	c.policies = make(map[policyKey]models.OTPPolicyOverride, len(d.policies))
+	for k, v := range d.policies {
+		c.policies[k] = v
+	}
+	c.templates = make(map[templateKey]models.MessageTemplate, len(d.templates))
+	for k, v := range d.templates {
+		c.templates[k] = v
+	}
+	c.messages = append([]notify.OutboxMessage(nil), d.messages...)
+	c.refreshTokens = make(map[int]models.RefreshToken, len(d.refreshTokens))
+	for k, v := range d.refreshTokens {
+		c.refreshTokens[k] = v
+	}
+	c.revokedTokens = make(map[string]time.Time, len(d.revokedTokens))
+	for k, v := range d.revokedTokens {
+		c.revokedTokens[k] = v
+	}
+	c.invalidBefore = make(map[int]time.Time, len(d.invalidBefore))
+	for k, v := range d.invalidBefore {
+		c.invalidBefore[k] = v
+	}
+	c.mfa = make(map[int]models.MFASettings, len(d.mfa))
+	for k, v := range d.mfa {
+		c.mfa[k] = v
+	}
+	c.recoveryCodes = make(map[int]models.RecoveryCode, len(d.recoveryCodes))
+	for k, v := range d.recoveryCodes {
+		c.recoveryCodes[k] = v
+	}
+	c.oauthClients = make(map[string]models.OAuthClient, len(d.oauthClients))
+	for k, v := range d.oauthClients {
+		c.oauthClients[k] = v
+	}
+	c.authCodes = make(map[int]models.AuthorizationCode, len(d.authCodes))
+	for k, v := range d.authCodes {
+		c.authCodes[k] = v
+	}
+	c.passkeys = make(map[int]models.Passkey, len(d.passkeys))
+	for k, v := range d.passkeys {
+		c.passkeys[k] = v
+	}
+	c.challenges = make(map[int]models.WebAuthnChallenge, len(d.challenges))
+	for k, v := range d.challenges {
+		c.challenges[k] = v
+	}
+	return &c
+}
+
+// lock guards a single operation, a transaction holds the lock for its whole run
+func (s *MemoryStore) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// InTx implements Store
+func (s *MemoryStore) InTx(fn func(store Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.data.clone()
+	if err := fn(&MemoryStore{mu: s.mu, data: s.data, inTx: true}); err != nil {
+		*s.data = *snapshot
+		return err
+	}
+	return nil
+}
+
+// Messages returns the notifications queued so far with their delivery state
+func (s *MemoryStore) Messages() []notify.OutboxMessage {
+	defer s.lock()()
+	return append([]notify.OutboxMessage(nil), s.data.messages...)
+}
+
+func (s *MemoryStore) Clients() ClientRepository         { return memoryClients{s} }
+func (s *MemoryStore) Agencies() AgencyRepository        { return memoryAgencies{s} }
+func (s *MemoryStore) Landlords() LandlordRepository     { return memoryLandlords{s} }
+func (s *MemoryStore) Users() UserRepository             { return memoryUsers{s} }
+func (s *MemoryStore) Invitations() InvitationRepository { return memoryInvitations{s} }
+func (s *MemoryStore) Roles() RoleRepository             { return memoryRoles{s} }
+func (s *MemoryStore) OTPs() OTPRepository               { return memoryOTPs{s} }
+func (s *MemoryStore) Templates() TemplateRepository     { return memoryTemplates{s} }
+func (s *MemoryStore) Outbox() OutboxRepository          { return memoryOutbox{s} }
+func (s *MemoryStore) Tokens() TokenRepository           { return memoryTokens{s} }
+func (s *MemoryStore) MFA() MFARepository                { return memoryMFA{s} }
+func (s *MemoryStore) OAuth() OAuthRepository            { return memoryOAuth{s} }
+func (s *MemoryStore) WebAuthn() WebAuthnRepository      { return memoryWebAuthn{s} }
+
+type memoryClients struct {
+	s *MemoryStore
+}
+
+func (r memoryClients) Create(client *models.Client) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	for _, existing := range d.clients {
+		if existing.Contact == client.Contact || client.Email != "" && existing.Email == client.Email {
+			return ErrDuplicate
+		}
+	}
+	client.ID = d.nextClientID
+	d.nextClientID++
+	// Same defaults as the clients table
+	client.IsActive = true
+	if client.MFAMethod == "" {
+		client.MFAMethod = "none"
+	}
+	d.clients[client.ID] = *client
+	return nil
+}
+
+func (r memoryClients) GetByID(id int) (*models.Client, error) {
+	defer r.s.lock()()
+	client, ok := r.s.data.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &client, nil
+}
+
+func (r memoryClients) GetByIDForUpdate(id int) (*models.Client, error) {
+	return r.GetByID(id)
+}
+
+func (r memoryClients) GetByContact(contact string) (*models.Client, error) {
+	return r.find(func(c models.Client) bool { return c.Contact == contact })
+}
+
+func (r memoryClients) GetByEmail(email string) (*models.Client, error) {
+	return r.find(func(c models.Client) bool { return c.Email != "" && c.Email == email })
+}
+
+func (r memoryClients) find(match func(models.Client) bool) (*models.Client, error) {
+	defer r.s.lock()()
+	for _, client := range r.s.data.clients {
+		if match(client) {
+			return &client, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryClients) update(id int, change func(*models.Client)) error {
+	defer r.s.lock()()
+	client, ok := r.s.data.clients[id]
+	if !ok {
+		return nil
+	}
+	change(&client)
+	r.s.data.clients[id] = client
+	return nil
+}
+
+func (r memoryClients) SetVerified(id int, at time.Time) error {
+	return r.update(id, func(c *models.Client) { c.IsVerified, c.UpdatedAt = true, at })
+}
+
+func (r memoryClients) SetPassword(id int, hash string, at time.Time) error {
+	return r.update(id, func(c *models.Client) { c.Password, c.UpdatedAt = hash, at })
+}
+
+func (r memoryClients) SetPreferredLanguage(id int, language string) error {
+	return r.update(id, func(c *models.Client) { c.PreferredLanguage = language })
+}
+
+func (r memoryClients) SetActive(id int, active bool, at time.Time) error {
+	if err := r.update(id, func(c *models.Client) { c.IsActive, c.UpdatedAt = active, at }); err != nil || active {
+		return err
+	}
+	return memoryTokens{r.s}.SetInvalidBefore(id, at)
+}
+
+func (r memoryClients) Delete(id int) error {
+	defer r.s.lock()()
+	d := r.s.data
+	delete(d.clients, id)
+	delete(d.agencies, id)
+	delete(d.landlords, id)
+	delete(d.users, id)
+	for landlordID, landlord := range d.landlords {
+		if landlord.AgencyID != nil && *landlord.AgencyID == id {
+			landlord.AgencyID = nil
+			d.landlords[landlordID] = landlord
+		}
+	}
+	for invitationID, invitation := range d.invitations {
+		if invitation.OwnerID == id {
+			delete(d.invitations, invitationID)
+		}
+	}
+	for roleID, role := range d.roles {
+		if role.OwnerID == id {
+			delete(d.roles, roleID)
+		}
+	}
+	for key := range d.userRoles {
+		if _, ok := d.roles[key.roleID]; key.userID == id || !ok {
+			delete(d.userRoles, key)
+		}
+	}
+	for otpID, code := range d.otps {
+		if code.ClientID == id {
+			delete(d.otps, otpID)
+		}
+	}
+	for tokenID, token := range d.refreshTokens {
+		if token.ClientID == id {
+			delete(d.refreshTokens, tokenID)
+		}
+	}
+	delete(d.invalidBefore, id)
+	delete(d.mfa, id)
+	for codeID, code := range d.recoveryCodes {
+		if code.ClientID == id {
+			delete(d.recoveryCodes, codeID)
+		}
+	}
+	for codeID, code := range d.authCodes {
+		if code.ClientID == id {
+			delete(d.authCodes, codeID)
+		}
+	}
+	for passkeyID, passkey := range d.passkeys {
+		if passkey.ClientID == id {
+			delete(d.passkeys, passkeyID)
+		}
+	}
+	for challengeID, challenge := range d.challenges {
+		if challenge.ClientID != nil && *challenge.ClientID == id {
+			delete(d.challenges, challengeID)
+		}
+	}
+	return nil
+}
+
+func (r memoryClients) Tenant(id int) (int, error) {
+	defer r.s.lock()()
+	if _, ok := r.s.data.clients[id]; !ok {
+		return 0, ErrNotFound
+	}
+	if landlord, ok := r.s.data.landlords[id]; ok && landlord.AgencyID != nil {
+		return *landlord.AgencyID, nil
+	}
+	if user, ok := r.s.data.users[id]; ok {
+		return user.OwnerID, nil
+	}
+	return id, nil
+}
+
+type memoryAgencies struct {
+	s *MemoryStore
+}
+
+func (r memoryAgencies) Create(agency *models.Agency) error {
+	defer r.s.lock()()
+	for _, existing := range r.s.data.agencies {
+		if agency.TaxID != "" && existing.TaxID == agency.TaxID {
+			return ErrDuplicate
+		}
+	}
+	r.s.data.agencies[agency.ID] = *agency
+	return nil
+}
+
+func (r memoryAgencies) Exists(id int) (bool, error) {
+	defer r.s.lock()()
+	_, ok := r.s.data.agencies[id]
+	return ok, nil
+}
+
+type memoryLandlords struct {
+	s *MemoryStore
+}
+
+func (r memoryLandlords) Create(landlord *models.Landlord) error {
+	defer r.s.lock()()
+	r.s.data.landlords[landlord.ID] = *landlord
+	return nil
+}
+
+// Helper function to join a landlord with its client record
+func (r memoryLandlords) get(id int) (*models.Landlord, bool) {
+	landlord, ok := r.s.data.landlords[id]
+	if !ok {
+		return nil, false
+	}
+	landlord.Client = r.s.data.clients[id]
+	return &landlord, true
+}
+
+func (r memoryLandlords) GetByID(id int) (*models.Landlord, error) {
+	defer r.s.lock()()
+	landlord, ok := r.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return landlord, nil
+}
+
+func (r memoryLandlords) ListByAgency(agencyID int) ([]models.Landlord, error) {
+	defer r.s.lock()()
+	landlords := []models.Landlord{}
+	for id, landlord := range r.s.data.landlords {
+		if landlord.AgencyID != nil && *landlord.AgencyID == agencyID {
+			joined, _ := r.get(id)
+			landlords = append(landlords, *joined)
+		}
+	}
+	sort.Slice(landlords, func(i, j int) bool {
+		if !landlords[i].CreatedAt.Equal(landlords[j].CreatedAt) {
+			return landlords[i].CreatedAt.Before(landlords[j].CreatedAt)
+		}
+		return landlords[i].ID < landlords[j].ID
+	})
+	return landlords, nil
+}
+
+func (r memoryLandlords) Update(landlord *models.Landlord) error {
+	defer r.s.lock()()
+	stored, ok := r.s.data.landlords[landlord.ID]
+	if !ok {
+		return nil
+	}
+	stored.Name, stored.Address = landlord.Name, landlord.Address
+	r.s.data.landlords[landlord.ID] = stored
+
+	client := r.s.data.clients[landlord.ID]
+	client.Name, client.UpdatedAt = landlord.Client.Name, landlord.UpdatedAt
+	r.s.data.clients[landlord.ID] = client
+	return nil
+}
+
+func (r memoryLandlords) SetAgency(id int, agencyID *int) error {
+	defer r.s.lock()()
+	landlord, ok := r.s.data.landlords[id]
+	if !ok {
+		return nil
+	}
+	landlord.AgencyID = agencyID
+	r.s.data.landlords[id] = landlord
+	return nil
+}
+
+type memoryUsers struct {
+	s *MemoryStore
+}
+
+func (r memoryUsers) Create(user *models.User) error {
+	defer r.s.lock()()
+	r.s.data.users[user.ID] = *user
+	return nil
+}
+
+// Helper function to join a user with its client record
+func (r memoryUsers) get(id int) (*models.User, bool) {
+	user, ok := r.s.data.users[id]
+	if !ok {
+		return nil, false
+	}
+	user.Client = r.s.data.clients[id]
+	return &user, true
+}
+
+func (r memoryUsers) GetByID(id int) (*models.User, error) {
+	defer r.s.lock()()
+	user, ok := r.get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) ListByOwner(ownerID int) ([]models.User, error) {
+	defer r.s.lock()()
+	users := []models.User{}
+	for id, user := range r.s.data.users {
+		if user.OwnerID == ownerID {
+			joined, _ := r.get(id)
+			users = append(users, *joined)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (r memoryUsers) Update(user *models.User) error {
+	defer r.s.lock()()
+	stored, ok := r.s.data.users[user.ID]
+	if !ok {
+		return nil
+	}
+	stored.FirstName, stored.LastName, stored.Position = user.FirstName, user.LastName, user.Position
+	r.s.data.users[user.ID] = stored
+
+	client := r.s.data.clients[user.ID]
+	client.Name, client.UpdatedAt = user.Name, user.UpdatedAt
+	r.s.data.clients[user.ID] = client
+	return nil
+}
+
+type memoryInvitations struct {
+	s *MemoryStore
+}
+
+func (r memoryInvitations) Create(invitation *models.Invitation) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	invitation.ID = d.nextInvitationID
+	d.nextInvitationID++
+	d.invitations[invitation.ID] = *invitation
+	return nil
+}
+
+func (r memoryInvitations) GetByID(id int) (*models.Invitation, error) {
+	defer r.s.lock()()
+	invitation, ok := r.s.data.invitations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &invitation, nil
+}
+
+func (r memoryInvitations) GetByIDForUpdate(id int) (*models.Invitation, error) {
+	return r.GetByID(id)
+}
+
+func (r memoryInvitations) ListPending(ownerID int) ([]models.Invitation, error) {
+	defer r.s.lock()()
+	invitations := []models.Invitation{}
+	for _, invitation := range r.s.data.invitations {
+		if invitation.OwnerID == ownerID && invitation.AcceptedAt == nil && invitation.RevokedAt == nil {
+			invitations = append(invitations, invitation)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		if !invitations[i].CreatedAt.Equal(invitations[j].CreatedAt) {
+			return invitations[i].CreatedAt.Before(invitations[j].CreatedAt)
+		}
+		return invitations[i].ID < invitations[j].ID
+	})
+	return invitations, nil
+}
+
+func (r memoryInvitations) HasPending(ownerID int, email, contact string, now time.Time) (bool, error) {
+	defer r.s.lock()()
+	for _, invitation := range r.s.data.invitations {
+		if invitation.OwnerID != ownerID || invitation.AcceptedAt != nil || invitation.RevokedAt != nil ||
+			!invitation.ExpiresAt.After(now) {
+			continue
+		}
+		if email != "" && invitation.Email == email || contact != "" && invitation.Contact == contact {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryInvitations) update(id int, change func(*models.Invitation)) error {
+	defer r.s.lock()()
+	invitation, ok := r.s.data.invitations[id]
+	if !ok {
+		return nil
+	}
+	change(&invitation)
+	r.s.data.invitations[id] = invitation
+	return nil
+}
+
+func (r memoryInvitations) SetToken(id int, tokenID string, expiresAt, sentAt time.Time) error {
+	return r.update(id, func(i *models.Invitation) { i.TokenID, i.ExpiresAt, i.SentAt = tokenID, expiresAt, sentAt })
+}
+
+func (r memoryInvitations) Revoke(id int, at time.Time) error {
+	return r.update(id, func(i *models.Invitation) { i.RevokedAt = &at })
+}
+
+func (r memoryInvitations) MarkAccepted(id, userID int, at time.Time) error {
+	return r.update(id, func(i *models.Invitation) { i.AcceptedAt, i.UserID = &at, &userID })
+}
+
+// permissionCatalog holds the same rows the 018 migration seeds
+var permissionCatalog = []models.Permission{
+	{Name: "properties:read", Description: "View properties"},
+	{Name: "properties:write", Description: "Create, update and delete properties"},
+	{Name: "units:read", Description: "View units"},
+	{Name: "units:write", Description: "Create, update and delete units"},
+	{Name: "tenants:read", Description: "View tenants"},
+	{Name: "tenants:write", Description: "Create, update and delete tenants"},
+	{Name: "leases:read", Description: "View leases"},
+	{Name: "leases:write", Description: "Create, renew and terminate leases"},
+	{Name: "payments:read", Description: "View payments and invoices"},
+	{Name: "payments:write", Description: "Record payments and issue invoices"},
+	{Name: "maintenance:read", Description: "View maintenance requests"},
+	{Name: "maintenance:write", Description: "Create and update maintenance requests"},
+	{Name: "reports:read", Description: "View reports"},
+	{Name: "users:read", Description: "View staff accounts and invitations"},
+	{Name: "users:write", Description: "Invite, update and deactivate staff accounts"},
+	{Name: "roles:read", Description: "View roles and their permissions"},
+	{Name: "roles:write", Description: "Create roles, change their permissions and assign them"},
+}
+
+type memoryRoles struct {
+	s *MemoryStore
+}
+
+func (r memoryRoles) Permissions() ([]models.Permission, error) {
+	defer r.s.lock()()
+	permissions := append([]models.Permission{}, r.s.data.permissions...)
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Name < permissions[j].Name })
+	return permissions, nil
+}
+
+func (r memoryRoles) PermissionsByName(names []string) ([]models.Permission, error) {
+	all, _ := r.Permissions()
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	permissions := []models.Permission{}
+	for _, permission := range all {
+		if wanted[permission.Name] {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// Helper function to keep the catalog names of a role, sorted like the MySQL store returns them
+func (r memoryRoles) knownPermissions(names []string) []string {
+	known := make(map[string]bool, len(r.s.data.permissions))
+	for _, permission := range r.s.data.permissions {
+		known[permission.Name] = true
+	}
+	kept := []string{}
+	for _, name := range names {
+		if known[name] {
+			kept = append(kept, name)
+			known[name] = false
+		}
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+// Helper function to check no other role of the owner has the name
+func (r memoryRoles) nameTaken(role *models.Role) bool {
+	for _, existing := range r.s.data.roles {
+		if existing.ID != role.ID && existing.OwnerID == role.OwnerID && existing.Name == role.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryRoles) Create(role *models.Role) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	if r.nameTaken(role) {
+		return ErrDuplicate
+	}
+	role.ID = d.nextRoleID
+	d.nextRoleID++
+	role.Permissions = r.knownPermissions(role.Permissions)
+	d.roles[role.ID] = *role
+	return nil
+}
+
+func (r memoryRoles) GetByID(id int) (*models.Role, error) {
+	defer r.s.lock()()
+	role, ok := r.s.data.roles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	role.Permissions = append([]string{}, role.Permissions...)
+	return &role, nil
+}
+
+// Helper function to copy the matching roles, ordered by name
+func (r memoryRoles) list(match func(models.Role) bool) []models.Role {
+	roles := []models.Role{}
+	for _, role := range r.s.data.roles {
+		if match(role) {
+			role.Permissions = append([]string{}, role.Permissions...)
+			roles = append(roles, role)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+func (r memoryRoles) ListByOwner(ownerID int) ([]models.Role, error) {
+	defer r.s.lock()()
+	return r.list(func(role models.Role) bool { return role.OwnerID == ownerID }), nil
+}
+
+func (r memoryRoles) Update(role *models.Role) error {
+	defer r.s.lock()()
+	stored, ok := r.s.data.roles[role.ID]
+	if !ok {
+		return nil
+	}
+	if r.nameTaken(role) {
+		return ErrDuplicate
+	}
+	stored.Name, stored.Description, stored.UpdatedAt = role.Name, role.Description, role.UpdatedAt
+	stored.Permissions = r.knownPermissions(role.Permissions)
+	r.s.data.roles[role.ID] = stored
+	return nil
+}
+
+func (r memoryRoles) Delete(id int) error {
+	defer r.s.lock()()
+	delete(r.s.data.roles, id)
+	for key := range r.s.data.userRoles {
+		if key.roleID == id {
+			delete(r.s.data.userRoles, key)
+		}
+	}
+	return nil
+}
+
+func (r memoryRoles) UserRoles(userID int) ([]models.Role, error) {
+	defer r.s.lock()()
+	return r.list(func(role models.Role) bool { return r.s.data.userRoles[userRoleKey{userID, role.ID}] }), nil
+}
+
+func (r memoryRoles) SetUserRoles(userID int, roleIDs []int) error {
+	defer r.s.lock()()
+	for key := range r.s.data.userRoles {
+		if key.userID == userID {
+			delete(r.s.data.userRoles, key)
+		}
+	}
+	for _, roleID := range roleIDs {
+		r.s.data.userRoles[userRoleKey{userID, roleID}] = true
+	}
+	return nil
+}
+
+func (r memoryRoles) UserPermissions(userID int) ([]string, error) {
+	defer r.s.lock()()
+	held := make(map[string]bool)
+	for key := range r.s.data.userRoles {
+		if key.userID != userID {
+			continue
+		}
+		for _, name := range r.s.data.roles[key.roleID].Permissions {
+			held[name] = true
+		}
+	}
+	names := make([]string, 0, len(held))
+	for name := range held {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type memoryOTPs struct {
+	s *MemoryStore
+}
+
+func (r memoryOTPs) Create(code *models.OTPCode) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	code.ID = d.nextOTPID
+	d.nextOTPID++
+	code.CreatedAt = time.Now()
+	d.otps[code.ID] = *code
+	return nil
+}
+
+func (r memoryOTPs) LatestUnused(clientID int, purpose string) (*models.OTPCode, error) {
+	defer r.s.lock()()
+	var latest *models.OTPCode
+	for _, code := range r.s.data.otps {
+		if code.ClientID != clientID || code.Purpose != purpose || code.Used {
+			continue
+		}
+		if latest == nil || code.ExpiresAt.After(latest.ExpiresAt) {
+			code := code
+			latest = &code
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return latest, nil
+}
+
+func (r memoryOTPs) RecordFailure(id, failedAttempts int, burn bool) error {
+	defer r.s.lock()()
+	if code, ok := r.s.data.otps[id]; ok {
+		code.FailedAttempts, code.Used = failedAttempts, burn
+		r.s.data.otps[id] = code
+	}
+	return nil
+}
+
+func (r memoryOTPs) MarkUsed(id int) error {
+	defer r.s.lock()()
+	if code, ok := r.s.data.otps[id]; ok {
+		code.Used = true
+		r.s.data.otps[id] = code
+	}
+	return nil
+}
+
+func (r memoryOTPs) InvalidateUnused(clientID int, purpose string) error {
+	defer r.s.lock()()
+	for id, code := range r.s.data.otps {
+		if code.ClientID == clientID && code.Purpose == purpose {
+			code.Used = true
+			r.s.data.otps[id] = code
+		}
+	}
+	return nil
+}
+
+func (r memoryOTPs) SinceLast(clientID int, purpose string) (time.Duration, bool, error) {
+	defer r.s.lock()()
+	var last time.Time
+	for _, code := range r.s.data.otps {
+		if code.ClientID == clientID && code.Purpose == purpose && code.CreatedAt.After(last) {
+			last = code.CreatedAt
+		}
+	}
+	if last.IsZero() {
+		return 0, false, nil
+	}
+	return time.Since(last), true, nil
+}
+
+func (r memoryOTPs) SinceNth(clientID, n int, window time.Duration) (time.Duration, bool, error) {
+	defer r.s.lock()()
+	var created []time.Time
+	for _, code := range r.s.data.otps {
+		if code.ClientID == clientID && time.Since(code.CreatedAt) < window {
+			created = append(created, code.CreatedAt)
+		}
+	}
+	if n < 1 || len(created) < n {
+		return 0, false, nil
+	}
+	sort.Slice(created, func(i, j int) bool { return created[i].After(created[j]) })
+	return time.Since(created[n-1]), true, nil
+}
+
+func (r memoryOTPs) GetPolicyOverride(tenantID int, purpose string) (*models.OTPPolicyOverride, error) {
+	defer r.s.lock()()
+	override, ok := r.s.data.policies[policyKey{tenantID, purpose}]
+	if !ok {
+		return nil, nil
+	}
+	return &override, nil
+}
+
+func (r memoryOTPs) SavePolicyOverride(override *models.OTPPolicyOverride) error {
+	defer r.s.lock()()
+	r.s.data.policies[policyKey{override.TenantID, override.Purpose}] = *override
+	return nil
+}
+
+func (r memoryOTPs) DeletePolicyOverride(tenantID int, purpose string) (bool, error) {
+	defer r.s.lock()()
+	key := policyKey{tenantID, purpose}
+	_, ok := r.s.data.policies[key]
+	delete(r.s.data.policies, key)
+	return ok, nil
+}
+
+type memoryTemplates struct {
+	s *MemoryStore
+}
+
+func (r memoryTemplates) Get(tenantID int, kind, locale string) (*models.MessageTemplate, error) {
+	defer r.s.lock()()
+	t, ok := r.s.data.templates[templateKey{tenantID, kind, locale}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (r memoryTemplates) List(tenantID int) ([]models.MessageTemplate, error) {
+	defer r.s.lock()()
+	templates := []models.MessageTemplate{}
+	for key, t := range r.s.data.templates {
+		if key.tenantID == tenantID {
+			templates = append(templates, t)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Type != templates[j].Type {
+			return templates[i].Type < templates[j].Type
+		}
+		return templates[i].Locale < templates[j].Locale
+	})
+	return templates, nil
+}
+
+func (r memoryTemplates) Save(t *models.MessageTemplate) error {
+	defer r.s.lock()()
+	saved := *t
+	saved.UpdatedAt = time.Now()
+	r.s.data.templates[templateKey{t.TenantID, t.Type, t.Locale}] = saved
+	return nil
+}
+
+func (r memoryTemplates) Delete(tenantID int, kind, locale string) (bool, error) {
+	defer r.s.lock()()
+	key := templateKey{tenantID, kind, locale}
+	_, ok := r.s.data.templates[key]
+	delete(r.s.data.templates, key)
+	return ok, nil
+}
+
+type memoryOutbox struct {
+	s *MemoryStore
+}
+
+func (r memoryOutbox) Enqueue(message notify.Message) error {
+	defer r.s.lock()()
+	d := r.s.data
+	d.messages = append(d.messages, notify.OutboxMessage{
+		Message:       message,
+		ID:            int64(len(d.messages) + 1),
+		Status:        notify.StatusPending,
+		NextAttemptAt: time.Now(),
+	})
+	return nil
+}
+
+func (r memoryOutbox) Claim(now time.Time, limit int, lease time.Duration) ([]notify.OutboxMessage, error) {
+	defer r.s.lock()()
+	d := r.s.data
+
+	var due []int
+	for i, m := range d.messages {
+		if m.Status == notify.StatusPending && !m.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return d.messages[due[i]].NextAttemptAt.Before(d.messages[due[j]].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	messages := make([]notify.OutboxMessage, 0, len(due))
+	for _, i := range due {
+		d.messages[i].Attempts++
+		d.messages[i].NextAttemptAt = now.Add(lease)
+		messages = append(messages, d.messages[i])
+	}
+	return messages, nil
+}
+
+func (r memoryOutbox) MarkSent(id int64, channel string, at time.Time) error {
+	defer r.s.lock()()
+	m, err := r.message(id)
+	if err != nil {
+		return err
+	}
+	m.Status, m.DeliveredChannel, m.LastError, m.Body = notify.StatusSent, channel, "", ""
+	return nil
+}
+
+func (r memoryOutbox) MarkFailed(id int64, lastError string, nextAttemptAt time.Time, dead bool) error {
+	defer r.s.lock()()
+	m, err := r.message(id)
+	if err != nil {
+		return err
+	}
+	m.NextAttemptAt, m.LastError = nextAttemptAt, lastError
+	if dead {
+		m.Status, m.Body = notify.StatusDead, ""
+	}
+	return nil
+}
+
+// message returns the stored message to update, the caller holds the lock
+func (r memoryOutbox) message(id int64) (*notify.OutboxMessage, error) {
+	if id < 1 || id > int64(len(r.s.data.messages)) {
+		return nil, ErrNotFound
+	}
+	return &r.s.data.messages[id-1], nil
+}
+
+type memoryTokens struct {
+	s *MemoryStore
+}
+
+func (r memoryTokens) CreateRefreshToken(token *models.RefreshToken) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	token.ID = d.nextTokenID
+	d.nextTokenID++
+	d.refreshTokens[token.ID] = *token
+	return nil
+}
+
+func (r memoryTokens) GetRefreshToken(hash string) (*models.RefreshToken, error) {
+	defer r.s.lock()()
+	for _, token := range r.s.data.refreshTokens {
+		if token.Hash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryTokens) GetRefreshTokenForUpdate(hash string) (*models.RefreshToken, error) {
+	return r.GetRefreshToken(hash)
+}
+
+func (r memoryTokens) MarkRefreshTokenReplaced(id, replacedBy int, at time.Time) error {
+	defer r.s.lock()()
+	if token, ok := r.s.data.refreshTokens[id]; ok {
+		token.RevokedAt, token.ReplacedBy = &at, &replacedBy
+		r.s.data.refreshTokens[id] = token
+	}
+	return nil
+}
+
+func (r memoryTokens) revoke(at time.Time, match func(models.RefreshToken) bool) error {
+	defer r.s.lock()()
+	for id, token := range r.s.data.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &at
+			r.s.data.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (r memoryTokens) RevokeRefreshFamily(familyID string, at time.Time) error {
+	return r.revoke(at, func(t models.RefreshToken) bool { return t.FamilyID == familyID })
+}
+
+func (r memoryTokens) RevokeClientRefreshTokens(clientID int, at time.Time) error {
+	return r.revoke(at, func(t models.RefreshToken) bool { return t.ClientID == clientID })
+}
+
+func (r memoryTokens) RevokeAccessToken(jti string, clientID int, expiresAt time.Time) error {
+	defer r.s.lock()()
+	if _, ok := r.s.data.revokedTokens[jti]; !ok {
+		r.s.data.revokedTokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (r memoryTokens) IsAccessTokenRevoked(jti string) (bool, error) {
+	defer r.s.lock()()
+	_, ok := r.s.data.revokedTokens[jti]
+	return ok, nil
+}
+
+func (r memoryTokens) PurgeRevokedAccessTokens(now time.Time) error {
+	defer r.s.lock()()
+	for jti, expiresAt := range r.s.data.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(r.s.data.revokedTokens, jti)
+		}
+	}
+	return nil
+}
+
+func (r memoryTokens) InvalidBefore(clientID int) (*time.Time, error) {
+	defer r.s.lock()()
+	at, ok := r.s.data.invalidBefore[clientID]
+	if !ok {
+		return nil, nil
+	}
+	return &at, nil
+}
+
+func (r memoryTokens) SetInvalidBefore(clientID int, at time.Time) error {
+	defer r.s.lock()()
+	if _, ok := r.s.data.clients[clientID]; ok {
+		r.s.data.invalidBefore[clientID] = tokenCutoff(at)
+	}
+	return nil
+}
+
+type memoryMFA struct {
+	s *MemoryStore
+}
+
+func (r memoryMFA) Settings(clientID int) (*models.MFASettings, error) {
+	defer r.s.lock()()
+	client, ok := r.s.data.clients[clientID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	settings := r.s.data.mfa[clientID]
+	settings.Method = client.MFAMethod
+	return &settings, nil
+}
+
+func (r memoryMFA) SettingsForUpdate(clientID int) (*models.MFASettings, error) {
+	return r.Settings(clientID)
+}
+
+func (r memoryMFA) update(clientID int, change func(*models.MFASettings)) error {
+	defer r.s.lock()()
+	client, ok := r.s.data.clients[clientID]
+	if !ok {
+		return nil
+	}
+	settings := r.s.data.mfa[clientID]
+	settings.Method = client.MFAMethod
+	change(&settings)
+	client.MFAMethod = settings.Method
+	r.s.data.clients[clientID] = client
+	r.s.data.mfa[clientID] = settings
+	return nil
+}
+
+func (r memoryMFA) SetTOTPSecret(clientID int, secret string, at time.Time) error {
+	return r.update(clientID, func(m *models.MFASettings) { m.TOTPSecret, m.TOTPLastStep = secret, nil })
+}
+
+func (r memoryMFA) EnableTOTP(clientID int, step int64, at time.Time) error {
+	return r.update(clientID, func(m *models.MFASettings) {
+		m.Method, m.TOTPLastStep, m.FailedAttempts = "totp", &step, 0
+	})
+}
+
+func (r memoryMFA) EnableSMS(clientID int, at time.Time) error {
+	return r.update(clientID, func(m *models.MFASettings) {
+		m.Method, m.TOTPSecret, m.TOTPLastStep, m.FailedAttempts = "sms", "", nil, 0
+	})
+}
+
+func (r memoryMFA) Disable(clientID int, at time.Time) error {
+	return r.update(clientID, func(m *models.MFASettings) { m.Method, m.TOTPSecret, m.TOTPLastStep = "none", "", nil })
+}
+
+func (r memoryMFA) SetTOTPLastStep(clientID int, step int64) error {
+	return r.update(clientID, func(m *models.MFASettings) { m.TOTPLastStep = &step })
+}
+
+func (r memoryMFA) SetFailedAttempts(clientID, attempts int, lockedUntil *time.Time) error {
+	return r.update(clientID, func(m *models.MFASettings) { m.FailedAttempts, m.LockedUntil = attempts, lockedUntil })
+}
+
+func (r memoryMFA) ReplaceRecoveryCodes(clientID int, hashes []string) error {
+	if err := r.DeleteRecoveryCodes(clientID); err != nil {
+		return err
+	}
+	defer r.s.lock()()
+	d := r.s.data
+	for _, hash := range hashes {
+		d.recoveryCodes[d.nextRecoveryID] = models.RecoveryCode{ID: d.nextRecoveryID, ClientID: clientID, Hash: hash}
+		d.nextRecoveryID++
+	}
+	return nil
+}
+
+func (r memoryMFA) UnusedRecoveryCodes(clientID int) ([]models.RecoveryCode, error) {
+	defer r.s.lock()()
+	var codes []models.RecoveryCode
+	for _, code := range r.s.data.recoveryCodes {
+		if code.ClientID == clientID && code.UsedAt == nil {
+			codes = append(codes, code)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ID < codes[j].ID })
+	return codes, nil
+}
+
+func (r memoryMFA) UseRecoveryCode(id int, at time.Time) error {
+	defer r.s.lock()()
+	if code, ok := r.s.data.recoveryCodes[id]; ok {
+		code.UsedAt = &at
+		r.s.data.recoveryCodes[id] = code
+	}
+	return nil
+}
+
+func (r memoryMFA) CountRecoveryCodes(clientID int) (int, error) {
+	codes, err := r.UnusedRecoveryCodes(clientID)
+	return len(codes), err
+}
+
+func (r memoryMFA) DeleteRecoveryCodes(clientID int) error {
+	defer r.s.lock()()
+	for id, code := range r.s.data.recoveryCodes {
+		if code.ClientID == clientID {
+			delete(r.s.data.recoveryCodes, id)
+		}
+	}
+	return nil
+}
+
+type memoryOAuth struct {
+	s *MemoryStore
+}
+
+func (r memoryOAuth) CreateClient(client *models.OAuthClient) error {
+	defer r.s.lock()()
+	if _, ok := r.s.data.oauthClients[client.ClientID]; ok {
+		return ErrDuplicate
+	}
+	r.s.data.oauthClients[client.ClientID] = *client
+	return nil
+}
+
+func (r memoryOAuth) GetClient(clientID string) (*models.OAuthClient, error) {
+	defer r.s.lock()()
+	client, ok := r.s.data.oauthClients[clientID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &client, nil
+}
+
+func (r memoryOAuth) CreateCode(code *models.AuthorizationCode) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	code.ID = d.nextAuthCodeID
+	d.nextAuthCodeID++
+	d.authCodes[code.ID] = *code
+	return nil
+}
+
+func (r memoryOAuth) GetCodeForUpdate(hash string) (*models.AuthorizationCode, error) {
+	defer r.s.lock()()
+	for _, code := range r.s.data.authCodes {
+		if code.Hash == hash {
+			return &code, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryOAuth) MarkCodeUsed(id int, at time.Time) error {
+	defer r.s.lock()()
+	if code, ok := r.s.data.authCodes[id]; ok {
+		code.UsedAt = &at
+		r.s.data.authCodes[id] = code
+	}
+	return nil
+}
+
+type memoryWebAuthn struct {
+	s *MemoryStore
+}
+
+func (r memoryWebAuthn) CreatePasskey(passkey *models.Passkey) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	for _, existing := range d.passkeys {
+		if bytes.Equal(existing.CredentialID, passkey.CredentialID) {
+			return ErrDuplicate
+		}
+	}
+	passkey.ID = d.nextPasskeyID
+	d.nextPasskeyID++
+	d.passkeys[passkey.ID] = *passkey
+	return nil
+}
+
+func (r memoryWebAuthn) ListPasskeys(clientID int) ([]models.Passkey, error) {
+	defer r.s.lock()()
+	passkeys := []models.Passkey{}
+	for _, passkey := range r.s.data.passkeys {
+		if passkey.ClientID == clientID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool { return passkeys[i].ID < passkeys[j].ID })
+	return passkeys, nil
+}
+
+func (r memoryWebAuthn) GetPasskeyForUpdate(credentialID []byte) (*models.Passkey, error) {
+	defer r.s.lock()()
+	for _, passkey := range r.s.data.passkeys {
+		if bytes.Equal(passkey.CredentialID, credentialID) {
+			return &passkey, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r memoryWebAuthn) UpdateSignCount(id int, signCount uint32, at time.Time) error {
+	defer r.s.lock()()
+	if passkey, ok := r.s.data.passkeys[id]; ok {
+		passkey.SignCount, passkey.LastUsedAt = signCount, &at
+		r.s.data.passkeys[id] = passkey
+	}
+	return nil
+}
+
+func (r memoryWebAuthn) DeletePasskey(id, clientID int) (bool, error) {
+	defer r.s.lock()()
+	passkey, ok := r.s.data.passkeys[id]
+	if !ok || passkey.ClientID != clientID {
+		return false, nil
+	}
+	delete(r.s.data.passkeys, id)
+	return true, nil
+}
+
+func (r memoryWebAuthn) CreateChallenge(challenge *models.WebAuthnChallenge) error {
+	defer r.s.lock()()
+	d := r.s.data
+
+	challenge.ID = d.nextChallengeID
+	d.nextChallengeID++
+	d.challenges[challenge.ID] = *challenge
+	return nil
+}
+
+func (r memoryWebAuthn) PurgeChallenges(now time.Time) error {
+	defer r.s.lock()()
+	for id, challenge := range r.s.data.challenges {
+		if challenge.ExpiresAt.Before(now) {
+			delete(r.s.data.challenges, id)
+		}
+	}
+	return nil
+}
+
+func (r memoryWebAuthn) ConsumeChallenge(challenge, ceremony string) (*models.WebAuthnChallenge, error) {
+	defer r.s.lock()()
+	for id, c := range r.s.data.challenges {
+		if c.Challenge == challenge && c.Ceremony == ceremony {
+			delete(r.s.data.challenges, id)
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
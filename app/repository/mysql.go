@@ -0,0 +1,1617 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"strings"
+	"time"
+)
+
+// querier is a *sql.DB or a *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// MySQLStore implements Store on the service database
+type MySQLStore struct {
+	db *sql.DB // nil when the store is bound to a transaction
+	q  querier
+}
+
+// NewMySQLStore returns a store whose repositories run their statements on db
+func NewMySQLStore(db *sql.DB) *MySQLStore {
+	return &MySQLStore{db: db, q: db}
+}
+
+// InTx implements Store
+func (s *MySQLStore) InTx(fn func(store Store) error) error {
+	if s.db == nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&MySQLStore{q: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *MySQLStore) Clients() ClientRepository         { return mysqlClients{s.q} }
+func (s *MySQLStore) Agencies() AgencyRepository        { return mysqlAgencies{s.q} }
+func (s *MySQLStore) Landlords() LandlordRepository     { return mysqlLandlords{s.q} }
+func (s *MySQLStore) Users() UserRepository             { return mysqlUsers{s.q} }
+func (s *MySQLStore) Invitations() InvitationRepository { return mysqlInvitations{s.q} }
+func (s *MySQLStore) Roles() RoleRepository             { return mysqlRoles{s.q} }
+func (s *MySQLStore) OTPs() OTPRepository               { return mysqlOTPs{s.q} }
+func (s *MySQLStore) Templates() TemplateRepository     { return mysqlTemplates{s.q} }
+func (s *MySQLStore) Outbox() OutboxRepository          { return mysqlOutbox{s.q} }
+func (s *MySQLStore) Tokens() TokenRepository           { return mysqlTokens{s.q} }
+func (s *MySQLStore) MFA() MFARepository                { return mysqlMFA{s.q} }
+func (s *MySQLStore) OAuth() OAuthRepository            { return mysqlOAuth{s.q} }
+func (s *MySQLStore) WebAuthn() WebAuthnRepository      { return mysqlWebAuthn{s.q} }
+
+// Helper function to tell a unique key violation from other errors
+func isDuplicate(err error) bool {
+	return strings.Contains(err.Error(), "Duplicate entry")
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+type mysqlClients struct {
+	q querier
+}
+
+const clientColumns = `id, uuid, type, name, email, contact, password, is_verified, is_active, mfa_method, preferred_language, created_at, updated_at`
+
+func (r mysqlClients) Create(client *models.Client) error {
+	result, err := r.q.Exec(`
+        INSERT INTO clients (name, contact, email, password, type, uuid, preferred_language, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		client.Name, client.Contact, nullString(client.Email),
+		client.Password, client.Type, client.UUID,
+		client.PreferredLanguage, client.CreatedAt, client.UpdatedAt)
+	if err != nil {
+		if isDuplicate(err) {
+			return ErrDuplicate
+		}
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+	client.ID = int(id)
+	client.IsActive = true // the column default
+	return nil
+}
+
+func (r mysqlClients) GetByID(id int) (*models.Client, error) {
+	return r.get(`SELECT `+clientColumns+` FROM clients WHERE id = ?`, id)
+}
+
+func (r mysqlClients) GetByIDForUpdate(id int) (*models.Client, error) {
+	return r.get(`SELECT `+clientColumns+` FROM clients WHERE id = ? FOR UPDATE`, id)
+}
+
+func (r mysqlClients) GetByContact(contact string) (*models.Client, error) {
+	return r.get(`SELECT `+clientColumns+` FROM clients WHERE contact = ?`, contact)
+}
+
+func (r mysqlClients) GetByEmail(email string) (*models.Client, error) {
+	return r.get(`SELECT `+clientColumns+` FROM clients WHERE email = ?`, email)
+}
+
+func (r mysqlClients) get(query string, args ...interface{}) (*models.Client, error) {
+	var client models.Client
+	err := scanClient(r.q.QueryRow(query, args...), &client)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client: %v", err)
+	}
+	return &client, nil
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Helper function to scan clientColumns into client, followed by the extra columns of the query
+func scanClient(row scanner, client *models.Client, extra ...interface{}) error {
+	var name, email, password sql.NullString
+	dest := append([]interface{}{
+		&client.ID, &client.UUID, &client.Type, &name, &email, &client.Contact, &password,
+		&client.IsVerified, &client.IsActive, &client.MFAMethod, &client.PreferredLanguage,
+		&client.CreatedAt, &client.UpdatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	client.Name, client.Email, client.Password = name.String, email.String, password.String
+	return nil
+}
+
+func (r mysqlClients) SetVerified(id int, at time.Time) error {
+	if _, err := r.q.Exec(`UPDATE clients SET is_verified = 1, updated_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to verify client: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlClients) SetPassword(id int, hash string, at time.Time) error {
+	if _, err := r.q.Exec(`UPDATE clients SET password = ?, updated_at = ? WHERE id = ?`, hash, at, id); err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlClients) SetPreferredLanguage(id int, language string) error {
+	if _, err := r.q.Exec(`UPDATE clients SET preferred_language = ? WHERE id = ?`, language, id); err != nil {
+		return fmt.Errorf("failed to update preferred language: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlClients) SetActive(id int, active bool, at time.Time) error {
+	query := `UPDATE clients SET is_active = ?, updated_at = ? WHERE id = ?`
+	args := []interface{}{active, at, id}
+	if !active {
+		query = `UPDATE clients SET is_active = ?, updated_at = ?, tokens_invalid_before = ? WHERE id = ?`
+		args = []interface{}{active, at, tokenCutoff(at), id}
+	}
+	if _, err := r.q.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to update client status: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlClients) Delete(id int) error {
+	if _, err := r.q.Exec(`DELETE FROM clients WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete client: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlClients) Tenant(id int) (int, error) {
+	var tenantID int
+	err := r.q.QueryRow(`
+		SELECT COALESCE(l.agency_id, u.owner_id, c.id)
+		FROM clients c
+		LEFT JOIN landlords l ON l.id = c.id
+		LEFT JOIN users u ON u.id = c.id
+		WHERE c.id = ?
+	`, id).Scan(&tenantID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load tenant: %v", err)
+	}
+	return tenantID, nil
+}
+
+type mysqlAgencies struct {
+	q querier
+}
+
+func (r mysqlAgencies) Create(agency *models.Agency) error {
+	_, err := r.q.Exec(`
+        INSERT INTO agencies (id, name, address, tax_id, logo_url)
+        VALUES (?, ?, ?, ?, ?)`,
+		agency.ID, agency.Name, agency.Address, agency.TaxID, agency.LogoURL)
+	if err != nil {
+		if isDuplicate(err) {
+			return ErrDuplicate
+		}
+		return fmt.Errorf("failed to create agency: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlAgencies) Exists(id int) (bool, error) {
+	var count int
+	if err := r.q.QueryRow(`SELECT COUNT(*) FROM agencies WHERE id = ?`, id).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to load agency: %v", err)
+	}
+	return count > 0, nil
+}
+
+type mysqlLandlords struct {
+	q querier
+}
+
+func (r mysqlLandlords) Create(landlord *models.Landlord) error {
+	_, err := r.q.Exec(`
+        INSERT INTO landlords (id, name, address, agency_id)
+        VALUES (?, ?, ?, ?)`,
+		landlord.ID, landlord.Name, landlord.Address, landlord.AgencyID)
+	if err != nil {
+		return fmt.Errorf("failed to create landlord: %v", err)
+	}
+	return nil
+}
+
+var landlordColumns = "c." + strings.ReplaceAll(clientColumns, ", ", ", c.") + ", l.name, l.address, l.agency_id"
+
+// Helper function to scan landlordColumns
+func scanLandlord(row scanner) (*models.Landlord, error) {
+	var landlord models.Landlord
+	var address sql.NullString
+	var agencyID sql.NullInt64
+	if err := scanClient(row, &landlord.Client, &landlord.Name, &address, &agencyID); err != nil {
+		return nil, err
+	}
+	landlord.Address = address.String
+	landlord.AgencyID = intOrNil(agencyID)
+	return &landlord, nil
+}
+
+func (r mysqlLandlords) GetByID(id int) (*models.Landlord, error) {
+	landlord, err := scanLandlord(r.q.QueryRow(`
+		SELECT `+landlordColumns+`
+		FROM landlords l
+		JOIN clients c ON c.id = l.id
+		WHERE l.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load landlord: %v", err)
+	}
+	return landlord, nil
+}
+
+func (r mysqlLandlords) ListByAgency(agencyID int) ([]models.Landlord, error) {
+	rows, err := r.q.Query(`
+		SELECT `+landlordColumns+`
+		FROM landlords l
+		JOIN clients c ON c.id = l.id
+		WHERE l.agency_id = ?
+		ORDER BY c.created_at, c.id
+	`, agencyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load landlords: %v", err)
+	}
+	defer rows.Close()
+
+	landlords := []models.Landlord{}
+	for rows.Next() {
+		landlord, err := scanLandlord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read landlord: %v", err)
+		}
+		landlords = append(landlords, *landlord)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read landlords: %v", err)
+	}
+	return landlords, nil
+}
+
+func (r mysqlLandlords) Update(landlord *models.Landlord) error {
+	_, err := r.q.Exec(`UPDATE landlords SET name = ?, address = ? WHERE id = ?`,
+		landlord.Name, landlord.Address, landlord.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update landlord: %v", err)
+	}
+	_, err = r.q.Exec(`UPDATE clients SET name = ?, updated_at = ? WHERE id = ?`,
+		landlord.Client.Name, landlord.UpdatedAt, landlord.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update landlord: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlLandlords) SetAgency(id int, agencyID *int) error {
+	if _, err := r.q.Exec(`UPDATE landlords SET agency_id = ? WHERE id = ?`, agencyID, id); err != nil {
+		return fmt.Errorf("failed to update landlord: %v", err)
+	}
+	return nil
+}
+
+type mysqlUsers struct {
+	q querier
+}
+
+var userColumns = "c." + strings.ReplaceAll(clientColumns, ", ", ", c.") +
+	", u.first_name, u.last_name, u.position, u.owner_id, u.owner_type"
+
+func (r mysqlUsers) Create(user *models.User) error {
+	_, err := r.q.Exec(`
+        INSERT INTO users (id, first_name, last_name, position, owner_id, owner_type)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		user.ID, user.FirstName, user.LastName, nullString(user.Position), user.OwnerID, user.OwnerType)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %v", err)
+	}
+	return nil
+}
+
+// Helper function to scan userColumns
+func scanUser(row scanner) (*models.User, error) {
+	var user models.User
+	var position sql.NullString
+	err := scanClient(row, &user.Client, &user.FirstName, &user.LastName, &position, &user.OwnerID, &user.OwnerType)
+	if err != nil {
+		return nil, err
+	}
+	user.Position = position.String
+	return &user, nil
+}
+
+func (r mysqlUsers) GetByID(id int) (*models.User, error) {
+	user, err := scanUser(r.q.QueryRow(`
+		SELECT `+userColumns+`
+		FROM users u
+		JOIN clients c ON c.id = u.id
+		WHERE u.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %v", err)
+	}
+	return user, nil
+}
+
+func (r mysqlUsers) ListByOwner(ownerID int) ([]models.User, error) {
+	rows, err := r.q.Query(`
+		SELECT `+userColumns+`
+		FROM users u
+		JOIN clients c ON c.id = u.id
+		WHERE u.owner_id = ?
+		ORDER BY c.created_at, c.id
+	`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %v", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user: %v", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users: %v", err)
+	}
+	return users, nil
+}
+
+func (r mysqlUsers) Update(user *models.User) error {
+	_, err := r.q.Exec(`
+		UPDATE users SET first_name = ?, last_name = ?, position = ? WHERE id = ?
+	`, user.FirstName, user.LastName, nullString(user.Position), user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	_, err = r.q.Exec(`UPDATE clients SET name = ?, updated_at = ? WHERE id = ?`, user.Name, user.UpdatedAt, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	return nil
+}
+
+type mysqlInvitations struct {
+	q querier
+}
+
+const invitationColumns = `id, owner_id, owner_type, first_name, last_name, position, email, contact, channel,
+		preferred_language, token_id, expires_at, sent_at, accepted_at, revoked_at, user_id, created_at`
+
+func (r mysqlInvitations) Create(invitation *models.Invitation) error {
+	result, err := r.q.Exec(`
+        INSERT INTO invitations (owner_id, owner_type, first_name, last_name, position, email, contact, channel,
+                                 preferred_language, token_id, expires_at, sent_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invitation.OwnerID, invitation.OwnerType, invitation.FirstName, invitation.LastName,
+		nullString(invitation.Position), nullString(invitation.Email), nullString(invitation.Contact),
+		invitation.Channel, invitation.PreferredLanguage, invitation.TokenID,
+		invitation.ExpiresAt, invitation.SentAt, invitation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create invitation: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get invitation ID: %v", err)
+	}
+	invitation.ID = int(id)
+	return nil
+}
+
+// Helper function to scan invitationColumns
+func scanInvitation(row scanner) (*models.Invitation, error) {
+	var invitation models.Invitation
+	var position, email, contact sql.NullString
+	var acceptedAt, revokedAt sql.NullTime
+	var userID sql.NullInt64
+	err := row.Scan(&invitation.ID, &invitation.OwnerID, &invitation.OwnerType, &invitation.FirstName,
+		&invitation.LastName, &position, &email, &contact, &invitation.Channel, &invitation.PreferredLanguage,
+		&invitation.TokenID, &invitation.ExpiresAt, &invitation.SentAt, &acceptedAt, &revokedAt, &userID,
+		&invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	invitation.Position, invitation.Email, invitation.Contact = position.String, email.String, contact.String
+	if acceptedAt.Valid {
+		invitation.AcceptedAt = &acceptedAt.Time
+	}
+	if revokedAt.Valid {
+		invitation.RevokedAt = &revokedAt.Time
+	}
+	invitation.UserID = intOrNil(userID)
+	return &invitation, nil
+}
+
+func (r mysqlInvitations) GetByID(id int) (*models.Invitation, error) {
+	return r.get(`SELECT `+invitationColumns+` FROM invitations WHERE id = ?`, id)
+}
+
+func (r mysqlInvitations) GetByIDForUpdate(id int) (*models.Invitation, error) {
+	return r.get(`SELECT `+invitationColumns+` FROM invitations WHERE id = ? FOR UPDATE`, id)
+}
+
+func (r mysqlInvitations) get(query string, args ...interface{}) (*models.Invitation, error) {
+	invitation, err := scanInvitation(r.q.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invitation: %v", err)
+	}
+	return invitation, nil
+}
+
+func (r mysqlInvitations) ListPending(ownerID int) ([]models.Invitation, error) {
+	rows, err := r.q.Query(`
+		SELECT `+invitationColumns+`
+		FROM invitations
+		WHERE owner_id = ? AND accepted_at IS NULL AND revoked_at IS NULL
+		ORDER BY created_at, id
+	`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invitations: %v", err)
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read invitation: %v", err)
+		}
+		invitations = append(invitations, *invitation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read invitations: %v", err)
+	}
+	return invitations, nil
+}
+
+func (r mysqlInvitations) HasPending(ownerID int, email, contact string, now time.Time) (bool, error) {
+	var count int
+	err := r.q.QueryRow(`
+		SELECT COUNT(*) FROM invitations
+		WHERE owner_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+		  AND (email = ? OR contact = ?)
+	`, ownerID, now, nullString(email), nullString(contact)).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to load invitations: %v", err)
+	}
+	return count > 0, nil
+}
+
+func (r mysqlInvitations) SetToken(id int, tokenID string, expiresAt, sentAt time.Time) error {
+	_, err := r.q.Exec(`UPDATE invitations SET token_id = ?, expires_at = ?, sent_at = ? WHERE id = ?`,
+		tokenID, expiresAt, sentAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update invitation: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlInvitations) Revoke(id int, at time.Time) error {
+	if _, err := r.q.Exec(`UPDATE invitations SET revoked_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to revoke invitation: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlInvitations) MarkAccepted(id, userID int, at time.Time) error {
+	_, err := r.q.Exec(`UPDATE invitations SET accepted_at = ?, user_id = ? WHERE id = ?`, at, userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to accept invitation: %v", err)
+	}
+	return nil
+}
+
+type mysqlRoles struct {
+	q querier
+}
+
+// Helper function to build the placeholders of an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func (r mysqlRoles) Permissions() ([]models.Permission, error) {
+	return r.permissions(`SELECT id, name, description FROM permissions ORDER BY name`)
+}
+
+func (r mysqlRoles) PermissionsByName(names []string) ([]models.Permission, error) {
+	if len(names) == 0 {
+		return []models.Permission{}, nil
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	return r.permissions(`SELECT id, name, description FROM permissions WHERE name IN (`+placeholders(len(names))+`) ORDER BY name`, args...)
+}
+
+func (r mysqlRoles) permissions(query string, args ...interface{}) ([]models.Permission, error) {
+	rows, err := r.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load permissions: %v", err)
+	}
+	defer rows.Close()
+
+	permissions := []models.Permission{}
+	for rows.Next() {
+		var permission models.Permission
+		var description sql.NullString
+		if err := rows.Scan(&permission.ID, &permission.Name, &description); err != nil {
+			return nil, fmt.Errorf("failed to read permission: %v", err)
+		}
+		permission.Description = description.String
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read permissions: %v", err)
+	}
+	return permissions, nil
+}
+
+func (r mysqlRoles) Create(role *models.Role) error {
+	result, err := r.q.Exec(`
+        INSERT INTO roles (name, description, owner_id, owner_type, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		role.Name, nullString(role.Description), role.OwnerID, role.OwnerType, role.CreatedAt, role.UpdatedAt)
+	if err != nil {
+		if isDuplicate(err) {
+			return ErrDuplicate
+		}
+		return fmt.Errorf("failed to create role: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get role ID: %v", err)
+	}
+	role.ID = int(id)
+	return r.setPermissions(role.ID, role.Permissions)
+}
+
+// Helper function to replace the permissions of a role by name
+func (r mysqlRoles) setPermissions(roleID int, names []string) error {
+	if _, err := r.q.Exec(`DELETE FROM role_permissions WHERE role_id = ?`, roleID); err != nil {
+		return fmt.Errorf("failed to update role permissions: %v", err)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	args := []interface{}{roleID}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	_, err := r.q.Exec(`
+        INSERT INTO role_permissions (role_id, permission_id)
+        SELECT ?, id FROM permissions WHERE name IN (`+placeholders(len(names))+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update role permissions: %v", err)
+	}
+	return nil
+}
+
+const roleColumns = `r.id, r.name, r.description, r.owner_id, r.owner_type, r.created_at, r.updated_at`
+
+// Helper function to load roles and fill in their permissions
+func (r mysqlRoles) roles(query string, args ...interface{}) ([]models.Role, error) {
+	rows, err := r.q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load roles: %v", err)
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		var description sql.NullString
+		var ownerID sql.NullInt64
+		err := rows.Scan(&role.ID, &role.Name, &description, &ownerID, &role.OwnerType, &role.CreatedAt, &role.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read role: %v", err)
+		}
+		role.Description, role.OwnerID, role.Permissions = description.String, int(ownerID.Int64), []string{}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read roles: %v", err)
+	}
+	rows.Close()
+	if len(roles) == 0 {
+		return roles, nil
+	}
+
+	byID := make(map[int]*models.Role, len(roles))
+	args = make([]interface{}, len(roles))
+	for i := range roles {
+		byID[roles[i].ID] = &roles[i]
+		args[i] = roles[i].ID
+	}
+	permissionRows, err := r.q.Query(`
+		SELECT rp.role_id, p.name
+		FROM role_permissions rp
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE rp.role_id IN (`+placeholders(len(roles))+`)
+		ORDER BY p.name
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load role permissions: %v", err)
+	}
+	defer permissionRows.Close()
+
+	for permissionRows.Next() {
+		var roleID int
+		var name string
+		if err := permissionRows.Scan(&roleID, &name); err != nil {
+			return nil, fmt.Errorf("failed to read role permission: %v", err)
+		}
+		byID[roleID].Permissions = append(byID[roleID].Permissions, name)
+	}
+	if err := permissionRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read role permissions: %v", err)
+	}
+	return roles, nil
+}
+
+func (r mysqlRoles) GetByID(id int) (*models.Role, error) {
+	roles, err := r.roles(`SELECT `+roleColumns+` FROM roles r WHERE r.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, ErrNotFound
+	}
+	return &roles[0], nil
+}
+
+func (r mysqlRoles) ListByOwner(ownerID int) ([]models.Role, error) {
+	return r.roles(`SELECT `+roleColumns+` FROM roles r WHERE r.owner_id = ? ORDER BY r.name`, ownerID)
+}
+
+func (r mysqlRoles) Update(role *models.Role) error {
+	_, err := r.q.Exec(`UPDATE roles SET name = ?, description = ?, updated_at = ? WHERE id = ?`,
+		role.Name, nullString(role.Description), role.UpdatedAt, role.ID)
+	if err != nil {
+		if isDuplicate(err) {
+			return ErrDuplicate
+		}
+		return fmt.Errorf("failed to update role: %v", err)
+	}
+	return r.setPermissions(role.ID, role.Permissions)
+}
+
+func (r mysqlRoles) Delete(id int) error {
+	if _, err := r.q.Exec(`DELETE FROM roles WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete role: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlRoles) UserRoles(userID int) ([]models.Role, error) {
+	return r.roles(`
+		SELECT `+roleColumns+`
+		FROM roles r
+		JOIN user_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = ?
+		ORDER BY r.name
+	`, userID)
+}
+
+func (r mysqlRoles) SetUserRoles(userID int, roleIDs []int) error {
+	if _, err := r.q.Exec(`DELETE FROM user_roles WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to update user roles: %v", err)
+	}
+	for _, roleID := range roleIDs {
+		if _, err := r.q.Exec(`INSERT IGNORE INTO user_roles (user_id, role_id) VALUES (?, ?)`, userID, roleID); err != nil {
+			return fmt.Errorf("failed to update user roles: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r mysqlRoles) UserPermissions(userID int) ([]string, error) {
+	rows, err := r.q.Query(`
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ?
+		ORDER BY p.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user permissions: %v", err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read user permission: %v", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read user permissions: %v", err)
+	}
+	return names, nil
+}
+
+type mysqlOTPs struct {
+	q querier
+}
+
+func (r mysqlOTPs) Create(code *models.OTPCode) error {
+	result, err := r.q.Exec(`
+        INSERT INTO otp_codes (client_id, otp_hash, expires_at, purpose)
+        VALUES (?, ?, ?, ?)`,
+		code.ClientID, code.Hash, code.ExpiresAt, code.Purpose)
+	if err != nil {
+		return fmt.Errorf("failed to store OTP: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get OTP ID: %v", err)
+	}
+	code.ID = int(id)
+	return nil
+}
+
+func (r mysqlOTPs) LatestUnused(clientID int, purpose string) (*models.OTPCode, error) {
+	var code models.OTPCode
+	err := r.q.QueryRow(`
+		SELECT id, client_id, purpose, otp_hash, failed_attempts, used, expires_at, created_at
+		FROM otp_codes
+		WHERE client_id = ? AND purpose = ? AND used = FALSE
+		ORDER BY expires_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`, clientID, purpose).Scan(&code.ID, &code.ClientID, &code.Purpose, &code.Hash, &code.FailedAttempts, &code.Used, &code.ExpiresAt, &code.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OTP: %v", err)
+	}
+	return &code, nil
+}
+
+func (r mysqlOTPs) RecordFailure(id, failedAttempts int, burn bool) error {
+	_, err := r.q.Exec(`UPDATE otp_codes SET failed_attempts = ?, used = ? WHERE id = ?`, failedAttempts, burn, id)
+	if err != nil {
+		return fmt.Errorf("failed to record OTP attempt: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlOTPs) MarkUsed(id int) error {
+	if _, err := r.q.Exec(`UPDATE otp_codes SET used = TRUE WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to use OTP: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlOTPs) InvalidateUnused(clientID int, purpose string) error {
+	_, err := r.q.Exec(`
+		UPDATE otp_codes SET used = TRUE
+		WHERE client_id = ? AND purpose = ? AND used = FALSE
+	`, clientID, purpose)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate OTPs: %v", err)
+	}
+	return nil
+}
+
+// Ages are computed by the database, created_at is filled in by its clock
+func (r mysqlOTPs) SinceLast(clientID int, purpose string) (time.Duration, bool, error) {
+	var seconds sql.NullInt64
+	err := r.q.QueryRow(`
+		SELECT TIMESTAMPDIFF(SECOND, MAX(created_at), NOW())
+		FROM otp_codes WHERE client_id = ? AND purpose = ?
+	`, clientID, purpose).Scan(&seconds)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to load last OTP: %v", err)
+	}
+	return time.Duration(seconds.Int64) * time.Second, seconds.Valid, nil
+}
+
+func (r mysqlOTPs) SinceNth(clientID, n int, window time.Duration) (time.Duration, bool, error) {
+	var seconds int64
+	err := r.q.QueryRow(`
+		SELECT TIMESTAMPDIFF(SECOND, created_at, NOW())
+		FROM otp_codes
+		WHERE client_id = ? AND created_at > NOW() - INTERVAL ? SECOND
+		ORDER BY created_at DESC
+		LIMIT 1 OFFSET ?
+	`, clientID, int64(window.Seconds()), n-1).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to count OTPs: %v", err)
+	}
+	return time.Duration(seconds) * time.Second, true, nil
+}
+
+func (r mysqlOTPs) GetPolicyOverride(tenantID int, purpose string) (*models.OTPPolicyOverride, error) {
+	var length, ttl, attempts, cooldown sql.NullInt64
+	var alphabet sql.NullString
+	err := r.q.QueryRow(`
+		SELECT length, alphabet, ttl_seconds, max_attempts, resend_cooldown_seconds
+		FROM otp_policies WHERE tenant_id = ? AND purpose = ?
+	`, tenantID, purpose).Scan(&length, &alphabet, &ttl, &attempts, &cooldown)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OTP policy: %v", err)
+	}
+
+	override := models.OTPPolicyOverride{
+		TenantID:              tenantID,
+		Purpose:               purpose,
+		Length:                intOrNil(length),
+		TTLSeconds:            intOrNil(ttl),
+		MaxAttempts:           intOrNil(attempts),
+		ResendCooldownSeconds: intOrNil(cooldown),
+	}
+	if alphabet.Valid {
+		override.Alphabet = &alphabet.String
+	}
+	return &override, nil
+}
+
+func intOrNil(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
+
+func (r mysqlOTPs) SavePolicyOverride(o *models.OTPPolicyOverride) error {
+	_, err := r.q.Exec(`
+		INSERT INTO otp_policies (tenant_id, purpose, length, alphabet, ttl_seconds, max_attempts, resend_cooldown_seconds)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			length = VALUES(length),
+			alphabet = VALUES(alphabet),
+			ttl_seconds = VALUES(ttl_seconds),
+			max_attempts = VALUES(max_attempts),
+			resend_cooldown_seconds = VALUES(resend_cooldown_seconds)
+	`, o.TenantID, o.Purpose, o.Length, o.Alphabet, o.TTLSeconds, o.MaxAttempts, o.ResendCooldownSeconds)
+	if err != nil {
+		return fmt.Errorf("failed to save OTP policy: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlOTPs) DeletePolicyOverride(tenantID int, purpose string) (bool, error) {
+	result, err := r.q.Exec(`DELETE FROM otp_policies WHERE tenant_id = ? AND purpose = ?`, tenantID, purpose)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete OTP policy: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+type mysqlTemplates struct {
+	q querier
+}
+
+func (r mysqlTemplates) Get(tenantID int, kind, locale string) (*models.MessageTemplate, error) {
+	t := models.MessageTemplate{TenantID: tenantID, Type: kind, Locale: locale}
+	var updatedAt sql.NullTime
+	err := r.q.QueryRow(`
+		SELECT subject, body, updated_at FROM message_templates
+		WHERE tenant_id = ? AND type = ? AND locale = ?
+	`, tenantID, kind, locale).Scan(&t.Subject, &t.Body, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %v", err)
+	}
+	t.UpdatedAt = updatedAt.Time
+	return &t, nil
+}
+
+func (r mysqlTemplates) List(tenantID int) ([]models.MessageTemplate, error) {
+	rows, err := r.q.Query(`
+		SELECT type, locale, subject, body, updated_at
+		FROM message_templates
+		WHERE tenant_id = ?
+		ORDER BY type, locale
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %v", err)
+	}
+	defer rows.Close()
+
+	templates := []models.MessageTemplate{}
+	for rows.Next() {
+		t := models.MessageTemplate{TenantID: tenantID}
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&t.Type, &t.Locale, &t.Subject, &t.Body, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read template: %v", err)
+		}
+		t.UpdatedAt = updatedAt.Time
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read templates: %v", err)
+	}
+	return templates, nil
+}
+
+func (r mysqlTemplates) Save(t *models.MessageTemplate) error {
+	_, err := r.q.Exec(`
+		INSERT INTO message_templates (tenant_id, type, locale, subject, body)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE subject = VALUES(subject), body = VALUES(body)
+	`, t.TenantID, t.Type, t.Locale, t.Subject, t.Body)
+	if err != nil {
+		return fmt.Errorf("failed to save template: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlTemplates) Delete(tenantID int, kind, locale string) (bool, error) {
+	result, err := r.q.Exec(`
+		DELETE FROM message_templates WHERE tenant_id = ? AND type = ? AND locale = ?
+	`, tenantID, kind, locale)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete template: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+type mysqlOutbox struct {
+	q querier
+}
+
+func (r mysqlOutbox) Enqueue(m notify.Message) error {
+	_, err := r.q.Exec(`
+		INSERT INTO notification_outbox (channel, recipient, subject, message, fallback_channel, fallback_recipient, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, m.Channel, m.Recipient, nullString(m.Subject), m.Body, nullString(m.FallbackChannel), nullString(m.FallbackRecipient), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to queue %s message: %v", m.Channel, err)
+	}
+	return nil
+}
+
+func (r mysqlOutbox) Claim(now time.Time, limit int, lease time.Duration) ([]notify.OutboxMessage, error) {
+	rows, err := r.q.Query(`
+		SELECT id, channel, recipient, subject, message, fallback_channel, fallback_recipient, attempts
+		FROM notification_outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED
+	`, notify.StatusPending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load outbox: %v", err)
+	}
+
+	var messages []notify.OutboxMessage
+	for rows.Next() {
+		m := notify.OutboxMessage{Status: notify.StatusPending}
+		var subject, fallbackChannel, fallbackRecipient sql.NullString
+		if err := rows.Scan(&m.ID, &m.Channel, &m.Recipient, &subject, &m.Body, &fallbackChannel, &fallbackRecipient, &m.Attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read outbox message: %v", err)
+		}
+		m.Subject, m.FallbackChannel, m.FallbackRecipient = subject.String, fallbackChannel.String, fallbackRecipient.String
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %v", err)
+	}
+
+	for i := range messages {
+		messages[i].Attempts++
+		messages[i].NextAttemptAt = now.Add(lease)
+		_, err = r.q.Exec(`
+			UPDATE notification_outbox SET attempts = ?, next_attempt_at = ? WHERE id = ?
+		`, messages[i].Attempts, messages[i].NextAttemptAt, messages[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim outbox message: %v", err)
+		}
+	}
+	return messages, nil
+}
+
+func (r mysqlOutbox) MarkSent(id int64, channel string, at time.Time) error {
+	_, err := r.q.Exec(`
+		UPDATE notification_outbox SET status = ?, delivered_channel = ?, sent_at = ?, last_error = NULL, message = '' WHERE id = ?
+	`, notify.StatusSent, channel, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message as sent: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlOutbox) MarkFailed(id int64, lastError string, nextAttemptAt time.Time, dead bool) error {
+	query := `UPDATE notification_outbox SET status = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`
+	status := notify.StatusPending
+	if dead {
+		// Nothing sends a dead message, so its body and the code in it are dropped
+		query = `UPDATE notification_outbox SET status = ?, next_attempt_at = ?, last_error = ?, message = '' WHERE id = ?`
+		status = notify.StatusDead
+	}
+
+	if _, err := r.q.Exec(query, status, nextAttemptAt, lastError, id); err != nil {
+		return fmt.Errorf("failed to record outbox delivery failure: %v", err)
+	}
+	return nil
+}
+
+type mysqlTokens struct {
+	q querier
+}
+
+func (r mysqlTokens) CreateRefreshToken(token *models.RefreshToken) error {
+	result, err := r.q.Exec(`
+        INSERT INTO refresh_tokens (client_id, token_hash, family_id, scope, oauth_client_id, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.ClientID, token.Hash, token.FamilyID, nullString(token.Scope), nullString(token.OAuthClientID),
+		token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get refresh token ID: %v", err)
+	}
+	token.ID = int(id)
+	return nil
+}
+
+const refreshTokenColumns = `id, client_id, token_hash, family_id, scope, oauth_client_id, replaced_by, expires_at, revoked_at, created_at`
+
+func (r mysqlTokens) GetRefreshToken(hash string) (*models.RefreshToken, error) {
+	return r.getRefreshToken(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = ?`, hash)
+}
+
+func (r mysqlTokens) GetRefreshTokenForUpdate(hash string) (*models.RefreshToken, error) {
+	return r.getRefreshToken(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = ? FOR UPDATE`, hash)
+}
+
+func (r mysqlTokens) getRefreshToken(query string, args ...interface{}) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var scope, oauthClientID sql.NullString
+	var replacedBy sql.NullInt64
+	var revokedAt sql.NullTime
+	err := r.q.QueryRow(query, args...).Scan(&token.ID, &token.ClientID, &token.Hash, &token.FamilyID, &scope,
+		&oauthClientID, &replacedBy, &token.ExpiresAt, &revokedAt, &token.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load refresh token: %v", err)
+	}
+	token.Scope = scope.String
+	token.OAuthClientID = oauthClientID.String
+	token.ReplacedBy = intOrNil(replacedBy)
+	token.RevokedAt = timeOrNil(revokedAt)
+	return &token, nil
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (r mysqlTokens) MarkRefreshTokenReplaced(id, replacedBy int, at time.Time) error {
+	_, err := r.q.Exec(`UPDATE refresh_tokens SET revoked_at = ?, replaced_by = ? WHERE id = ?`, at, replacedBy, id)
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlTokens) RevokeRefreshFamily(familyID string, at time.Time) error {
+	_, err := r.q.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`, at, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token family: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlTokens) RevokeClientRefreshTokens(clientID int, at time.Time) error {
+	_, err := r.q.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE client_id = ? AND revoked_at IS NULL`, at, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlTokens) RevokeAccessToken(jti string, clientID int, expiresAt time.Time) error {
+	_, err := r.q.Exec(`
+        INSERT IGNORE INTO revoked_tokens (jti, client_id, expires_at)
+        VALUES (?, ?, ?)`,
+		jti, clientID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlTokens) IsAccessTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	if err := r.q.QueryRow(`SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)`, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	return revoked, nil
+}
+
+func (r mysqlTokens) PurgeRevokedAccessTokens(now time.Time) error {
+	if _, err := r.q.Exec(`DELETE FROM revoked_tokens WHERE expires_at < ?`, now); err != nil {
+		return fmt.Errorf("failed to purge revoked tokens: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlTokens) InvalidBefore(clientID int) (*time.Time, error) {
+	var invalidBefore sql.NullTime
+	err := r.q.QueryRow(`SELECT tokens_invalid_before FROM clients WHERE id = ?`, clientID).Scan(&invalidBefore)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	return timeOrNil(invalidBefore), nil
+}
+
+func (r mysqlTokens) SetInvalidBefore(clientID int, at time.Time) error {
+	if _, err := r.q.Exec(`UPDATE clients SET tokens_invalid_before = ? WHERE id = ?`, tokenCutoff(at), clientID); err != nil {
+		return fmt.Errorf("failed to revoke tokens: %v", err)
+	}
+	return nil
+}
+
+type mysqlMFA struct {
+	q querier
+}
+
+const mfaColumns = `mfa_method, totp_secret, totp_last_step, mfa_failed_attempts, mfa_locked_until`
+
+func (r mysqlMFA) Settings(clientID int) (*models.MFASettings, error) {
+	return r.settings(`SELECT `+mfaColumns+` FROM clients WHERE id = ?`, clientID)
+}
+
+func (r mysqlMFA) SettingsForUpdate(clientID int) (*models.MFASettings, error) {
+	return r.settings(`SELECT `+mfaColumns+` FROM clients WHERE id = ? FOR UPDATE`, clientID)
+}
+
+func (r mysqlMFA) settings(query string, args ...interface{}) (*models.MFASettings, error) {
+	var settings models.MFASettings
+	var secret sql.NullString
+	var lastStep sql.NullInt64
+	var lockedUntil sql.NullTime
+	err := r.q.QueryRow(query, args...).Scan(&settings.Method, &secret, &lastStep, &settings.FailedAttempts, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load two-factor settings: %v", err)
+	}
+	settings.TOTPSecret = secret.String
+	settings.LockedUntil = timeOrNil(lockedUntil)
+	if lastStep.Valid {
+		settings.TOTPLastStep = &lastStep.Int64
+	}
+	return &settings, nil
+}
+
+func (r mysqlMFA) SetTOTPSecret(clientID int, secret string, at time.Time) error {
+	_, err := r.q.Exec(`UPDATE clients SET totp_secret = ?, totp_last_step = NULL, updated_at = ? WHERE id = ?`, secret, at, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to store TOTP secret: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) EnableTOTP(clientID int, step int64, at time.Time) error {
+	_, err := r.q.Exec(`
+		UPDATE clients SET mfa_method = 'totp', totp_last_step = ?, mfa_failed_attempts = 0, updated_at = ?
+		WHERE id = ?
+	`, step, at, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor authentication: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) EnableSMS(clientID int, at time.Time) error {
+	_, err := r.q.Exec(`
+		UPDATE clients SET mfa_method = 'sms', totp_secret = NULL, totp_last_step = NULL, mfa_failed_attempts = 0, updated_at = ?
+		WHERE id = ?
+	`, at, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor authentication: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) Disable(clientID int, at time.Time) error {
+	_, err := r.q.Exec(`
+		UPDATE clients SET mfa_method = 'none', totp_secret = NULL, totp_last_step = NULL, updated_at = ?
+		WHERE id = ?
+	`, at, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) SetTOTPLastStep(clientID int, step int64) error {
+	if _, err := r.q.Exec(`UPDATE clients SET totp_last_step = ? WHERE id = ?`, step, clientID); err != nil {
+		return fmt.Errorf("failed to update TOTP step: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) SetFailedAttempts(clientID, attempts int, lockedUntil *time.Time) error {
+	_, err := r.q.Exec(`UPDATE clients SET mfa_failed_attempts = ?, mfa_locked_until = ? WHERE id = ?`, attempts, lockedUntil, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to update failed attempts: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) ReplaceRecoveryCodes(clientID int, hashes []string) error {
+	if err := r.DeleteRecoveryCodes(clientID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := r.q.Exec(`INSERT INTO mfa_recovery_codes (client_id, code_hash) VALUES (?, ?)`, clientID, hash); err != nil {
+			return fmt.Errorf("failed to store recovery code: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r mysqlMFA) UnusedRecoveryCodes(clientID int) ([]models.RecoveryCode, error) {
+	rows, err := r.q.Query(`
+		SELECT id, code_hash FROM mfa_recovery_codes
+		WHERE client_id = ? AND used_at IS NULL
+		FOR UPDATE
+	`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recovery codes: %v", err)
+	}
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+	for rows.Next() {
+		code := models.RecoveryCode{ClientID: clientID}
+		if err := rows.Scan(&code.ID, &code.Hash); err != nil {
+			return nil, fmt.Errorf("failed to read recovery code: %v", err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recovery codes: %v", err)
+	}
+	return codes, nil
+}
+
+func (r mysqlMFA) UseRecoveryCode(id int, at time.Time) error {
+	if _, err := r.q.Exec(`UPDATE mfa_recovery_codes SET used_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to use recovery code: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlMFA) CountRecoveryCodes(clientID int) (int, error) {
+	var remaining int
+	err := r.q.QueryRow(`SELECT COUNT(*) FROM mfa_recovery_codes WHERE client_id = ? AND used_at IS NULL`, clientID).Scan(&remaining)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %v", err)
+	}
+	return remaining, nil
+}
+
+func (r mysqlMFA) DeleteRecoveryCodes(clientID int) error {
+	if _, err := r.q.Exec(`DELETE FROM mfa_recovery_codes WHERE client_id = ?`, clientID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+	return nil
+}
+
+type mysqlOAuth struct {
+	q querier
+}
+
+func (r mysqlOAuth) CreateClient(client *models.OAuthClient) error {
+	_, err := r.q.Exec(`
+        INSERT INTO oauth_clients (client_id, secret_hash, name, service_name, redirect_uris, scopes, grant_types)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		client.ClientID, nullString(client.SecretHash), client.Name, nullString(client.ServiceName),
+		strings.Join(client.RedirectURIs, " "), strings.Join(client.Scopes, " "), strings.Join(client.GrantTypes, " "))
+	if err != nil {
+		if isDuplicate(err) {
+			return ErrDuplicate
+		}
+		return fmt.Errorf("failed to create oauth client: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlOAuth) GetClient(clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	var secretHash, serviceName sql.NullString
+	var redirectURIs, scopes, grantTypes string
+	err := r.q.QueryRow(`
+		SELECT client_id, secret_hash, name, service_name, redirect_uris, scopes, grant_types
+		FROM oauth_clients
+		WHERE client_id = ?
+	`, clientID).Scan(&client.ClientID, &secretHash, &client.Name, &serviceName, &redirectURIs, &scopes, &grantTypes)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load oauth client: %v", err)
+	}
+
+	client.SecretHash, client.ServiceName = secretHash.String, serviceName.String
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Scopes = strings.Fields(scopes)
+	client.GrantTypes = strings.Fields(grantTypes)
+	return &client, nil
+}
+
+func (r mysqlOAuth) CreateCode(code *models.AuthorizationCode) error {
+	result, err := r.q.Exec(`
+        INSERT INTO oauth_authorization_codes (code_hash, oauth_client_id, client_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		code.Hash, code.OAuthClientID, code.ClientID, code.RedirectURI, code.Scope,
+		nullString(code.Nonce), code.CodeChallenge, code.AuthTime, code.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store authorization code: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get authorization code ID: %v", err)
+	}
+	code.ID = int(id)
+	return nil
+}
+
+func (r mysqlOAuth) GetCodeForUpdate(hash string) (*models.AuthorizationCode, error) {
+	code := models.AuthorizationCode{Hash: hash}
+	var nonce sql.NullString
+	var usedAt sql.NullTime
+	err := r.q.QueryRow(`
+		SELECT id, oauth_client_id, client_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at, used_at
+		FROM oauth_authorization_codes
+		WHERE code_hash = ?
+		FOR UPDATE
+	`, hash).Scan(&code.ID, &code.OAuthClientID, &code.ClientID, &code.RedirectURI,
+		&code.Scope, &nonce, &code.CodeChallenge, &code.AuthTime, &code.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load authorization code: %v", err)
+	}
+	code.Nonce = nonce.String
+	code.UsedAt = timeOrNil(usedAt)
+	return &code, nil
+}
+
+func (r mysqlOAuth) MarkCodeUsed(id int, at time.Time) error {
+	if _, err := r.q.Exec(`UPDATE oauth_authorization_codes SET used_at = ? WHERE id = ?`, at, id); err != nil {
+		return fmt.Errorf("failed to redeem authorization code: %v", err)
+	}
+	return nil
+}
+
+type mysqlWebAuthn struct {
+	q querier
+}
+
+func (r mysqlWebAuthn) CreatePasskey(passkey *models.Passkey) error {
+	result, err := r.q.Exec(`
+		INSERT INTO webauthn_credentials (client_id, credential_id, public_key, sign_count, transports, backup_eligible, name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, passkey.ClientID, passkey.CredentialID, passkey.PublicKey, passkey.SignCount,
+		strings.Join(passkey.Transports, ","), passkey.BackupEligible, passkey.Name, passkey.CreatedAt)
+	if err != nil {
+		if isDuplicate(err) {
+			return ErrDuplicate
+		}
+		return fmt.Errorf("failed to store passkey: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get passkey ID: %v", err)
+	}
+	passkey.ID = int(id)
+	return nil
+}
+
+const passkeyColumns = `id, client_id, credential_id, public_key, sign_count, transports, backup_eligible, name, last_used_at, created_at`
+
+// Helper function to scan passkeyColumns
+func scanPasskey(row scanner) (*models.Passkey, error) {
+	var passkey models.Passkey
+	var transports, name sql.NullString
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&passkey.ID, &passkey.ClientID, &passkey.CredentialID, &passkey.PublicKey, &passkey.SignCount,
+		&transports, &passkey.BackupEligible, &name, &lastUsedAt, &passkey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if transports.String != "" {
+		passkey.Transports = strings.Split(transports.String, ",")
+	}
+	passkey.Name = name.String
+	passkey.LastUsedAt = timeOrNil(lastUsedAt)
+	return &passkey, nil
+}
+
+func (r mysqlWebAuthn) ListPasskeys(clientID int) ([]models.Passkey, error) {
+	rows, err := r.q.Query(`
+		SELECT `+passkeyColumns+`
+		FROM webauthn_credentials WHERE client_id = ?
+		ORDER BY created_at, id
+	`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load passkeys: %v", err)
+	}
+	defer rows.Close()
+
+	passkeys := []models.Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passkey: %v", err)
+		}
+		passkeys = append(passkeys, *passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read passkeys: %v", err)
+	}
+	return passkeys, nil
+}
+
+func (r mysqlWebAuthn) GetPasskeyForUpdate(credentialID []byte) (*models.Passkey, error) {
+	passkey, err := scanPasskey(r.q.QueryRow(`
+		SELECT `+passkeyColumns+`
+		FROM webauthn_credentials
+		WHERE credential_id = ?
+		FOR UPDATE
+	`, credentialID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load passkey: %v", err)
+	}
+	return passkey, nil
+}
+
+func (r mysqlWebAuthn) UpdateSignCount(id int, signCount uint32, at time.Time) error {
+	_, err := r.q.Exec(`UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ? WHERE id = ?`, signCount, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlWebAuthn) DeletePasskey(id, clientID int) (bool, error) {
+	result, err := r.q.Exec(`DELETE FROM webauthn_credentials WHERE id = ? AND client_id = ?`, id, clientID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete passkey: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (r mysqlWebAuthn) CreateChallenge(challenge *models.WebAuthnChallenge) error {
+	result, err := r.q.Exec(`
+		INSERT INTO webauthn_challenges (challenge, client_id, ceremony, expires_at)
+		VALUES (?, ?, ?, ?)
+	`, challenge.Challenge, challenge.ClientID, challenge.Ceremony, challenge.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to store challenge: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get challenge ID: %v", err)
+	}
+	challenge.ID = int(id)
+	return nil
+}
+
+func (r mysqlWebAuthn) PurgeChallenges(now time.Time) error {
+	if _, err := r.q.Exec(`DELETE FROM webauthn_challenges WHERE expires_at < ?`, now); err != nil {
+		return fmt.Errorf("failed to purge challenges: %v", err)
+	}
+	return nil
+}
+
+func (r mysqlWebAuthn) ConsumeChallenge(challenge, ceremony string) (*models.WebAuthnChallenge, error) {
+	c := models.WebAuthnChallenge{Challenge: challenge, Ceremony: ceremony}
+	var clientID sql.NullInt64
+	err := r.q.QueryRow(`
+		SELECT id, client_id, expires_at FROM webauthn_challenges
+		WHERE challenge = ? AND ceremony = ?
+	`, challenge, ceremony).Scan(&c.ID, &clientID, &c.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load challenge: %v", err)
+	}
+	c.ClientID = intOrNil(clientID)
+
+	result, err := r.q.Exec(`DELETE FROM webauthn_challenges WHERE id = ?`, c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use challenge: %v", err)
+	}
+	// Only one of two concurrent answers gets to delete it
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	return &c, nil
+}
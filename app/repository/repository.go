@@ -0,0 +1,267 @@
+// Package repository keeps the SQL out of the controllers. Every repository has a MySQL
+// implementation used by the service and an in-memory one for running handlers without a database.
+package repository
+
+import (
+	"errors"
+	"github.com/kimoresteve/identity-service/app/models"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("record not found")
+	ErrDuplicate = errors.New("record already exists")
+)
+
+// ClientRepository stores the base record of every identity
+type ClientRepository interface {
+	// Create inserts the client and sets its ID, ErrDuplicate when the email or contact is taken
+	Create(client *models.Client) error
+	GetByID(id int) (*models.Client, error)
+	// GetByIDForUpdate locks the client until the transaction ends
+	GetByIDForUpdate(id int) (*models.Client, error)
+	GetByContact(contact string) (*models.Client, error)
+	GetByEmail(email string) (*models.Client, error)
+	SetVerified(id int, at time.Time) error
+	SetPassword(id int, hash string, at time.Time) error
+	SetPreferredLanguage(id int, language string) error
+	// SetActive enables or disables sign in, disabling also invalidates the access tokens issued so far
+	SetActive(id int, active bool, at time.Time) error
+	// Delete removes the client with its profile, codes and tokens
+	Delete(id int) error
+	// Tenant returns the agency or landlord the client belongs to,
+	// agencies and independent landlords are their own tenant
+	Tenant(id int) (int, error)
+}
+
+// AgencyRepository stores agency profiles, the client record is created first
+type AgencyRepository interface {
+	Create(agency *models.Agency) error
+	Exists(id int) (bool, error)
+}
+
+// LandlordRepository stores landlord profiles, the client record is created first
+type LandlordRepository interface {
+	Create(landlord *models.Landlord) error
+	// GetByID returns the landlord with its client record
+	GetByID(id int) (*models.Landlord, error)
+	// ListByAgency returns the landlords an agency represents, oldest first
+	ListByAgency(agencyID int) ([]models.Landlord, error)
+	// Update saves the name and address, the client name follows them
+	Update(landlord *models.Landlord) error
+	// SetAgency changes the agency representing the landlord, nil makes it independent
+	SetAgency(id int, agencyID *int) error
+}
+
+// UserRepository stores the staff accounts of agencies and landlords, the client record is created first
+type UserRepository interface {
+	Create(user *models.User) error
+	// GetByID returns the user with its client record
+	GetByID(id int) (*models.User, error)
+	// ListByOwner returns the users of an agency or landlord, oldest first
+	ListByOwner(ownerID int) ([]models.User, error)
+	// Update saves the first name, last name and position, the client name follows them
+	Update(user *models.User) error
+}
+
+// InvitationRepository stores the staff invitations of agencies and landlords
+type InvitationRepository interface {
+	// Create inserts the invitation and sets its ID
+	Create(invitation *models.Invitation) error
+	GetByID(id int) (*models.Invitation, error)
+	// GetByIDForUpdate locks the invitation until the transaction ends
+	GetByIDForUpdate(id int) (*models.Invitation, error)
+	// ListPending returns the invitations of an owner that were neither accepted nor revoked, oldest first
+	ListPending(ownerID int) ([]models.Invitation, error)
+	// HasPending reports whether the owner has an unexpired pending invitation for the email or contact
+	HasPending(ownerID int, email, contact string, now time.Time) (bool, error)
+	// SetToken records the token last sent, the earlier ones stop working
+	SetToken(id int, tokenID string, expiresAt, sentAt time.Time) error
+	Revoke(id int, at time.Time) error
+	MarkAccepted(id, userID int, at time.Time) error
+}
+
+// RoleRepository stores the roles of agencies and landlords, the permission catalog and who has which role
+type RoleRepository interface {
+	// Permissions returns the permission catalog ordered by name
+	Permissions() ([]models.Permission, error)
+	// PermissionsByName returns the catalog entries of the names, unknown names are left out
+	PermissionsByName(names []string) ([]models.Permission, error)
+
+	// Create inserts the role with its permissions and sets its ID, ErrDuplicate when the owner has a role of that name
+	Create(role *models.Role) error
+	// GetByID returns the role with its permissions
+	GetByID(id int) (*models.Role, error)
+	// ListByOwner returns the roles of an agency or landlord with their permissions, ordered by name
+	ListByOwner(ownerID int) ([]models.Role, error)
+	// Update saves the name, description and permissions, ErrDuplicate when the name is taken
+	Update(role *models.Role) error
+	Delete(id int) error
+
+	// UserRoles returns the roles assigned to a user with their permissions, ordered by name
+	UserRoles(userID int) ([]models.Role, error)
+	// SetUserRoles replaces the roles assigned to a user
+	SetUserRoles(userID int, roleIDs []int) error
+	// UserPermissions returns the names of the permissions a user holds through its roles, ordered by name
+	UserPermissions(userID int) ([]string, error)
+}
+
+// OTPRepository stores one-time codes and the agencies' OTP policy overrides
+type OTPRepository interface {
+	// Create inserts the code and sets its ID
+	Create(code *models.OTPCode) error
+	// LatestUnused returns the newest unused code of a purpose, locked until the transaction ends
+	LatestUnused(clientID int, purpose string) (*models.OTPCode, error)
+	RecordFailure(id, failedAttempts int, burn bool) error
+	MarkUsed(id int) error
+	// InvalidateUnused marks every unused code of a purpose as used
+	InvalidateUnused(clientID int, purpose string) error
+	// SinceLast returns how long ago the last code of a purpose was created, false when there is none
+	SinceLast(clientID int, purpose string) (time.Duration, bool, error)
+	// SinceNth returns how long ago the n-th newest code created within window was created,
+	// over all purposes, false when fewer were created
+	SinceNth(clientID, n int, window time.Duration) (time.Duration, bool, error)
+
+	// GetPolicyOverride returns nil when the tenant has no override for the purpose
+	GetPolicyOverride(tenantID int, purpose string) (*models.OTPPolicyOverride, error)
+	SavePolicyOverride(override *models.OTPPolicyOverride) error
+	// DeletePolicyOverride reports whether there was an override to delete
+	DeletePolicyOverride(tenantID int, purpose string) (bool, error)
+}
+
+// TemplateRepository stores the tenants' message template overrides
+type TemplateRepository interface {
+	Get(tenantID int, kind, locale string) (*models.MessageTemplate, error)
+	List(tenantID int) ([]models.MessageTemplate, error)
+	Save(template *models.MessageTemplate) error
+	// Delete reports whether there was a template to delete
+	Delete(tenantID int, kind, locale string) (bool, error)
+}
+
+// OutboxRepository queues notifications, they are only delivered once the transaction commits
+type OutboxRepository interface {
+	Enqueue(message notify.Message) error
+	// Claim returns up to limit pending messages due at now, oldest first, counts the attempt and
+	// hides them from other dispatchers until the lease runs out. Run it in a transaction, the
+	// messages are locked until the claim is recorded and messages locked by others are skipped.
+	Claim(now time.Time, limit int, lease time.Duration) ([]notify.OutboxMessage, error)
+	// MarkSent records the channel that delivered the message and drops its body, it carries
+	// one-time codes in the clear
+	MarkSent(id int64, channel string, at time.Time) error
+	// MarkFailed schedules the next attempt, or dead-letters the message and drops its body
+	MarkFailed(id int64, lastError string, nextAttemptAt time.Time, dead bool) error
+}
+
+// TokenRepository stores refresh tokens, the access token denylist and the clients' access token cutoffs
+type TokenRepository interface {
+	// CreateRefreshToken inserts the token and sets its ID
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshToken(hash string) (*models.RefreshToken, error)
+	// GetRefreshTokenForUpdate locks the token until the transaction ends
+	GetRefreshTokenForUpdate(hash string) (*models.RefreshToken, error)
+	// MarkRefreshTokenReplaced revokes a rotated token and links it to the token it was rotated into
+	MarkRefreshTokenReplaced(id, replacedBy int, at time.Time) error
+	// RevokeRefreshFamily revokes the unrevoked tokens of a family
+	RevokeRefreshFamily(familyID string, at time.Time) error
+	// RevokeClientRefreshTokens revokes every unrevoked token of a client
+	RevokeClientRefreshTokens(clientID int, at time.Time) error
+
+	// RevokeAccessToken puts a jti on the denylist until the token expires, revoking it twice is fine
+	RevokeAccessToken(jti string, clientID int, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti string) (bool, error)
+	// PurgeRevokedAccessTokens drops the denylist entries of tokens that expired before now
+	PurgeRevokedAccessTokens(now time.Time) error
+
+	// InvalidBefore returns the cutoff of a client, its access tokens issued earlier are revoked.
+	// Nil when there is no cutoff.
+	InvalidBefore(clientID int) (*time.Time, error)
+	// SetInvalidBefore revokes the access tokens of a client issued before at
+	SetInvalidBefore(clientID int, at time.Time) error
+}
+
+// tokenCutoff returns the cutoff stored for at. Token iat has second precision, so the cutoff does too.
+func tokenCutoff(at time.Time) time.Time {
+	return at.Truncate(time.Second)
+}
+
+// MFARepository stores the second factor settings and recovery codes of clients
+type MFARepository interface {
+	Settings(clientID int) (*models.MFASettings, error)
+	// SettingsForUpdate locks the settings until the transaction ends
+	SettingsForUpdate(clientID int) (*models.MFASettings, error)
+	// SetTOTPSecret starts an enrollment, the method is only switched by EnableTOTP
+	SetTOTPSecret(clientID int, secret string, at time.Time) error
+	// EnableTOTP switches to the enrolled secret, step is the time step of the confirming code
+	EnableTOTP(clientID int, step int64, at time.Time) error
+	// EnableSMS switches to SMS codes, a pending TOTP enrollment is dropped
+	EnableSMS(clientID int, at time.Time) error
+	// Disable switches the second factor off and drops the TOTP secret
+	Disable(clientID int, at time.Time) error
+	SetTOTPLastStep(clientID int, step int64) error
+	// SetFailedAttempts records the failed verifications since the last successful one and
+	// until when verifications are refused, nil when they are not
+	SetFailedAttempts(clientID, attempts int, lockedUntil *time.Time) error
+
+	// ReplaceRecoveryCodes deletes the recovery codes of a client and stores the hashes as new ones
+	ReplaceRecoveryCodes(clientID int, hashes []string) error
+	// UnusedRecoveryCodes returns the unused recovery codes of a client, locked until the transaction ends
+	UnusedRecoveryCodes(clientID int) ([]models.RecoveryCode, error)
+	UseRecoveryCode(id int, at time.Time) error
+	CountRecoveryCodes(clientID int) (int, error)
+	DeleteRecoveryCodes(clientID int) error
+}
+
+// OAuthRepository stores relying parties, service accounts and the authorization codes issued to them
+type OAuthRepository interface {
+	CreateClient(client *models.OAuthClient) error
+	GetClient(clientID string) (*models.OAuthClient, error)
+	// CreateCode inserts the authorization code and sets its ID
+	CreateCode(code *models.AuthorizationCode) error
+	// GetCodeForUpdate locks the code until the transaction ends
+	GetCodeForUpdate(hash string) (*models.AuthorizationCode, error)
+	MarkCodeUsed(id int, at time.Time) error
+}
+
+// WebAuthnRepository stores passkeys and the challenges of ceremonies in progress
+type WebAuthnRepository interface {
+	// CreatePasskey inserts the passkey and sets its ID, ErrDuplicate when the credential is registered already
+	CreatePasskey(passkey *models.Passkey) error
+	// ListPasskeys returns the passkeys of a client, oldest first
+	ListPasskeys(clientID int) ([]models.Passkey, error)
+	// GetPasskeyForUpdate returns the passkey with the credential id, locked until the transaction ends
+	GetPasskeyForUpdate(credentialID []byte) (*models.Passkey, error)
+	// UpdateSignCount records a login with the passkey
+	UpdateSignCount(id int, signCount uint32, at time.Time) error
+	// DeletePasskey reports whether the client had a passkey with the id
+	DeletePasskey(id, clientID int) (bool, error)
+
+	// CreateChallenge inserts the challenge and sets its ID
+	CreateChallenge(challenge *models.WebAuthnChallenge) error
+	// PurgeChallenges drops the challenges that expired before now
+	PurgeChallenges(now time.Time) error
+	// ConsumeChallenge deletes the challenge of a ceremony and returns it. Of two concurrent
+	// calls for the same challenge only one gets it, the other gets ErrNotFound.
+	ConsumeChallenge(challenge, ceremony string) (*models.WebAuthnChallenge, error)
+}
+
+// Store hands out the repositories. The ones of the Store passed to an InTx function share a transaction.
+type Store interface {
+	Clients() ClientRepository
+	Agencies() AgencyRepository
+	Landlords() LandlordRepository
+	Users() UserRepository
+	Invitations() InvitationRepository
+	Roles() RoleRepository
+	OTPs() OTPRepository
+	Templates() TemplateRepository
+	Outbox() OutboxRepository
+	Tokens() TokenRepository
+	MFA() MFARepository
+	OAuth() OAuthRepository
+	WebAuthn() WebAuthnRepository
+
+	// InTx runs fn in a transaction that is committed when fn returns nil and rolled back otherwise.
+	// Calling InTx on a Store that is already in a transaction runs fn in that transaction.
+	InTx(fn func(store Store) error) error
+}
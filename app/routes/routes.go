@@ -35,6 +35,17 @@ func (a *App) setRoutes() {
 	a.Router.HandleFunc("/", a.Controller.Status).Methods("POST")
 	a.Router.HandleFunc("/", a.Controller.Status).Methods("GET")
 
+	//keys
+	a.Router.HandleFunc("/.well-known/jwks.json", a.Controller.JWKS).Methods("GET")
+
+	//oidc
+	a.Router.HandleFunc("/.well-known/openid-configuration", a.Controller.OpenIDConfiguration).Methods("GET")
+	a.Router.Handle("/oauth/authorize", middleware.OptionalJWTMiddleware(http.HandlerFunc(a.Controller.Authorize))).Methods("GET", "POST")
+	a.Router.HandleFunc("/oauth/token", a.Controller.Token).Methods("POST")
+	a.Router.HandleFunc("/oauth/introspect", a.Controller.Introspect).Methods("POST")
+	a.Router.HandleFunc("/oauth/revoke", a.Controller.Revoke).Methods("POST")
+	a.Router.Handle("/userinfo", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.UserInfo))).Methods("GET", "POST")
+
 	//auth
 
 	authRouter := a.Router.PathPrefix("/auth").Subrouter()
@@ -43,10 +54,78 @@ func (a *App) setRoutes() {
 
 	a.Router.Handle("/auth/get-token/{id}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.GenerateToken))).Methods("GET")
 	authRouter.HandleFunc("/register/agency", a.Controller.RegisterAgency).Methods("POST")
-	authRouter.HandleFunc("/register/agency/landlord", a.Controller.Login).Methods("POST")
+	authRouter.Handle("/register/agency/landlord", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.RegisterLandlordAgency))).Methods("POST")
 	authRouter.HandleFunc("/register/landlord", a.Controller.RegisterLandlord).Methods("POST")
 	a.Router.HandleFunc("/auth/login", a.Controller.Login).Methods("POST")
+	a.Router.HandleFunc("/auth/login/verify-2fa", a.Controller.VerifyTwoFactor).Methods("POST")
+	a.Router.HandleFunc("/auth/refresh", a.Controller.RefreshToken).Methods("POST")
+	a.Router.Handle("/auth/logout", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.Logout))).Methods("POST")
+	a.Router.Handle("/auth/logout-all", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.LogoutAll))).Methods("POST")
 	a.Router.HandleFunc("/auth/verify", a.Controller.Verify).Methods("POST")
+	a.Router.HandleFunc("/auth/resend-otp", a.Controller.ResendOTP).Methods("POST")
+	a.Router.Handle("/auth/preferred-language", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.SetPreferredLanguage))).Methods("PUT")
+
+	//message templates
+	a.Router.Handle("/auth/templates", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ListMessageTemplates))).Methods("GET")
+	a.Router.Handle("/auth/templates", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.SaveMessageTemplate))).Methods("PUT")
+	a.Router.Handle("/auth/templates/{type}/{locale}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.DeleteMessageTemplate))).Methods("DELETE")
+
+	//users, agencies and landlords hold every permission, their staff the ones of their roles
+	a.Router.Handle("/auth/users", middleware.RequirePermission("users:read")(http.HandlerFunc(a.Controller.ListUsers))).Methods("GET")
+	a.Router.Handle("/auth/users", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.AddUser))).Methods("POST")
+	a.Router.Handle("/auth/users/{id}", middleware.RequirePermission("users:read")(http.HandlerFunc(a.Controller.GetUser))).Methods("GET")
+	a.Router.Handle("/auth/users/{id}", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.UpdateUser))).Methods("PATCH")
+	a.Router.Handle("/auth/users/{id}", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.DeleteUser))).Methods("DELETE")
+	a.Router.Handle("/auth/users/{id}/deactivate", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.DeactivateUser))).Methods("POST")
+	a.Router.Handle("/auth/users/{id}/activate", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.ActivateUser))).Methods("POST")
+
+	//landlords
+	a.Router.Handle("/auth/landlords", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ListAgencyLandlords))).Methods("GET")
+	a.Router.Handle("/auth/landlords/{id}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.GetAgencyLandlord))).Methods("GET")
+	a.Router.Handle("/auth/landlords/{id}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.UpdateAgencyLandlord))).Methods("PATCH")
+	a.Router.Handle("/auth/landlords/{id}/deactivate", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.DeactivateAgencyLandlord))).Methods("POST")
+	a.Router.Handle("/auth/landlords/{id}/activate", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ActivateAgencyLandlord))).Methods("POST")
+	a.Router.Handle("/auth/landlords/{id}/release", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ReleaseAgencyLandlord))).Methods("POST")
+
+	//invitations
+	a.Router.Handle("/auth/invitations", middleware.RequirePermission("users:read")(http.HandlerFunc(a.Controller.ListInvitations))).Methods("GET")
+	a.Router.Handle("/auth/invitations", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.InviteUser))).Methods("POST")
+	a.Router.HandleFunc("/auth/invitations/accept", a.Controller.AcceptInvitation).Methods("POST")
+	a.Router.Handle("/auth/invitations/{id}/resend", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.ResendInvitation))).Methods("POST")
+	a.Router.Handle("/auth/invitations/{id}", middleware.RequirePermission("users:write")(http.HandlerFunc(a.Controller.RevokeInvitation))).Methods("DELETE")
+
+	//roles
+	a.Router.Handle("/auth/permissions", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ListPermissions))).Methods("GET")
+	a.Router.Handle("/auth/roles", middleware.RequirePermission("roles:read")(http.HandlerFunc(a.Controller.ListRoles))).Methods("GET")
+	a.Router.Handle("/auth/roles", middleware.RequirePermission("roles:write")(http.HandlerFunc(a.Controller.CreateRole))).Methods("POST")
+	a.Router.Handle("/auth/roles/{id}", middleware.RequirePermission("roles:read")(http.HandlerFunc(a.Controller.GetRole))).Methods("GET")
+	a.Router.Handle("/auth/roles/{id}", middleware.RequirePermission("roles:write")(http.HandlerFunc(a.Controller.UpdateRole))).Methods("PATCH")
+	a.Router.Handle("/auth/roles/{id}", middleware.RequirePermission("roles:write")(http.HandlerFunc(a.Controller.DeleteRole))).Methods("DELETE")
+	a.Router.Handle("/auth/users/{id}/roles", middleware.RequirePermission("roles:read")(http.HandlerFunc(a.Controller.GetUserRoles))).Methods("GET")
+	a.Router.Handle("/auth/users/{id}/roles", middleware.RequirePermission("roles:write")(http.HandlerFunc(a.Controller.SetUserRoles))).Methods("PUT")
+
+	//otp policy
+	a.Router.Handle("/auth/otp-policy", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.GetOTPPolicy))).Methods("GET")
+	a.Router.Handle("/auth/otp-policy/{purpose}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.SaveOTPPolicy))).Methods("PUT")
+	a.Router.Handle("/auth/otp-policy/{purpose}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.DeleteOTPPolicy))).Methods("DELETE")
+
+	//two-factor
+	a.Router.Handle("/auth/2fa/totp/enroll", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.EnrollTOTP))).Methods("POST")
+	a.Router.Handle("/auth/2fa/totp/confirm", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ConfirmTOTP))).Methods("POST")
+	a.Router.Handle("/auth/2fa/totp/disable", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.DisableTOTP))).Methods("POST")
+	a.Router.Handle("/auth/2fa/sms/enable", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.EnableSMSTwoFactor))).Methods("POST")
+	a.Router.Handle("/auth/2fa/sms/disable", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.DisableSMSTwoFactor))).Methods("POST")
+	a.Router.Handle("/auth/2fa/recovery-codes", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.RecoveryCodes))).Methods("GET")
+	a.Router.Handle("/auth/2fa/recovery-codes/regenerate", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.RegenerateRecoveryCodes))).Methods("POST")
+
+	//passkeys
+	a.Router.Handle("/auth/webauthn/register/begin", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.BeginPasskeyRegistration))).Methods("POST")
+	a.Router.Handle("/auth/webauthn/register/finish", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.FinishPasskeyRegistration))).Methods("POST")
+	a.Router.HandleFunc("/auth/webauthn/login/begin", a.Controller.BeginPasskeyLogin).Methods("POST")
+	a.Router.HandleFunc("/auth/webauthn/login/finish", a.Controller.FinishPasskeyLogin).Methods("POST")
+	a.Router.Handle("/auth/webauthn/credentials", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.ListPasskeys))).Methods("GET")
+	a.Router.Handle("/auth/webauthn/credentials/{id}", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.DeletePasskey))).Methods("DELETE")
+
 	a.Router.HandleFunc("/auth/forgot-password", a.Controller.ForgotPassword).Methods("POST")
 	a.Router.HandleFunc("/auth/reset-password", a.Controller.ResetPassword).Methods("POST")
 
@@ -57,8 +136,6 @@ func (a *App) setRoutes() {
 	//a.Router.HandleFunc("/auth/forgot", a.Controller.ForgotPassword).Methods("POST")
 	//a.Router.HandleFunc("/auth/resetPassword", a.Controller.ResetPassword).Methods("POST")
 	//
-	//a.Router.HandleFunc("/auth/resendOtp", a.Controller.ResendOTP).Methods("POST")
-	//
 	////landlords
 	//a.Router.Handle("/landlord/{landlordID}/units", middleware.JWTMiddleware(http.HandlerFunc(a.Controller.GetUnitsByLandlordID))).Methods("GET")
 	//
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTP purposes a policy can be set for
+var OTPPurposes = []string{"activation", "reset", "2fa"}
+
+// OTPDigits is the alphabet of numeric codes
+const OTPDigits = "0123456789"
+
+// Limits a policy has to stay within, whoever sets it
+const (
+	minOTPLength       = 4
+	maxOTPLength       = 12
+	maxOTPAlphabet     = 64
+	minOTPCombinations = 10000
+	minOTPTTL          = time.Minute
+	maxOTPTTL          = 24 * time.Hour
+	maxOTPAttempts     = 10
+	maxOTPCooldown     = time.Hour
+)
+
+// OTPPolicy describes the codes sent for one purpose
+type OTPPolicy struct {
+	Length         int           `json:"length"`
+	Alphabet       string        `json:"alphabet"`
+	TTL            time.Duration `json:"-"`
+	MaxAttempts    int           `json:"max_attempts"` // wrong guesses a code survives, it is burnt on the last one
+	ResendCooldown time.Duration `json:"-"`
+}
+
+// DefaultOTPPolicy is used for every purpose unless configured otherwise
+var DefaultOTPPolicy = OTPPolicy{
+	Length:         6,
+	Alphabet:       OTPDigits,
+	TTL:            15 * time.Minute,
+	MaxAttempts:    5,
+	ResendCooldown: time.Minute,
+}
+
+// Generate returns a new random code
+func (p OTPPolicy) Generate() (string, error) {
+	max := big.NewInt(int64(len(p.Alphabet)))
+	code := make([]byte, p.Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate OTP: %v", err)
+		}
+		code[i] = p.Alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Normalize prepares a code typed by a client for comparison. Codes from an alphabet
+// without lowercase letters are case insensitive.
+func (p OTPPolicy) Normalize(code string) string {
+	code = strings.TrimSpace(code)
+	if p.Alphabet == strings.ToUpper(p.Alphabet) {
+		code = strings.ToUpper(code)
+	}
+	return code
+}
+
+// Validate checks the policy is within the limits every policy has to keep
+func (p OTPPolicy) Validate() error {
+	if p.Length < minOTPLength || p.Length > maxOTPLength {
+		return fmt.Errorf("length must be between %d and %d", minOTPLength, maxOTPLength)
+	}
+	if len(p.Alphabet) < 2 || len(p.Alphabet) > maxOTPAlphabet {
+		return fmt.Errorf("alphabet must have between 2 and %d characters", maxOTPAlphabet)
+	}
+	seen := make(map[rune]bool, len(p.Alphabet))
+	for _, r := range p.Alphabet {
+		if r > 127 || !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z') {
+			return errors.New("alphabet may only contain letters and digits")
+		}
+		if seen[r] {
+			return fmt.Errorf("alphabet repeats %q", r)
+		}
+		seen[r] = true
+	}
+	if math.Pow(float64(len(p.Alphabet)), float64(p.Length)) < minOTPCombinations {
+		return fmt.Errorf("length and alphabet must allow at least %d codes", minOTPCombinations)
+	}
+	if p.TTL < minOTPTTL || p.TTL > maxOTPTTL {
+		return fmt.Errorf("ttl must be between %v and %v", minOTPTTL, maxOTPTTL)
+	}
+	if p.MaxAttempts < 1 || p.MaxAttempts > maxOTPAttempts {
+		return fmt.Errorf("max_attempts must be between 1 and %d", maxOTPAttempts)
+	}
+	if p.ResendCooldown < 0 || p.ResendCooldown > maxOTPCooldown {
+		return fmt.Errorf("resend_cooldown must be between 0 and %v", maxOTPCooldown)
+	}
+	return nil
+}
+
+// LoadOTPPoliciesFromEnv builds the policy of every purpose from DefaultOTPPolicy and the environment.
+// OTP_LENGTH, OTP_ALPHABET, OTP_TTL_SECONDS, OTP_MAX_ATTEMPTS and OTP_RESEND_COOLDOWN_SECONDS apply to
+// all purposes, the same settings with the purpose after OTP_ (OTP_RESET_TTL_SECONDS, OTP_2FA_LENGTH)
+// to one of them.
+func LoadOTPPoliciesFromEnv() (map[string]OTPPolicy, error) {
+	policies := make(map[string]OTPPolicy, len(OTPPurposes))
+	for _, purpose := range OTPPurposes {
+		policy := DefaultOTPPolicy
+		for _, prefix := range []string{"OTP_", "OTP_" + strings.ToUpper(purpose) + "_"} {
+			if err := policy.applyEnv(prefix); err != nil {
+				return nil, err
+			}
+		}
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("%s OTP policy: %v", purpose, err)
+		}
+		policies[purpose] = policy
+	}
+	return policies, nil
+}
+
+func (p *OTPPolicy) applyEnv(prefix string) error {
+	ints := []struct {
+		name string
+		set  func(int)
+	}{
+		{"LENGTH", func(n int) { p.Length = n }},
+		{"TTL_SECONDS", func(n int) { p.TTL = time.Duration(n) * time.Second }},
+		{"MAX_ATTEMPTS", func(n int) { p.MaxAttempts = n }},
+		{"RESEND_COOLDOWN_SECONDS", func(n int) { p.ResendCooldown = time.Duration(n) * time.Second }},
+	}
+	for _, setting := range ints {
+		value := os.Getenv(prefix + setting.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s%s: %v", prefix, setting.name, err)
+		}
+		setting.set(n)
+	}
+	if alphabet := os.Getenv(prefix + "ALPHABET"); alphabet != "" {
+		p.Alphabet = alphabet
+	}
+	return nil
+}
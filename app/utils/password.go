@@ -1,22 +1,36 @@
 package utils
 
 import (
-	"crypto/rand"
-	"fmt"
-	"math/big"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
 )
 
-func GenerateOTP() (string, error) {
-	// Set the maximum number (exclusive) - 1,000,000 for 6 digits
-	max := big.NewInt(1000000)
+// HashOTP computes the keyed hash an OTP is stored as. The client and purpose are part of the
+// hash, so a code can't be checked against another client's or purpose's row. The key is
+// OTP_HASH_KEY, falling back to JWT_SECRET.
+func HashOTP(clientID int, purpose, otp string) (string, error) {
+	key := os.Getenv("OTP_HASH_KEY")
+	if key == "" {
+		key = os.Getenv("JWT_SECRET")
+	}
+	if key == "" {
+		return "", errors.New("OTP_HASH_KEY is not set")
+	}
 
-	// Generate random number
-	n, err := rand.Int(rand.Reader, max)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.Itoa(clientID) + ":" + purpose + ":" + otp))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// CheckOTP compares an OTP with its stored hash in constant time
+func CheckOTP(hash string, clientID int, purpose, otp string) (bool, error) {
+	computed, err := HashOTP(clientID, purpose, otp)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate OTP: %v", err)
+		return false, err
 	}
-
-	// Format as 6-digit string with leading zeros
-	otp := fmt.Sprintf("%06d", n)
-	return otp, nil
+	return hmac.Equal([]byte(computed), []byte(hash)), nil
 }
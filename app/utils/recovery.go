@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Lowercase letters and digits without the easily confused 0/o, 1/l and i
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+const recoveryCodeLength = 10
+
+// GenerateRecoveryCodes creates n single-use codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		var b strings.Builder
+		for j := 0; j < recoveryCodeLength; j++ {
+			if j == recoveryCodeLength/2 {
+				b.WriteByte('-')
+			}
+			k, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate recovery code: %v", err)
+			}
+			b.WriteByte(recoveryCodeAlphabet[k.Int64()])
+		}
+		codes = append(codes, b.String())
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode strips the separator, spaces and case so codes are hashed and compared the same way they were typed
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
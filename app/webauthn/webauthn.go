@@ -0,0 +1,405 @@
+// Package webauthn implements the relying party side of the WebAuthn registration and
+// assertion ceremonies (https://www.w3.org/TR/webauthn-2/) for passkey login.
+// Attestation statements are not verified: credentials are created with attestation "none",
+// so any authenticator, including a software one, can register.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent      byte = 0x01
+	FlagUserVerified     byte = 0x04
+	FlagBackupEligible   byte = 0x08
+	FlagBackupState      byte = 0x10
+	FlagAttestedCredData byte = 0x40
+	FlagExtensionData    byte = 0x80
+)
+
+var (
+	ErrChallengeMismatch = errors.New("challenge does not match")
+	ErrOriginMismatch    = errors.New("origin is not allowed")
+	ErrRPIDMismatch      = errors.New("relying party id does not match")
+	ErrUserNotVerified   = errors.New("user was not verified by the authenticator")
+	ErrSignCount         = errors.New("signature counter did not increase, the credential may be cloned")
+)
+
+// Base64URL is binary data encoded as unpadded base64url in JSON, as WebAuthn clients send it
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("invalid base64url value: %v", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty describes this service to authenticators
+type RelyingParty struct {
+	ID      string   // effective domain credentials are scoped to, e.g. example.com
+	Name    string   // shown by the authenticator
+	Origins []string // origins the ceremonies may run on, e.g. https://app.example.com
+	Timeout time.Duration
+}
+
+// User is the account a credential is registered for
+type User struct {
+	ID          Base64URL `json:"id"` // user handle, must not contain personal information
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialDescriptor identifies a credential that may be used or must not be registered again
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions passed to navigator.credentials.create()
+type CreationOptions struct {
+	RP                     rpEntity               `json:"rp"`
+	User                   User                   `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions passed to navigator.credentials.get()
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the PublicKeyCredential returned by navigator.credentials.create()
+type RegistrationResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the PublicKeyCredential returned by navigator.credentials.get()
+type AssertionResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// ClientData is the collected client data the authenticator signed over
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	Transports     []string
+	BackupEligible bool
+}
+
+// AuthenticatorData is the parsed authenticator data of a ceremony
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte // only during registration
+	PublicKey    []byte // COSE_Key, only during registration
+}
+
+// GenerateChallenge creates a random ceremony challenge
+func GenerateChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+	return b, nil
+}
+
+// CreationOptions builds the options for registering a passkey for user. Credentials the
+// user already has are excluded so the same authenticator isn't registered twice.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]credentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, credentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	return CreationOptions{
+		RP:                 rpEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            rp.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds the options for logging in with a passkey. Without allowed credentials
+// the authenticator offers any discoverable credential it holds for this relying party.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// ParseClientData decodes clientDataJSON, callers use it to find the ceremony a response belongs to
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	var cd ClientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("invalid client data: %v", err)
+	}
+	return &cd, nil
+}
+
+// ChallengeBytes returns the decoded challenge of the client data
+func (cd *ClientData) ChallengeBytes() ([]byte, error) {
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: %v", err)
+	}
+	return challenge, nil
+}
+
+// VerifyRegistration checks the response to a registration ceremony started with challenge
+// and returns the new credential (WebAuthn §7.1).
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *RegistrationResponse) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, fmt.Errorf("unexpected credential type %q", resp.Type)
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	attestation, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid attestation object")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, authData.CredentialID) {
+		return nil, errors.New("credential id does not match the attested credential")
+	}
+	if _, err := ParsePublicKey(authData.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             authData.CredentialID,
+		PublicKey:      authData.PublicKey,
+		SignCount:      authData.SignCount,
+		Transports:     resp.Response.Transports,
+		BackupEligible: authData.Flags&FlagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response to an authentication ceremony started with challenge
+// against a stored credential and returns the new signature counter (WebAuthn §7.2).
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential *Credential, resp *AssertionResponse) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, fmt.Errorf("unexpected credential type %q", resp.Type)
+	}
+	if !bytes.Equal(resp.RawID, credential.ID) {
+		return 0, errors.New("credential id does not match")
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := ParseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.Verify(signed, resp.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Synced passkeys always report zero, a counter only means something once it moves
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+	return authData.SignCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	cd, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("unexpected client data type %q", cd.Type)
+	}
+
+	got, err := cd.ChallengeBytes()
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if cd.CrossOrigin {
+		return ErrOriginMismatch
+	}
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return ErrOriginMismatch
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *AuthenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+	if authData.Flags&FlagUserPresent == 0 {
+		return errors.New("user was not present")
+	}
+	if authData.Flags&FlagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+// ParseAuthenticatorData decodes authenticator data (WebAuthn §6.1)
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if authData.Flags&FlagAttestedCredData != 0 {
+		// aaguid (16) followed by the credential id length (2)
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("invalid credential id length")
+		}
+		authData.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %v", err)
+		}
+		authData.PublicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if authData.Flags&FlagExtensionData != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extension data: %v", err)
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after authenticator data")
+	}
+	return authData, nil
+}
@@ -0,0 +1,372 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testRP = &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://app.example.com"}}
+
+// cborMap keeps the pairs in order, so the encoding is deterministic
+type cborMap [][2]interface{}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+}
+
+// encodeCBOR encodes the few types authenticators send
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case int64:
+		return encodeCBOR(int(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair[0])...)
+			out = append(out, encodeCBOR(pair[1])...)
+		}
+		return out
+	default:
+		panic("unsupported CBOR test value")
+	}
+}
+
+// testAuthenticator is a software authenticator holding one credential
+type testAuthenticator struct {
+	credentialID []byte
+	publicKey    []byte // COSE_Key
+	sign         func(data []byte) []byte
+}
+
+func newES256Authenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAuthenticator{
+		credentialID: randomBytes(t, 16),
+		publicKey: encodeCBOR(cborMap{
+			{coseKty, coseKtyEC2},
+			{coseAlg, AlgES256},
+			{coseCrv, coseP256},
+			{coseX, key.X.FillBytes(make([]byte, 32))},
+			{coseY, key.Y.FillBytes(make([]byte, 32))},
+		}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		},
+	}
+}
+
+func newEdDSAAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAuthenticator{
+		credentialID: randomBytes(t, 16),
+		publicKey: encodeCBOR(cborMap{
+			{coseKty, coseKtyOKP},
+			{coseAlg, AlgEdDSA},
+			{coseCrv, coseEd},
+			{coseX, []byte(public)},
+		}),
+		sign: func(data []byte) []byte { return ed25519.Sign(private, data) },
+	}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// ceremony is what the browser and the authenticator put in a response, the defaults are valid
+type ceremony struct {
+	challenge []byte
+	origin    string
+	rpID      string
+	flags     byte
+	signCount uint32
+}
+
+func newCeremony(challenge []byte) ceremony {
+	return ceremony{
+		challenge: challenge,
+		origin:    "https://app.example.com",
+		rpID:      "example.com",
+		flags:     FlagUserPresent | FlagUserVerified,
+	}
+}
+
+func (c ceremony) clientDataJSON(t *testing.T, typ string) []byte {
+	t.Helper()
+	data, err := json.Marshal(ClientData{Type: typ, Challenge: base64.RawURLEncoding.EncodeToString(c.challenge), Origin: c.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *testAuthenticator) authenticatorData(c ceremony, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	flags := c.flags
+	if attested {
+		flags |= FlagAttestedCredData
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, c.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // aaguid
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.publicKey...)
+	}
+	return data
+}
+
+// register answers navigator.credentials.create() with attestation "none"
+func (a *testAuthenticator) register(t *testing.T, c ceremony) *RegistrationResponse {
+	t.Helper()
+
+	resp := &RegistrationResponse{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), RawID: a.credentialID, Type: "public-key"}
+	resp.Response.ClientDataJSON = c.clientDataJSON(t, "webauthn.create")
+	resp.Response.AttestationObject = encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authenticatorData(c, true)},
+	})
+	return resp
+}
+
+// assert answers navigator.credentials.get()
+func (a *testAuthenticator) assert(t *testing.T, c ceremony) *AssertionResponse {
+	t.Helper()
+
+	resp := &AssertionResponse{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), RawID: a.credentialID, Type: "public-key"}
+	resp.Response.ClientDataJSON = c.clientDataJSON(t, "webauthn.get")
+	resp.Response.AuthenticatorData = a.authenticatorData(c, false)
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	resp.Response.Signature = a.sign(append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...))
+	return resp
+}
+
+func TestCeremonies(t *testing.T) {
+	for name, newAuthenticator := range map[string]func(*testing.T) *testAuthenticator{
+		"ES256": newES256Authenticator,
+		"EdDSA": newEdDSAAuthenticator,
+	} {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newAuthenticator(t)
+
+			challenge := randomBytes(t, 32)
+			registration := newCeremony(challenge)
+			registration.flags |= FlagBackupEligible
+			credential, err := testRP.VerifyRegistration(challenge, authenticator.register(t, registration))
+			if err != nil {
+				t.Fatalf("registration failed: %v", err)
+			}
+			if !bytes.Equal(credential.ID, authenticator.credentialID) || !bytes.Equal(credential.PublicKey, authenticator.publicKey) {
+				t.Fatal("registered credential does not match the authenticator")
+			}
+			if !credential.BackupEligible {
+				t.Fatal("backup eligibility was not recorded")
+			}
+
+			challenge = randomBytes(t, 32)
+			login := newCeremony(challenge)
+			login.signCount = 1
+			signCount, err := testRP.VerifyAssertion(challenge, credential, authenticator.assert(t, login))
+			if err != nil {
+				t.Fatalf("assertion failed: %v", err)
+			}
+			if signCount != 1 {
+				t.Fatalf("sign count = %d, want 1", signCount)
+			}
+		})
+	}
+}
+
+func TestCeremonyMismatches(t *testing.T) {
+	authenticator := newES256Authenticator(t)
+	challenge := randomBytes(t, 32)
+	credential, err := testRP.VerifyRegistration(challenge, authenticator.register(t, newCeremony(challenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *ceremony)
+		want   error
+	}{
+		{"challenge", func(c *ceremony) { c.challenge = randomBytes(t, 32) }, ErrChallengeMismatch},
+		{"origin", func(c *ceremony) { c.origin = "https://evil.example.net" }, ErrOriginMismatch},
+		{"rpId", func(c *ceremony) { c.rpID = "evil.example.net" }, ErrRPIDMismatch},
+		{"user verification", func(c *ceremony) { c.flags &^= FlagUserVerified }, ErrUserNotVerified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCeremony(challenge)
+			tt.change(&c)
+
+			if _, err := testRP.VerifyRegistration(challenge, authenticator.register(t, c)); !errors.Is(err, tt.want) {
+				t.Errorf("registration error = %v, want %v", err, tt.want)
+			}
+			if _, err := testRP.VerifyAssertion(challenge, credential, authenticator.assert(t, c)); !errors.Is(err, tt.want) {
+				t.Errorf("assertion error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionSignature(t *testing.T) {
+	authenticator := newEdDSAAuthenticator(t)
+	challenge := randomBytes(t, 32)
+	credential, err := testRP.VerifyRegistration(challenge, authenticator.register(t, newCeremony(challenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature must be made with the registered key over this authenticator data
+	resp := authenticator.assert(t, newCeremony(challenge))
+	resp.Response.AuthenticatorData[32] |= FlagBackupState
+	if _, err := testRP.VerifyAssertion(challenge, credential, resp); err == nil {
+		t.Error("assertion over changed authenticator data was accepted")
+	}
+
+	other := newEdDSAAuthenticator(t)
+	other.credentialID = authenticator.credentialID
+	if _, err := testRP.VerifyAssertion(challenge, credential, other.assert(t, newCeremony(challenge))); err == nil {
+		t.Error("assertion signed by another key was accepted")
+	}
+}
+
+func TestSignCount(t *testing.T) {
+	authenticator := newES256Authenticator(t)
+	challenge := randomBytes(t, 32)
+	credential, err := testRP.VerifyRegistration(challenge, authenticator.register(t, newCeremony(challenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		stored, given uint32
+		want          error
+	}{
+		{"increased", 5, 6, nil},
+		{"repeated", 5, 5, ErrSignCount},
+		{"went back", 5, 3, ErrSignCount},
+		{"reset to zero", 5, 0, ErrSignCount},
+		{"always zero", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := *credential
+			stored.SignCount = tt.stored
+			c := newCeremony(challenge)
+			c.signCount = tt.given
+
+			signCount, err := testRP.VerifyAssertion(challenge, &stored, authenticator.assert(t, c))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if err == nil && signCount != tt.given {
+				t.Fatalf("sign count = %d, want %d", signCount, tt.given)
+			}
+		})
+	}
+}
+
+func TestTruncatedCBOR(t *testing.T) {
+	authenticator := newES256Authenticator(t)
+	challenge := randomBytes(t, 32)
+	resp := authenticator.register(t, newCeremony(challenge))
+
+	full := resp.Response.AttestationObject
+	for _, n := range []int{0, 1, len(full) / 2, len(full) - 1} {
+		if _, _, err := decodeCBOR(full[:n]); !errors.Is(err, errCBORTruncated) {
+			t.Errorf("decoding %d of %d bytes: error = %v, want %v", n, len(full), err, errCBORTruncated)
+		}
+	}
+
+	resp.Response.AttestationObject = full[:len(full)-1]
+	if _, err := testRP.VerifyRegistration(challenge, resp); err == nil {
+		t.Error("truncated attestation object was accepted")
+	}
+
+	// Lengths beyond the data are refused before anything is allocated for them
+	for _, data := range [][]byte{
+		cborHead(2, 1<<40),
+		cborHead(4, 1<<40),
+		cborHead(5, 1<<40),
+	} {
+		if _, _, err := decodeCBOR(data); !errors.Is(err, errCBORTruncated) {
+			t.Errorf("decoding %x: error = %v, want %v", data, err, errCBORTruncated)
+		}
+	}
+}
+
+func TestDeepCBOR(t *testing.T) {
+	// Arrays of one array, nested one level deeper than allowed
+	deep := append(bytes.Repeat([]byte{0x81}, maxCBORDepth+1), 0x00)
+	if _, _, err := decodeCBOR(deep); err == nil || !strings.Contains(err.Error(), "too deep") {
+		t.Fatalf("error = %v, want nesting too deep", err)
+	}
+	if _, _, err := decodeCBOR(deep[1:]); err != nil {
+		t.Fatalf("nesting at the limit was refused: %v", err)
+	}
+
+	authenticator := newES256Authenticator(t)
+	challenge := randomBytes(t, 32)
+	resp := authenticator.register(t, newCeremony(challenge))
+	resp.Response.AttestationObject = append(append(cborHead(5, 1), encodeCBOR("authData")...), deep...)
+	if _, err := testRP.VerifyRegistration(challenge, resp); err == nil {
+		t.Error("over-deep attestation object was accepted")
+	}
+}
@@ -1,21 +1,39 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/mysql"
 	_ "github.com/golang-migrate/migrate/v4/database/mysql" // MySQL driver
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/kimoresteve/identity-service/app/auth"
 	"github.com/kimoresteve/identity-service/app/controllers"
 	"github.com/kimoresteve/identity-service/app/database"
+	middleware "github.com/kimoresteve/identity-service/app/middlewares"
+	"github.com/kimoresteve/identity-service/app/notify"
+	"github.com/kimoresteve/identity-service/app/outbox"
+	"github.com/kimoresteve/identity-service/app/repository"
 	subroute "github.com/kimoresteve/identity-service/app/routes"
+	"github.com/kimoresteve/identity-service/app/utils"
 	_ "github.com/kimoresteve/identity-service/docs"
 	"log"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 func main() {
 
+	registerClient := flag.String("register-oauth-client", "", "register an OpenID Connect relying party with this name and exit")
+	redirectURIs := flag.String("redirect-uris", "", "space separated redirect URIs of the relying party")
+	publicClient := flag.Bool("public", false, "register a public relying party (PKCE only, no client secret)")
+	registerService := flag.String("register-service-client", "", "register a service account for the client_credentials grant and exit")
+	serviceScopes := flag.String("scopes", "", "space separated scopes the service account may request")
+	flag.Parse()
+
 	dbInstance := database.GetDBConnection()
 	driver, err := mysql.WithInstance(dbInstance, &mysql.Config{})
 	if err != nil {
@@ -46,12 +64,64 @@ func main() {
 
 	}
 
+	smsSender, err := notify.NewSMSSenderFromEnv()
+	if err != nil {
+		log.Fatalf("SMS setup error %s", err.Error())
+	}
+	emailSender, err := notify.NewEmailSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Email setup error %s", err.Error())
+	}
+
+	otpPolicies, err := utils.LoadOTPPoliciesFromEnv()
+	if err != nil {
+		log.Fatalf("OTP policy error %s", err.Error())
+	}
+
 	router := &subroute.App{}
 
+	store := repository.NewMySQLStore(dbInstance)
+	authService := &auth.Service{
+		Store:        store,
+		Templates:    notify.NewTemplateRegistry(),
+		OTPPolicies:  otpPolicies,
+		EmailEnabled: emailSender != nil,
+		InviteURL:    os.Getenv("INVITE_URL"),
+	}
+	middleware.SetRevocationStore(authService)
+	middleware.SetPermissionResolver(authService)
+
 	router.Controller = &controllers.Controller{
-		DB: dbInstance,
+		Store: store,
+		SMS:   smsSender,
+		Email: emailSender,
+		Auth:  authService,
+	}
+
+	if *registerClient != "" {
+		clientID, secret, err := router.Controller.RegisterOAuthClient(*registerClient, strings.Fields(*redirectURIs), *publicClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("client_id: %s\n", clientID)
+		if secret != "" {
+			fmt.Printf("client_secret: %s\n", secret)
+		}
+		os.Exit(0)
+	}
+
+	if *registerService != "" {
+		clientID, secret, err := router.Controller.RegisterServiceClient(*registerService, strings.Fields(*serviceScopes))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("client_id: %s\nclient_secret: %s\n", clientID, secret)
+		os.Exit(0)
 	}
 
+	// Deliver queued OTPs and notifications in the background
+	go outbox.NewDispatcher(store, smsSender, emailSender).Run(context.Background())
+
 	router.Initialize()
 	router.Run()
 
@@ -0,0 +1,14 @@
+-- Refresh tokens (opaque, only the hash is stored)
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    id          INT AUTO_INCREMENT PRIMARY KEY,
+    client_id   INT         NOT NULL,
+    token_hash  CHAR(64)    NOT NULL UNIQUE,
+    family_id   VARCHAR(64) NOT NULL, -- shared by every token rotated from the same login
+    replaced_by INT NULL,             -- token issued when this one was rotated
+    expires_at  TIMESTAMP   NOT NULL,
+    revoked_at  TIMESTAMP NULL,
+    created_at  DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_refresh_tokens_family (family_id),
+    FOREIGN KEY (client_id) REFERENCES clients (id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN tokens_invalid_before;
+DROP TABLE IF EXISTS revoked_tokens;
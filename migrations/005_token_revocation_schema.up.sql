@@ -0,0 +1,12 @@
+-- Revoked access tokens, rows can be purged once the token has expired
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti        VARCHAR(64) PRIMARY KEY,
+    client_id  INT         NOT NULL,
+    expires_at TIMESTAMP   NOT NULL,
+    revoked_at DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_revoked_tokens_expires (expires_at)
+);
+
+-- Tokens issued before this moment are rejected (logout from all devices)
+ALTER TABLE clients ADD COLUMN tokens_invalid_before TIMESTAMP NULL;
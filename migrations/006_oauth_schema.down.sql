@@ -0,0 +1,3 @@
+ALTER TABLE refresh_tokens DROP COLUMN scope;
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_clients;
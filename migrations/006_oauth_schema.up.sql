@@ -0,0 +1,32 @@
+-- OAuth/OIDC relying parties
+CREATE TABLE IF NOT EXISTS oauth_clients
+(
+    id            INT AUTO_INCREMENT PRIMARY KEY,
+    client_id     VARCHAR(64)  NOT NULL UNIQUE,
+    secret_hash   VARCHAR(255) NULL, -- NULL for public clients, they must use PKCE
+    name          VARCHAR(255) NOT NULL,
+    redirect_uris TEXT         NOT NULL, -- space separated, matched exactly
+    scopes        VARCHAR(255) NOT NULL DEFAULT 'openid profile email phone',
+    created_at    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Authorization codes (single use, only the hash is stored)
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes
+(
+    id              INT AUTO_INCREMENT PRIMARY KEY,
+    code_hash       CHAR(64)     NOT NULL UNIQUE,
+    oauth_client_id VARCHAR(64)  NOT NULL,
+    client_id       INT          NOT NULL, -- the signed in identity
+    redirect_uri    TEXT         NOT NULL,
+    scope           VARCHAR(255) NOT NULL,
+    nonce           VARCHAR(255) NULL,
+    code_challenge  VARCHAR(128) NOT NULL, -- PKCE S256
+    auth_time       DATETIME     NOT NULL,
+    expires_at      TIMESTAMP    NOT NULL,
+    used_at         TIMESTAMP NULL,
+    FOREIGN KEY (oauth_client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+    FOREIGN KEY (client_id) REFERENCES clients (id) ON DELETE CASCADE
+);
+
+-- Scope granted to the tokens of a refresh token family
+ALTER TABLE refresh_tokens ADD COLUMN scope VARCHAR(255) NULL AFTER family_id;
@@ -0,0 +1,3 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN grant_types,
+    DROP COLUMN service_name;
@@ -0,0 +1,4 @@
+-- Grants a client may use, service accounts use client_credentials
+ALTER TABLE oauth_clients
+    ADD COLUMN grant_types  VARCHAR(255) NOT NULL DEFAULT 'authorization_code refresh_token' AFTER scopes,
+    ADD COLUMN service_name VARCHAR(100) NULL AFTER name;
@@ -0,0 +1,5 @@
+ALTER TABLE clients
+    DROP COLUMN mfa_method,
+    DROP COLUMN totp_secret,
+    DROP COLUMN totp_last_step,
+    DROP COLUMN mfa_failed_attempts;
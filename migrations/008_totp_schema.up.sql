@@ -0,0 +1,6 @@
+-- Second factor settings, totp_secret without mfa_method 'totp' is a pending enrollment
+ALTER TABLE clients
+    ADD COLUMN mfa_method          ENUM('none', 'totp') NOT NULL DEFAULT 'none',
+    ADD COLUMN totp_secret         VARCHAR(64) NULL,
+    ADD COLUMN totp_last_step      BIGINT      NULL, -- last accepted time step, codes can't be replayed
+    ADD COLUMN mfa_failed_attempts INT         NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+UPDATE clients SET mfa_method = 'none' WHERE mfa_method = 'sms';
+ALTER TABLE clients MODIFY COLUMN mfa_method ENUM('none', 'totp') NOT NULL DEFAULT 'none';
@@ -0,0 +1,2 @@
+-- SMS codes as a second factor, codes are stored in otp_codes with purpose '2fa'
+ALTER TABLE clients MODIFY COLUMN mfa_method ENUM('none', 'totp', 'sms') NOT NULL DEFAULT 'none';
@@ -0,0 +1,11 @@
+-- Single-use recovery codes for clients locked out of their second factor (only the bcrypt hash is stored)
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    client_id  INT          NOT NULL,
+    code_hash  VARCHAR(255) NOT NULL,
+    used_at    TIMESTAMP NULL,
+    created_at DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_mfa_recovery_codes_client (client_id, used_at),
+    FOREIGN KEY (client_id) REFERENCES clients (id) ON DELETE CASCADE
+);
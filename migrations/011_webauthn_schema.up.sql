@@ -0,0 +1,28 @@
+-- Passkeys registered by clients
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id              INT AUTO_INCREMENT PRIMARY KEY,
+    client_id       INT             NOT NULL,
+    credential_id   VARBINARY(1023) NOT NULL,
+    public_key      BLOB            NOT NULL, -- COSE_Key
+    sign_count      INT UNSIGNED    NOT NULL DEFAULT 0,
+    transports      VARCHAR(255) NULL,        -- comma separated hints for the browser
+    backup_eligible BOOLEAN         NOT NULL DEFAULT FALSE,
+    name            VARCHAR(100) NULL,
+    last_used_at    TIMESTAMP NULL,
+    created_at      DATETIME        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_webauthn_credentials_credential (credential_id),
+    FOREIGN KEY (client_id) REFERENCES clients (id) ON DELETE CASCADE
+);
+
+-- Outstanding registration and login ceremonies, each challenge can be answered once
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    challenge  VARCHAR(64)                     NOT NULL UNIQUE, -- base64url
+    client_id  INT NULL,                                        -- unknown for passwordless login
+    ceremony   ENUM ('registration', 'login')  NOT NULL,
+    expires_at TIMESTAMP                       NOT NULL,
+    created_at DATETIME                        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (client_id) REFERENCES clients (id) ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+-- Messages written in the same transaction as the change that triggers them and delivered by the dispatcher
+CREATE TABLE IF NOT EXISTS notification_outbox
+(
+    id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+    channel         ENUM ('sms')                    NOT NULL,
+    recipient       VARCHAR(255)                    NOT NULL,
+    message         TEXT                            NOT NULL,
+    status          ENUM ('pending', 'sent', 'dead') NOT NULL DEFAULT 'pending',
+    attempts        INT                             NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP                       NOT NULL DEFAULT CURRENT_TIMESTAMP, -- also the lease of a claimed message
+    last_error      TEXT NULL,
+    sent_at         TIMESTAMP NULL,
+    created_at      DATETIME                        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at      DATETIME                        NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_notification_outbox_due (status, next_attempt_at)
+);
@@ -0,0 +1,7 @@
+DELETE FROM notification_outbox WHERE channel = 'email';
+ALTER TABLE notification_outbox
+    DROP COLUMN delivered_channel,
+    DROP COLUMN fallback_recipient,
+    DROP COLUMN fallback_channel,
+    DROP COLUMN subject,
+    MODIFY COLUMN channel ENUM ('sms') NOT NULL;
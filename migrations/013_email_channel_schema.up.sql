@@ -0,0 +1,7 @@
+-- Email as a delivery channel, messages fall back to the other channel when theirs fails
+ALTER TABLE notification_outbox
+    MODIFY COLUMN channel ENUM ('sms', 'email') NOT NULL,
+    ADD COLUMN subject            VARCHAR(255) NULL AFTER recipient,
+    ADD COLUMN fallback_channel   ENUM ('sms', 'email') NULL AFTER message,
+    ADD COLUMN fallback_recipient VARCHAR(255) NULL AFTER fallback_channel,
+    ADD COLUMN delivered_channel  ENUM ('sms', 'email') NULL AFTER status;
@@ -0,0 +1,7 @@
+DELETE FROM otp_codes;
+ALTER TABLE otp_codes
+    DROP INDEX idx_otp_codes_client_purpose,
+    DROP COLUMN failed_attempts,
+    DROP COLUMN otp_hash,
+    ADD COLUMN otp VARCHAR(10) NOT NULL AFTER client_id,
+    MODIFY COLUMN used BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,8 @@
+-- OTPs are stored as keyed hashes. Outstanding plaintext codes can't be converted and are dropped, clients request new ones.
+DELETE FROM otp_codes;
+ALTER TABLE otp_codes
+    DROP COLUMN otp,
+    ADD COLUMN otp_hash        CHAR(64) NOT NULL AFTER client_id,
+    ADD COLUMN failed_attempts INT      NOT NULL DEFAULT 0, -- the code is burnt after too many
+    MODIFY COLUMN used BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD INDEX idx_otp_codes_client_purpose (client_id, purpose, used);
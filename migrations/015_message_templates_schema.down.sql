@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_templates;
+ALTER TABLE clients DROP COLUMN preferred_language;
@@ -0,0 +1,18 @@
+-- Language notifications are sent in
+ALTER TABLE clients
+    ADD COLUMN preferred_language VARCHAR(10) NOT NULL DEFAULT 'en';
+
+-- Agencies and landlords can override the built in message templates for the clients they own
+CREATE TABLE IF NOT EXISTS message_templates
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    tenant_id  INT           NOT NULL, -- agency or landlord client
+    type       VARCHAR(50)   NOT NULL,
+    locale     VARCHAR(10)   NOT NULL,
+    subject    VARCHAR(255)  NOT NULL DEFAULT '',
+    body       VARCHAR(2000) NOT NULL, -- text/template source
+    created_at DATETIME      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP     DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_message_templates_tenant (tenant_id, type, locale),
+    FOREIGN KEY (tenant_id) REFERENCES clients (id) ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+-- Agency overrides of the configured OTP policy for the clients they own, NULL keeps the configured value
+CREATE TABLE IF NOT EXISTS otp_policies
+(
+    id                      INT AUTO_INCREMENT PRIMARY KEY,
+    tenant_id               INT                                 NOT NULL,
+    purpose                 ENUM ('activation', 'reset', '2fa') NOT NULL,
+    length                  INT NULL,
+    alphabet                VARCHAR(64) NULL,
+    ttl_seconds             INT NULL,
+    max_attempts            INT NULL,
+    resend_cooldown_seconds INT NULL,
+    created_at              DATETIME                            NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at              TIMESTAMP                           DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_otp_policies_tenant (tenant_id, purpose),
+    FOREIGN KEY (tenant_id) REFERENCES clients (id) ON DELETE CASCADE
+);
@@ -0,0 +1,24 @@
+-- Staff invitations of agencies and landlords, the invitee picks a password when accepting
+CREATE TABLE IF NOT EXISTS invitations
+(
+    id                 INT AUTO_INCREMENT PRIMARY KEY,
+    owner_id           INT                        NOT NULL, -- agency or landlord client
+    owner_type         ENUM ('agency', 'landlord') NOT NULL,
+    first_name         VARCHAR(100)               NOT NULL,
+    last_name          VARCHAR(100)               NOT NULL,
+    position           VARCHAR(100),
+    email              VARCHAR(255),
+    contact            VARCHAR(50),
+    channel            ENUM ('sms', 'email')      NOT NULL,
+    preferred_language VARCHAR(10)                NOT NULL DEFAULT 'en',
+    token_id           VARCHAR(64)                NOT NULL DEFAULT '', -- jti of the last token sent, earlier ones stop working
+    expires_at         DATETIME                   NOT NULL,
+    sent_at            DATETIME                   NOT NULL,
+    accepted_at        DATETIME NULL,
+    revoked_at         DATETIME NULL,
+    user_id            INT NULL,
+    created_at         DATETIME                   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_invitations_owner (owner_id, accepted_at, revoked_at),
+    FOREIGN KEY (owner_id) REFERENCES clients (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES clients (id) ON DELETE SET NULL
+);
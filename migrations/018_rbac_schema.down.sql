@@ -0,0 +1,10 @@
+DELETE FROM permissions
+WHERE name IN ('properties:read', 'properties:write', 'units:read', 'units:write', 'tenants:read', 'tenants:write',
+               'leases:read', 'leases:write', 'payments:read', 'payments:write', 'maintenance:read',
+               'maintenance:write', 'reports:read', 'users:read', 'users:write', 'roles:read', 'roles:write');
+ALTER TABLE roles
+    DROP FOREIGN KEY fk_roles_owner,
+    DROP INDEX uq_roles_owner_name,
+    DROP COLUMN updated_at,
+    DROP COLUMN created_at,
+    DROP COLUMN description;
@@ -0,0 +1,27 @@
+-- Agencies and landlords define their own roles, names are unique per owner
+ALTER TABLE roles
+    ADD COLUMN description VARCHAR(255) NULL AFTER name,
+    ADD COLUMN created_at  DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN updated_at  TIMESTAMP    DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    ADD UNIQUE KEY uq_roles_owner_name (owner_id, name),
+    ADD CONSTRAINT fk_roles_owner FOREIGN KEY (owner_id) REFERENCES clients (id) ON DELETE CASCADE;
+
+-- System-wide permission catalog roles are built from, names are resource:action
+INSERT IGNORE INTO permissions (name, description)
+VALUES ('properties:read', 'View properties'),
+       ('properties:write', 'Create, update and delete properties'),
+       ('units:read', 'View units'),
+       ('units:write', 'Create, update and delete units'),
+       ('tenants:read', 'View tenants'),
+       ('tenants:write', 'Create, update and delete tenants'),
+       ('leases:read', 'View leases'),
+       ('leases:write', 'Create, renew and terminate leases'),
+       ('payments:read', 'View payments and invoices'),
+       ('payments:write', 'Record payments and issue invoices'),
+       ('maintenance:read', 'View maintenance requests'),
+       ('maintenance:write', 'Create and update maintenance requests'),
+       ('reports:read', 'View reports'),
+       ('users:read', 'View staff accounts and invitations'),
+       ('users:write', 'Invite, update and deactivate staff accounts'),
+       ('roles:read', 'View roles and their permissions'),
+       ('roles:write', 'Create roles, change their permissions and assign them');
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP FOREIGN KEY fk_refresh_tokens_oauth_client;
+ALTER TABLE refresh_tokens DROP COLUMN oauth_client_id;
@@ -0,0 +1,4 @@
+-- Relying party a refresh token family was issued to, NULL for first-party logins
+ALTER TABLE refresh_tokens ADD COLUMN oauth_client_id VARCHAR(64) NULL AFTER scope;
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT fk_refresh_tokens_oauth_client FOREIGN KEY (oauth_client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN mfa_locked_until;
@@ -0,0 +1,2 @@
+-- Second factor attempts are refused until then, set once mfa_failed_attempts reaches the limit
+ALTER TABLE clients ADD COLUMN mfa_locked_until DATETIME NULL AFTER mfa_failed_attempts;